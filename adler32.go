@@ -8,4 +8,26 @@ import (
 
 func lzham_adler32(buf *bytes.Buffer) uint32 {
 	return adler32.Checksum(buf.Bytes())
-}
\ No newline at end of file
+}
+
+// lzham_adler32_update continues the adler32 adler with buf. A new checksum starts at 1.
+func lzham_adler32_update(adler uint32, buf []byte) uint32 {
+	const mod = 65521
+	const nmax = 5552 // The most bytes that can be summed before s2 could overflow.
+
+	s1, s2 := adler&0xFFFF, adler>>16
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > nmax {
+			n = nmax
+		}
+		for _, c := range buf[:n] {
+			s1 += uint32(c)
+			s2 += s1
+		}
+		s1 %= mod
+		s2 %= mod
+		buf = buf[n:]
+	}
+	return s2<<16 | s1
+}
@@ -25,3 +25,25 @@ func Test_lzham_adler32(t *testing.T) {
 		})
 	}
 }
+
+func Test_lzham_adler32_update(t *testing.T) {
+	data := gen_test_data(1, 100000)
+	for i := range data[:20000] {
+		data[i] = 0xFF
+	}
+
+	want := lzham_adler32(bytes.NewBuffer(data))
+	for _, chunk := range []int{1, 7, 5552, 65536, len(data)} {
+		adler := uint32(1)
+		for ofs := 0; ofs < len(data); ofs += chunk {
+			end := ofs + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			adler = lzham_adler32_update(adler, data[ofs:end])
+		}
+		if adler != want {
+			t.Errorf("%d byte chunks: got %#x, want %#x", chunk, adler, want)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package lzham
 
+import "math/bits"
+
 type lzham_flush_t int8
 
 const (
@@ -14,6 +16,9 @@ const (
 	LZHAM_MIN_DICT_SIZE_LOG2     = 15
 	LZHAM_MAX_DICT_SIZE_LOG2_X86 = 26
 	LZHAM_MAX_DICT_SIZE_LOG2_X64 = 29
+
+	// LZHAM_MAX_DICT_SIZE_LOG2 is the largest dictionary for this GOARCH, 32-bit platforms are held to the x86 limit.
+	LZHAM_MAX_DICT_SIZE_LOG2 = LZHAM_MAX_DICT_SIZE_LOG2_X86 + (LZHAM_MAX_DICT_SIZE_LOG2_X64-LZHAM_MAX_DICT_SIZE_LOG2_X86)*(bits.UintSize/64)
 )
 
 const (
@@ -54,7 +59,7 @@ const (
 	LZHAM_COMP_LEVEL_FORCE_DWORD lzham_compress_level = 0xFFFFFFFF
 )
 
-type lzham_compress_flags uint8
+type lzham_compress_flags uint32
 
 const (
 	LZHAM_COMP_FLAG_EXTREME_PARSING       lzham_compress_flags = 1 << (iota + 1) // Improves ratio by allowing the compressor's parse graph to grow "higher" (up to 4 parent nodes per output node), but is much slower.
@@ -70,6 +75,10 @@ const (
 	LZHAM_COMP_FLAG_FORCE_SINGLE_THREADED_PARSING
 
 	LZHAM_COMP_FLAG_USE_LOW_MEMORY_MATCH_FINDER
+
+	// Finds the longest match at every position with a suffix array rebuilt for each block. Meant for offline
+	// compression where ratio matters more than compression speed, which is several times slower.
+	LZHAM_COMP_FLAG_USE_SUFFIX_ARRAY_MATCH_FINDER
 )
 
 type lzham_table_update_rate uint8
@@ -0,0 +1,105 @@
+package lzham
+
+import "fmt"
+
+func (f lzham_flush_t) String() string {
+	switch f {
+	case LZHAM_NO_FLUSH:
+		return "LZHAM_NO_FLUSH"
+	case LZHAM_SYNC_FLUSH:
+		return "LZHAM_SYNC_FLUSH"
+	case LZHAM_FULL_FLUSH:
+		return "LZHAM_FULL_FLUSH"
+	case LZHAM_FINISH:
+		return "LZHAM_FINISH"
+	case LZHAM_TABLE_FLUSH:
+		return "LZHAM_TABLE_FLUSH"
+	}
+	return fmt.Sprintf("lzham_flush_t(%d)", int8(f))
+}
+
+// String names the status. The first success or failure codes share their value with, and print as,
+// LZHAM_COMP_STATUS_SUCCESS and LZHAM_COMP_STATUS_FAILED.
+func (s lzham_compress_status_t) String() string {
+	switch s {
+	case LZHAM_COMP_STATUS_NOT_FINISHED:
+		return "LZHAM_COMP_STATUS_NOT_FINISHED"
+	case LZHAM_COMP_STATUS_NEEDS_MORE_INPUT:
+		return "LZHAM_COMP_STATUS_NEEDS_MORE_INPUT"
+	case LZHAM_COMP_STATUS_HAS_MORE_OUTPUT:
+		return "LZHAM_COMP_STATUS_HAS_MORE_OUTPUT"
+	case LZHAM_COMP_STATUS_SUCCESS:
+		return "LZHAM_COMP_STATUS_SUCCESS"
+	case LZHAM_COMP_STATUS_FAILED:
+		return "LZHAM_COMP_STATUS_FAILED"
+	case LZHAM_COMP_STATUS_FAILED_INITIALIZING:
+		return "LZHAM_COMP_STATUS_FAILED_INITIALIZING"
+	case LZHAM_COMP_STATUS_INVALID_PARAMETER:
+		return "LZHAM_COMP_STATUS_INVALID_PARAMETER"
+	case LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL:
+		return "LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL"
+	case LZHAM_COMP_STATUS_FORCE_DWORD:
+		return "LZHAM_COMP_STATUS_FORCE_DWORD"
+	}
+	return fmt.Sprintf("lzham_compress_status_t(%d)", uint32(s))
+}
+
+func (l lzham_compress_level) String() string {
+	switch l {
+	case LZHAM_COMP_LEVEL_FASTEST:
+		return "LZHAM_COMP_LEVEL_FASTEST"
+	case LZHAM_COMP_LEVEL_FASTER:
+		return "LZHAM_COMP_LEVEL_FASTER"
+	case LZHAM_COMP_LEVEL_DEFAULT:
+		return "LZHAM_COMP_LEVEL_DEFAULT"
+	case LZHAM_COMP_LEVEL_BETTER:
+		return "LZHAM_COMP_LEVEL_BETTER"
+	case LZHAM_COMP_LEVEL_UBER:
+		return "LZHAM_COMP_LEVEL_UBER"
+	case LZHAM_TOTAL_COMP_LEVELS:
+		return "LZHAM_TOTAL_COMP_LEVELS"
+	case LZHAM_COMP_LEVEL_FORCE_DWORD:
+		return "LZHAM_COMP_LEVEL_FORCE_DWORD"
+	}
+	return fmt.Sprintf("lzham_compress_level(%d)", uint32(l))
+}
+
+// String names the status. The first success or failure codes share their value with, and print as,
+// LZHAM_DECOMP_STATUS_SUCCESS and LZHAM_DECOMP_STATUS_FAILED_INITIALIZING.
+func (s lzham_decompress_status_t) String() string {
+	switch s {
+	case LZHAM_DECOMP_STATUS_NOT_FINISHED:
+		return "LZHAM_DECOMP_STATUS_NOT_FINISHED"
+	case LZHAM_DECOMP_STATUS_HAS_MORE_OUTPUT:
+		return "LZHAM_DECOMP_STATUS_HAS_MORE_OUTPUT"
+	case LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT:
+		return "LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT"
+	case LZHAM_DECOMP_STATUS_SUCCESS:
+		return "LZHAM_DECOMP_STATUS_SUCCESS"
+	case LZHAM_DECOMP_STATUS_FAILED_INITIALIZING:
+		return "LZHAM_DECOMP_STATUS_FAILED_INITIALIZING"
+	case LZHAM_DECOMP_STATUS_FAILED_DEST_BUF_TOO_SMALL:
+		return "LZHAM_DECOMP_STATUS_FAILED_DEST_BUF_TOO_SMALL"
+	case LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES:
+		return "LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES"
+	case LZHAM_DECOMP_STATUS_FAILED_BAD_CODE:
+		return "LZHAM_DECOMP_STATUS_FAILED_BAD_CODE"
+	case LZHAM_DECOMP_STATUS_FAILED_ADLER32:
+		return "LZHAM_DECOMP_STATUS_FAILED_ADLER32"
+	case LZHAM_DECOMP_STATUS_FAILED_BAD_RAW_BLOCK:
+		return "LZHAM_DECOMP_STATUS_FAILED_BAD_RAW_BLOCK"
+	case LZHAM_DECOMP_STATUS_FAILED_BAD_COMP_BLOCK_SYNC_CHECK:
+		return "LZHAM_DECOMP_STATUS_FAILED_BAD_COMP_BLOCK_SYNC_CHECK"
+	case LZHAM_DECOMP_STATUS_FAILED_BAD_ZLIB_HEADER:
+		return "LZHAM_DECOMP_STATUS_FAILED_BAD_ZLIB_HEADER"
+	case LZHAM_DECOMP_STATUS_FAILED_NEED_SEED_BYTES:
+		return "LZHAM_DECOMP_STATUS_FAILED_NEED_SEED_BYTES"
+	case LZHAM_DECOMP_STATUS_FAILED_BAD_SEED_BYTES:
+		return "LZHAM_DECOMP_STATUS_FAILED_BAD_SEED_BYTES"
+	case LZHAM_DECOMP_STATUS_FAILED_BAD_SYNC_BLOCK:
+		return "LZHAM_DECOMP_STATUS_FAILED_BAD_SYNC_BLOCK"
+	case LZHAM_DECOMP_STATUS_INVALID_PARAMETER:
+		return "LZHAM_DECOMP_STATUS_INVALID_PARAMETER"
+	}
+	return fmt.Sprintf("lzham_decompress_status_t(%d)", uint32(s))
+}
@@ -0,0 +1,44 @@
+package lzham
+
+import "fmt"
+
+// Error is a failure status of the compressor or the decompressor. It's a comparable value, so errors.Is matches it
+// against the sentinels below.
+type Error struct {
+	// CompStatus is the failed status of the compressor, or 0 for a decompressor failure.
+	CompStatus lzham_compress_status_t
+	// DecompStatus is the failed status of the decompressor, or 0 for a compressor failure.
+	DecompStatus lzham_decompress_status_t
+}
+
+var (
+	ErrCompressFailed           = Error{CompStatus: LZHAM_COMP_STATUS_FAILED}
+	ErrCompressorInitFailed     = Error{CompStatus: LZHAM_COMP_STATUS_FAILED_INITIALIZING}
+	ErrInvalidCompressParameter = Error{CompStatus: LZHAM_COMP_STATUS_INVALID_PARAMETER}
+	ErrOutputBufTooSmall        = Error{CompStatus: LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL}
+
+	ErrDecompressorInitFailed     = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_INITIALIZING}
+	ErrDestBufTooSmall            = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_DEST_BUF_TOO_SMALL}
+	ErrExpectedMoreRawBytes       = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES}
+	ErrBadCode                    = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_CODE}
+	ErrBadAdler32                 = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_ADLER32}
+	ErrBadRawBlock                = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_RAW_BLOCK}
+	ErrBadCompBlockSyncCheck      = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_COMP_BLOCK_SYNC_CHECK}
+	ErrBadZlibHeader              = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_ZLIB_HEADER}
+	ErrNeedSeedBytes              = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_NEED_SEED_BYTES}
+	ErrBadSeedBytes               = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_SEED_BYTES}
+	ErrBadSyncBlock               = Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_SYNC_BLOCK}
+	ErrInvalidDecompressParameter = Error{DecompStatus: LZHAM_DECOMP_STATUS_INVALID_PARAMETER}
+)
+
+func (e Error) Error() string {
+	if e.DecompStatus != 0 {
+		return fmt.Sprintf("decompression failed: %v", e.DecompStatus)
+	}
+	return fmt.Sprintf("compression failed: %v", e.CompStatus)
+}
+
+// compress_status_error returns the Error of a failed compressor status.
+func compress_status_error(status lzham_compress_status_t) error {
+	return Error{CompStatus: status}
+}
@@ -0,0 +1,125 @@
+package lzham
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	data := gen_test_log(100, 50<<10)
+	comp := compress_test_writer(t, &CompressOptions{DictSizeLog2: 16}, data)
+	params := LZHAM_decompress_params{dict_size_log2: 16}
+
+	bad_adler := append([]byte(nil), comp...)
+	bad_adler[len(bad_adler)-1] ^= 0x01
+
+	decompress := func(src []byte, params LZHAM_decompress_params) error {
+		_, _, status := LZHAM_lib_decompress_memory(&params, make([]byte, len(data)), src)
+		return decompress_status_error(status)
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		want     error
+		want_msg string
+	}{
+		{
+			name:     "bad zlib header",
+			err:      decompress(comp, LZHAM_decompress_params{dict_size_log2: 16, decompress_flags: uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM)}),
+			want:     ErrBadZlibHeader,
+			want_msg: "decompression failed: LZHAM_DECOMP_STATUS_FAILED_BAD_ZLIB_HEADER",
+		},
+		{
+			name:     "bad adler32",
+			err:      decompress(bad_adler, params),
+			want:     ErrBadAdler32,
+			want_msg: "decompression failed: LZHAM_DECOMP_STATUS_FAILED_ADLER32",
+		},
+		{
+			name:     "wrapped",
+			err:      fmt.Errorf("reading archive: %w", Error{DecompStatus: LZHAM_DECOMP_STATUS_FAILED_BAD_CODE}),
+			want:     ErrBadCode,
+			want_msg: "reading archive: decompression failed: LZHAM_DECOMP_STATUS_FAILED_BAD_CODE",
+		},
+		{
+			name:     "compressor",
+			err:      compress_status_error(LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL),
+			want:     ErrOutputBufTooSmall,
+			want_msg: "compression failed: LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", tt.err, tt.want)
+			}
+			if tt.err.Error() != tt.want_msg {
+				t.Errorf("Error() = %q, want %q", tt.err.Error(), tt.want_msg)
+			}
+
+			var lzham_err Error
+			if !errors.As(tt.err, &lzham_err) || lzham_err != tt.want {
+				t.Errorf("errors.As() = %v, want %v", lzham_err, tt.want)
+			}
+		})
+	}
+
+	if errors.Is(ErrBadCode, ErrBadAdler32) || errors.Is(ErrCompressorInitFailed, ErrDecompressorInitFailed) {
+		t.Error("distinct sentinels match")
+	}
+}
+
+func Test_status_String(t *testing.T) {
+	var names []string
+	for s := LZHAM_COMP_STATUS_NOT_FINISHED; s <= LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL; s++ {
+		names = append(names, s.String())
+	}
+	for s := LZHAM_DECOMP_STATUS_NOT_FINISHED; s <= LZHAM_DECOMP_STATUS_INVALID_PARAMETER; s++ {
+		names = append(names, s.String())
+	}
+	for l := LZHAM_COMP_LEVEL_FASTEST; l <= LZHAM_TOTAL_COMP_LEVELS; l++ {
+		names = append(names, l.String())
+	}
+	for _, f := range []lzham_flush_t{LZHAM_NO_FLUSH, LZHAM_SYNC_FLUSH, LZHAM_FULL_FLUSH, LZHAM_FINISH, LZHAM_TABLE_FLUSH} {
+		names = append(names, f.String())
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasPrefix(name, "LZHAM_") || seen[name] {
+			t.Errorf("String() = %q, want a distinct LZHAM_ name", name)
+		}
+		seen[name] = true
+	}
+
+	tests := []struct {
+		name string
+		s    fmt.Stringer
+		want string
+	}{
+		{name: "compress status", s: LZHAM_COMP_STATUS_HAS_MORE_OUTPUT, want: "LZHAM_COMP_STATUS_HAS_MORE_OUTPUT"},
+		{name: "decompress status", s: LZHAM_DECOMP_STATUS_FAILED_BAD_CODE, want: "LZHAM_DECOMP_STATUS_FAILED_BAD_CODE"},
+		{name: "level", s: LZHAM_COMP_LEVEL_UBER, want: "LZHAM_COMP_LEVEL_UBER"},
+		{name: "flush", s: LZHAM_TABLE_FLUSH, want: "LZHAM_TABLE_FLUSH"},
+		{name: "unknown compress status", s: lzham_compress_status_t(100), want: "lzham_compress_status_t(100)"},
+		{name: "unknown decompress status", s: lzham_decompress_status_t(100), want: "lzham_decompress_status_t(100)"},
+		{name: "unknown flush", s: lzham_flush_t(7), want: "lzham_flush_t(7)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v", LZHAM_COMP_LEVEL_BETTER)
+	if buf.String() != "LZHAM_COMP_LEVEL_BETTER" {
+		t.Errorf("%%v = %q", buf.String())
+	}
+}
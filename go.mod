@@ -1,12 +1,3 @@
 module github.com/amaanq/lzham-go
 
-go 1.18
-
-require github.com/withmandala/go-log v0.1.0
-
-require (
-	github.com/smartystreets/goconvey v1.7.2 // indirect
-	golang.org/x/crypto v0.0.0-20220331220935-ae2d96664a29 // indirect
-	golang.org/x/sys v0.0.0-20220403205710-6acee93ad0eb // indirect
-	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
-)
+go 1.21
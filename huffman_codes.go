@@ -0,0 +1,169 @@
+package lzham
+
+// huffman_codes.cpp
+
+const (
+	cHuffmanMaxSupportedSyms = 1024
+
+	// Below this many symbols an insertion sort beats the radix sort's histogram passes.
+	cHuffmanInsertionSortSyms = 32
+)
+
+// huffman_work is the scratch space generate_huffman_codes sorts symbols in, reused between table updates.
+// Each key packs a symbol's frequency above its index, so keys sort by frequency and then by symbol, the same
+// order the reference's stable sort of (frequency, symbol) pairs gives.
+type huffman_work struct {
+	keys0 [cHuffmanMaxSupportedSyms]uint32
+	keys1 [cHuffmanMaxSupportedSyms]uint32
+	freq  [cHuffmanMaxSupportedSyms]uint32
+}
+
+// radix_sort_syms sorts keys0 by increasing frequency with up to two 8-bit passes over the frequency half of each
+// key. keys1 must be as long as keys0. It returns whichever of the two holds the result.
+func radix_sort_syms(keys0, keys1 []uint32) []uint32 {
+	num_syms := len(keys0)
+	if num_syms <= cHuffmanInsertionSortSyms {
+		for i := 1; i < num_syms; i++ {
+			k := keys0[i]
+			j := i
+			for ; j > 0 && keys0[j-1] > k; j-- {
+				keys0[j] = keys0[j-1]
+			}
+			keys0[j] = k
+		}
+		return keys0
+	}
+
+	const cMaxPasses = 2
+	var hist [cMaxPasses][256]uint32
+
+	for _, k := range keys0 {
+		hist[0][(k>>16)&0xFF]++
+		hist[1][k>>24]++
+	}
+
+	num_passes := cMaxPasses
+	if hist[1][0] == uint32(num_syms) {
+		num_passes = 1
+	}
+
+	cur, next := keys0, keys1[:num_syms]
+	for pass, shift := 0, uint32(16); pass < num_passes; pass, shift = pass+1, shift+8 {
+		offsets := &hist[pass]
+		var cur_ofs uint32
+		for i, n := range offsets {
+			offsets[i] = cur_ofs
+			cur_ofs += n
+		}
+
+		for _, k := range cur {
+			c := (k >> shift) & 0xFF
+			next[offsets[c]] = k
+			offsets[c]++
+		}
+
+		cur, next = next, cur
+	}
+
+	return cur
+}
+
+// calculate_minimum_redundancy computes code lengths in place from frequencies sorted in increasing order
+// (Moffat and Katajainen, "In-Place Calculation of Minimum-Redundancy Codes").
+func calculate_minimum_redundancy(A []uint32) {
+	num_syms := len(A)
+	A[0] += A[1]
+	root := 0
+	leaf := 2
+	for next := 1; next < num_syms-1; next++ {
+		if leaf >= num_syms || A[root] < A[leaf] {
+			A[next] = A[root]
+			A[root] = uint32(next)
+			root++
+		} else {
+			A[next] = A[leaf]
+			leaf++
+		}
+
+		if leaf >= num_syms || (root < next && A[root] < A[leaf]) {
+			A[next] += A[root]
+			A[root] = uint32(next)
+			root++
+		} else {
+			A[next] += A[leaf]
+			leaf++
+		}
+	}
+
+	A[num_syms-2] = 0
+	for next := num_syms - 3; next >= 0; next-- {
+		A[next] = A[A[next]] + 1
+	}
+
+	avbl := 1
+	used := 0
+	dpth := uint32(0)
+	root = num_syms - 2
+	next := num_syms - 1
+	for avbl > 0 {
+		for root >= 0 && A[root] == dpth {
+			used++
+			root--
+		}
+		for avbl > used {
+			A[next] = dpth
+			next--
+			avbl--
+		}
+		avbl = 2 * used
+		dpth++
+		used = 0
+	}
+}
+
+// generate_huffman_codes computes the code size of every symbol from its frequency. Symbols with a zero frequency
+// get a code size of 0. It returns the largest code size, which may exceed cMaxExpectedHuffCodeSize.
+func generate_huffman_codes(pContext *huffman_work, freq []uint16, code_sizes []uint8) (max_code_size uint32, total_freq uint32, ok bool) {
+	num_syms := len(freq)
+	if num_syms == 0 || num_syms > cHuffmanMaxSupportedSyms {
+		return 0, 0, false
+	}
+
+	num_used_syms := 0
+	for i, f := range freq {
+		if f == 0 {
+			code_sizes[i] = 0
+		} else {
+			total_freq += uint32(f)
+			pContext.keys0[num_used_syms] = uint32(f)<<16 | uint32(i)
+			num_used_syms++
+		}
+	}
+
+	if num_used_syms == 1 {
+		code_sizes[pContext.keys0[0]&0xFFFF] = 1
+		return 1, total_freq, true
+	}
+	if num_used_syms == 0 {
+		return 0, total_freq, true
+	}
+
+	keys := radix_sort_syms(pContext.keys0[:num_used_syms], pContext.keys1[:num_used_syms])
+
+	sizes := pContext.freq[:num_used_syms]
+	for i, k := range keys {
+		sizes[i] = k >> 16
+	}
+
+	calculate_minimum_redundancy(sizes)
+
+	for i, k := range keys {
+		len := sizes[i]
+		code_sizes[k&0xFFFF] = uint8(len)
+		if len > max_code_size {
+			max_code_size = len
+		}
+	}
+
+	return max_code_size, total_freq, true
+}
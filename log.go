@@ -1,11 +1,25 @@
 package lzham
 
 import (
-	"os"
-
-	"github.com/withmandala/go-log"
+	"context"
+	"log/slog"
 )
 
-var (
-	logger = log.New(os.Stdout).WithColor().WithDebug().WithTimestamp()
-)
+// discard_logger is what a compressor or decompressor without a logger logs to. Nothing is enabled, so nothing
+// is even formatted.
+var discard_logger = slog.New(discard_handler{})
+
+type discard_handler struct{}
+
+func (discard_handler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discard_handler) Handle(context.Context, slog.Record) error { return nil }
+func (h discard_handler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discard_handler) WithGroup(string) slog.Handler           { return h }
+
+// get_logger returns logger, or discard_logger if it's nil.
+func get_logger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discard_logger
+	}
+	return logger
+}
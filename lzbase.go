@@ -8,6 +8,33 @@ var (
 	slot_tab2 = [256]uint32{}
 )
 
+func init() {
+	init_slot_tabs()
+}
+
+func init_slot_tabs() {
+	for i := uint32(0); i < cLZXMaxPositionSlots; i++ {
+		lo := lzx_position_base[i]
+		hi := lo + lzx_position_extra_mask[i]
+
+		var tab []uint32
+		var shift uint32
+		if hi < 0x1000 {
+			tab, shift = slot_tab0[:], 0
+		} else if hi < 0x100000 {
+			tab, shift = slot_tab1[:], 11
+		} else if hi < 0x1000000 {
+			tab, shift = slot_tab2[:], 16
+		} else {
+			break
+		}
+
+		for j := lo >> shift; j <= hi>>shift; j++ {
+			tab[j] = i
+		}
+	}
+}
+
 func compute_lzx_position_slot(dist uint32) (uint32, uint32) {
 	var s uint32
 	if dist < 0x1000 {
@@ -29,3 +56,12 @@ func compute_lzx_position_slot(dist uint32) (uint32, uint32) {
 
 	return slot, ofs
 }
+
+// get_num_lzx_position_slots returns how many position slots are needed to code every distance in a dictionary of dict_size bytes.
+func get_num_lzx_position_slots(dict_size uint32) uint32 {
+	var num_slots uint32
+	for num_slots < cLZXMaxPositionSlots && lzx_position_base[num_slots] < dict_size {
+		num_slots++
+	}
+	return num_slots
+}
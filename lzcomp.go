@@ -2,13 +2,13 @@ package lzham
 
 import (
 	"errors"
-	"fmt"
+	"log/slog"
+	"runtime"
 )
 
 var (
-	ErrInvalidDictSizeLog2  = errors.New("invalid dict_size_log2")
-	ErrCompressorInitFailed = errors.New("failed to initialize compressor")
-	ErrNilCompressState     = errors.New("nil compress state")
+	ErrInvalidDictSizeLog2 = errors.New("invalid dict_size_log2")
+	ErrNilCompressState    = errors.New("nil compress state")
 )
 
 type LZHAM_compress_state struct {
@@ -25,6 +25,9 @@ type LZHAM_compress_state struct {
 
 	finished_compression bool
 
+	// Set once a flush has been done for all the input taken so far, so repeating the call doesn't flush again.
+	flushed bool
+
 	params LZHAM_compress_params
 
 	status lzham_compress_status_t
@@ -57,17 +60,41 @@ type LZHAM_compress_params struct {
 	// If this is 0, the compressor will either use a fast_bytes setting controlled by level, or a for extreme parsing a fixed setting of LZHAM_EXTREME_PARSING_FAST_BYTES (96).
 	// Field added in version 0x1011
 	fast_bytes uint32
+
+	// If non-zero, the most memory in bytes the match finder may use. The match finder's window is reduced to fit,
+	// see LZHAM_lib_compress_get_effective_dict_size_log2.
+	match_finder_max_bytes uint64
+
+	// def=0, in 1/256ths of a bit. How much compressed size the parser may give up, per unit of decoding work (see
+	// cLitComplexity), to pick paths of fewer and cheaper decisions that decode faster. Ignored with
+	// LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO. The fastest two levels don't use it.
+	decompression_complexity_weight uint32
+
+	// Where the compressor logs to, nil for nowhere.
+	logger *slog.Logger
+
+	// Called with the progress of the stream after each block of data and after the EOF block, if set.
+	progress func(Progress)
 }
 
 func LZHAM_lib_compress_init(pParams *LZHAM_compress_params) (*LZHAM_compress_state, error) {
-	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2_X64 {
+	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
 		return nil, ErrInvalidDictSizeLog2
 	}
 
+	pState, status := create_compress_state(pParams)
+	if status != LZHAM_COMP_STATUS_SUCCESS {
+		return nil, compress_status_error(status)
+	}
+	return pState, nil
+}
+
+// create_compress_state validates pParams and returns a compressor set up with them.
+func create_compress_state(pParams *LZHAM_compress_params) (*LZHAM_compress_state, lzham_compress_status_t) {
 	var internal_params init_params
 	status := create_internal_init_params(&internal_params, pParams)
 	if status != LZHAM_COMP_STATUS_SUCCESS {
-		return nil, fmt.Errorf("create_internal_init_params failed with status %d", status)
+		return nil, status
 	}
 
 	pState := &LZHAM_compress_state{
@@ -82,15 +109,11 @@ func LZHAM_lib_compress_init(pParams *LZHAM_compress_params) (*LZHAM_compress_st
 		finished_compression: false,
 	}
 
-	if internal_params.max_helper_threads > 0 {
-		internal_params.max_helper_threads = 0
-	}
-
 	if !pState.compressor.init(&internal_params) {
-		return nil, ErrCompressorInitFailed
+		return nil, LZHAM_COMP_STATUS_FAILED_INITIALIZING
 	}
 
-	return pState, nil
+	return pState, LZHAM_COMP_STATUS_SUCCESS
 }
 
 func LZHAM_lib_compress_reinit(pParams *LZHAM_compress_params, ptr *LZHAM_compress_state) (*LZHAM_compress_state, error) {
@@ -98,7 +121,9 @@ func LZHAM_lib_compress_reinit(pParams *LZHAM_compress_params, ptr *LZHAM_compre
 		return nil, ErrNilCompressState
 	}
 
-	ptr.compressor.reset() // reset the compressor
+	if !ptr.compressor.reset() {
+		return nil, ErrCompressorInitFailed
+	}
 
 	ptr.pIn_buf = nil
 	ptr.pIn_buf_size = 0
@@ -107,12 +132,195 @@ func LZHAM_lib_compress_reinit(pParams *LZHAM_compress_params, ptr *LZHAM_compre
 	ptr.status = LZHAM_COMP_STATUS_NOT_FINISHED
 	ptr.comp_data_ofs = 0
 	ptr.finished_compression = false
+	ptr.flushed = false
 
 	return ptr, nil
 }
 
+// LZHAM_lib_compress compresses pIn_buf into pOut_buf, returning how many bytes of each it used. Input is taken
+// a block at a time and only while there's room for the compressed data, so any size of either buffer works:
+// LZHAM_COMP_STATUS_HAS_MORE_OUTPUT means pOut_buf filled up and the call should be repeated with more room.
+// Once all of pIn_buf has been taken, flush_type is applied. Without a flush the status is
+// LZHAM_COMP_STATUS_NEEDS_MORE_INPUT, after a flush it's LZHAM_COMP_STATUS_NOT_FINISHED, and after LZHAM_FINISH
+// it's LZHAM_COMP_STATUS_SUCCESS once all the output has been returned.
+func LZHAM_lib_compress(pState *LZHAM_compress_state, pIn_buf, pOut_buf []byte, flush_type lzham_flush_t) (uint64, uint64, lzham_compress_status_t) {
+	if pState == nil {
+		return 0, 0, LZHAM_COMP_STATUS_INVALID_PARAMETER
+	}
+	if pState.status >= LZHAM_COMP_STATUS_FIRST_FAILURE_CODE {
+		return 0, 0, pState.status
+	}
+
+	block_flush_type := uint32(cSyncFlush)
+	switch flush_type {
+	case LZHAM_NO_FLUSH, LZHAM_FINISH, LZHAM_SYNC_FLUSH:
+	case LZHAM_FULL_FLUSH:
+		block_flush_type = cFullFlush
+	case LZHAM_TABLE_FLUSH:
+		block_flush_type = cTableFlush
+	default:
+		return 0, 0, LZHAM_COMP_STATUS_INVALID_PARAMETER
+	}
+
+	pState.pIn_buf, pState.pIn_buf_size = pIn_buf, uint64(len(pIn_buf))
+	pState.pOut_buf, pState.pOut_buf_size = pOut_buf, uint64(len(pOut_buf))
+
+	lz := &pState.compressor
+	var in_ofs, out_ofs uint64
+	for {
+		out_ofs += pState.copy_comp_data(pOut_buf[out_ofs:])
+		if pState.comp_data_ofs < uint64(len(lz.comp_buf)) {
+			pState.status = LZHAM_COMP_STATUS_HAS_MORE_OUTPUT
+			break
+		}
+		if pState.finished_compression {
+			pState.status = LZHAM_COMP_STATUS_SUCCESS
+			break
+		}
+
+		if in_ofs < pState.pIn_buf_size {
+			n := LZHAM_MIN64(pState.pIn_buf_size-in_ofs, uint64(lz.params.block_size-uint32(len(lz.block_buf))))
+			if !lz.put_bytes(pIn_buf[in_ofs : in_ofs+n]) {
+				pState.status = LZHAM_COMP_STATUS_FAILED
+				break
+			}
+			in_ofs += n
+			pState.flushed = false
+			continue
+		}
+
+		if flush_type == LZHAM_NO_FLUSH {
+			pState.status = LZHAM_COMP_STATUS_NEEDS_MORE_INPUT
+			break
+		}
+		if flush_type == LZHAM_FINISH {
+			if !lz.finish() {
+				pState.status = LZHAM_COMP_STATUS_FAILED
+				break
+			}
+			pState.finished_compression = true
+			continue
+		}
+		if pState.flushed {
+			pState.status = LZHAM_COMP_STATUS_NOT_FINISHED
+			break
+		}
+		if !lz.flush(block_flush_type) {
+			pState.status = LZHAM_COMP_STATUS_FAILED
+			break
+		}
+		pState.flushed = true
+	}
+
+	pState.pIn_buf, pState.pIn_buf_size = nil, 0
+	pState.pOut_buf, pState.pOut_buf_size = nil, 0
+
+	// A failed stream can only be reinitialized, so its goroutines aren't kept waiting for more.
+	if pState.status >= LZHAM_COMP_STATUS_FIRST_FAILURE_CODE {
+		lz.pTask_pool.deinit()
+	}
+
+	return in_ofs, out_ofs, pState.status
+}
+
+// copy_comp_data copies as much of the compressed data not yet returned as fits in out. The compressor's buffer is
+// emptied once it has all been returned.
+func (pState *LZHAM_compress_state) copy_comp_data(out []byte) uint64 {
+	comp_buf := pState.compressor.comp_buf
+	n := uint64(copy(out, comp_buf[pState.comp_data_ofs:]))
+	pState.comp_data_ofs += n
+	if pState.comp_data_ofs == uint64(len(comp_buf)) {
+		pState.compressor.comp_buf_base += int64(len(comp_buf))
+		pState.compressor.comp_buf = comp_buf[:0]
+		pState.comp_data_ofs = 0
+	}
+	return n
+}
+
+// LZHAM_lib_compress_memory compresses all of pSrc_buf into pDst_buf, returning the compressed size and the adler32
+// of pSrc_buf. LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL means the stream doesn't fit in pDst_buf, which it always
+// does if it's LZHAM_lib_compress_bound bytes.
+func LZHAM_lib_compress_memory(pParams *LZHAM_compress_params, pDst_buf, pSrc_buf []byte) (uint64, uint32, lzham_compress_status_t) {
+	if pParams == nil {
+		return 0, 0, LZHAM_COMP_STATUS_INVALID_PARAMETER
+	}
+
+	pState, status := create_compress_state(pParams)
+	if status != LZHAM_COMP_STATUS_SUCCESS {
+		return 0, 0, status
+	}
+
+	_, dst_len, status := LZHAM_lib_compress(pState, pSrc_buf, pDst_buf, LZHAM_FINISH)
+	if status == LZHAM_COMP_STATUS_HAS_MORE_OUTPUT {
+		return dst_len, 0, LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL
+	}
+	if status != LZHAM_COMP_STATUS_SUCCESS {
+		return dst_len, 0, status
+	}
+
+	return dst_len, pState.compressor.src_adler32, status
+}
+
+// LZHAM_lib_compress_bound returns the most bytes LZHAM_lib_compress_memory can produce from src_len bytes. Every
+// block is either compressed into fewer bytes than it holds or sent raw, so the bound is the data plus a raw block
+// header per block, the zlib header and the EOF block.
+func LZHAM_lib_compress_bound(src_len uint64, pParams *LZHAM_compress_params) uint64 {
+	// A memory budget can shrink the window, and with it the blocks, down to the smallest dictionary.
+	window_size_log2 := pParams.dict_size_log2
+	if pParams.match_finder_max_bytes > 0 {
+		window_size_log2 = LZHAM_MIN_DICT_SIZE_LOG2
+	}
+	block_size := LZHAM_MIN64(cDefaultBlockSize, uint64(1)<<window_size_log2/8)
+
+	// Seed bytes can leave blocks straddling the end of the window, which splits each into two.
+	num_blocks := 2*((src_len+block_size-1)/block_size) + 1
+
+	return 2 + src_len + num_blocks*cRawBlockHeaderSize + cEOFBlockSize
+}
+
+// CompressBound returns the most bytes CompressMemory can write when compressing src_len bytes with opts, or with
+// DefaultCompressOptions if opts is nil.
+func CompressBound(src_len uint64, opts *CompressOptions) uint64 {
+	if opts == nil {
+		default_opts := DefaultCompressOptions()
+		opts = &default_opts
+	}
+	params := opts.params()
+	return LZHAM_lib_compress_bound(src_len, &params)
+}
+
+// CompressMemory compresses src into a whole stream in dst with opts, or with DefaultCompressOptions if opts is nil,
+// and returns the stream's length. A dst of CompressBound bytes is always big enough, a smaller one gives
+// ErrOutputBufTooSmall if the stream doesn't fit.
+func CompressMemory(dst, src []byte, opts *CompressOptions) (int, error) {
+	if opts == nil {
+		default_opts := DefaultCompressOptions()
+		opts = &default_opts
+	}
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	params := opts.params()
+	n, _, status := LZHAM_lib_compress_memory(&params, dst, src)
+	if status != LZHAM_COMP_STATUS_SUCCESS {
+		return 0, compress_status_error(status)
+	}
+	return int(n), nil
+}
+
+// LZHAM_lib_compress_get_effective_dict_size_log2 returns log2 of the window the compressor's match finder actually searches.
+// It is smaller than the dictionary when match_finder_max_bytes was too small to hold a full window. The stream is still
+// decodable with the full dictionary size, matches are just never farther away than the effective window.
+func LZHAM_lib_compress_get_effective_dict_size_log2(pState *LZHAM_compress_state) uint32 {
+	if pState == nil {
+		return 0
+	}
+	return pState.compressor.get_effective_dict_size_log2()
+}
+
 func create_internal_init_params(internal_params *init_params, pParams *LZHAM_compress_params) lzham_compress_status_t {
-	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2_X64 {
+	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
 		return LZHAM_COMP_STATUS_INVALID_PARAMETER
 	}
 
@@ -131,9 +339,13 @@ func create_internal_init_params(internal_params *init_params, pParams *LZHAM_co
 	}
 
 	internal_params.dict_size_log2 = pParams.dict_size_log2
+	internal_params.match_finder_max_bytes = pParams.match_finder_max_bytes
+	internal_params.decompression_complexity_weight = pParams.decompression_complexity_weight
+	internal_params.logger = pParams.logger
+	internal_params.progress = pParams.progress
 
 	if pParams.max_helper_threads < 0 {
-		internal_params.max_helper_threads = 0
+		internal_params.max_helper_threads = uint32(LZHAM_MAX_INT32(int32(runtime.NumCPU())-1, 0))
 	} else {
 		internal_params.max_helper_threads = uint32(pParams.max_helper_threads)
 	}
@@ -164,18 +376,8 @@ func create_internal_init_params(internal_params *init_params, pParams *LZHAM_co
 		return LZHAM_COMP_STATUS_INVALID_PARAMETER
 	}
 
-	if pParams.table_max_update_interval > 0 || pParams.table_update_interval_slow_rate > 0 {
-		internal_params.table_max_update_interval = pParams.table_max_update_interval
-		internal_params.table_update_interval_slow_rate = pParams.table_update_interval_slow_rate
-	} else {
-		var rate uint32 = pParams.table_update_rate
-		if rate == 0 {
-			rate = uint32(LZHAM_DEFAULT_TABLE_UPDATE_RATE)
-		}
-		rate = clamp(rate, 1, uint32(LZHAM_FASTEST_TABLE_UPDATE_RATE)) - 1
-		internal_params.table_max_update_interval = uint32(g_table_update_settings[rate].m_max_update_interval)
-		internal_params.table_update_interval_slow_rate = uint32(g_table_update_settings[rate].m_slow_rate)
-	}
+	internal_params.table_max_update_interval, internal_params.table_update_interval_slow_rate = get_table_update_settings(
+		pParams.table_update_rate, pParams.table_max_update_interval, pParams.table_update_interval_slow_rate)
 
 	return LZHAM_COMP_STATUS_SUCCESS
 }
@@ -1,5 +1,11 @@
 package lzham
 
+import (
+	"log/slog"
+	"math/bits"
+	"time"
+)
+
 const (
 	// Update and print high-level coding statistics if set to 1.
 	// TODO: Add match distance coding statistics.
@@ -65,9 +71,22 @@ type init_params struct {
 
 	extreme_parsing_max_best_arrivals uint32
 	fast_bytes_override               uint32
+
+	decompression_complexity_weight uint32
+
+	match_finder_max_bytes uint64
+
+	logger   *slog.Logger
+	progress func(Progress)
 }
 
+// How much work the decompressor does for each kind of decision, as ported, which the graph parsers use to prefer
+// paths that decode faster. decompression_complexity_weight is in 1/cComplexityWeightScale bits per unit of
+// complexity.
 const (
+	cComplexityWeightScaleBits = 8
+	cComplexityWeightScale     = 1 << cComplexityWeightScaleBits
+
 	cLitComplexity  = 1
 	cRep0Complexity = 2
 	cRep3Complexity = 5
@@ -182,10 +201,27 @@ type lzcompressor struct {
 	src_size    int64
 	src_adler32 uint32
 
-	accel search_accelerator
+	// Compressed bytes taken out of comp_buf before it was last emptied, so all of them with what's in it now.
+	comp_buf_base int64
+	start_time    time.Time
+
+	accel match_finder
+
+	num_lzx_slots uint32
+
+	state state
+
+	// The models and LZ state before the current block, restored if it's sent raw.
+	saved_state state
 
 	codec symbol_codec
 
+	// With LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO, the current block's decisions, coded again from
+	// saved_state with the update rates reset into reset_codec, leaving reset_state where the models end up.
+	reset_decisions []lzdecision
+	reset_state     state
+	reset_codec     symbol_codec
+
 	stats coding_stats
 
 	block_buf []byte
@@ -199,11 +235,39 @@ type lzcompressor struct {
 
 	finished            bool
 	use_task_pool       bool
+	pTask_pool          *task_pool
 	use_extreme_parsing bool
 
 	fast_bytes uint32
 
-	num_parse_threads uint32
+	// Added to a path's cost per unit of decision complexity.
+	complexity_penalty bit_cost_t
+
+	num_parse_jobs      uint32
+	num_parse_threads   uint32
+	parse_thread_states [cMaxParseThreads]parse_thread_state
+	block_decisions     []lzdecision
+
+	// Closed to give up compressing, which fails the block being parsed. nil never is.
+	done <-chan struct{}
+
+	logger *slog.Logger
+}
+
+// set_done makes compressing give up once done is closed, in the match finder too.
+func (lz *lzcompressor) set_done(done <-chan struct{}) {
+	lz.done = done
+	lz.accel.set_done(done)
+}
+
+// canceled reports whether done has been closed.
+func (lz *lzcompressor) canceled() bool {
+	select {
+	case <-lz.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (lz *lzcompressor) init(params *init_params) bool {
@@ -215,6 +279,8 @@ func (lz *lzcompressor) init(params *init_params) bool {
 		return false
 	}
 
+	lz.logger = get_logger(params.logger)
+
 	use_extreme_parsing := params.lzham_compress_flags&uint32(LZHAM_COMP_FLAG_EXTREME_PARSING) > 0 && params.compression_level == cCompressionLevelUber
 
 	settings := s_level_settings[params.compression_level]
@@ -231,7 +297,7 @@ func (lz *lzcompressor) init(params *init_params) bool {
 	var dict_size uint32 = 1 << params.dict_size_log2
 
 	if params.num_seed_bytes > 0 {
-		if params.pSeed_bytes != nil {
+		if params.pSeed_bytes == nil {
 			return false
 		}
 		if params.num_seed_bytes > uint32(dict_size) {
@@ -239,16 +305,37 @@ func (lz *lzcompressor) init(params *init_params) bool {
 		}
 	}
 
-	var max_block_size uint32 = dict_size / 8
-	if params.block_size > max_block_size {
-		params.block_size = max_block_size
+	lz.settings = settings
+	lz.use_extreme_parsing = use_extreme_parsing
+
+	// Trading decompression rate for ratio means only ever breaking exact ties by complexity.
+	lz.complexity_penalty = 0
+	if params.lzham_compress_flags&uint32(LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO) == 0 {
+		lz.complexity_penalty = bit_cost_t(params.decompression_complexity_weight) << (cBitCostScaleShift - cComplexityWeightScaleBits)
 	}
 
+	// The hash chain match finder searches on demand, so only the other backends can be parsed from several goroutines.
+	// Their rounds are always split into cMaxParseThreads jobs however many goroutines parse them, so the output
+	// doesn't depend on the CPU count or on forcing single threaded parsing.
+	lz.num_parse_jobs = 1
 	var num_parse_threads uint32 = 1
-
-	num_parse_jobs := num_parse_threads - 1
-	match_accel_helper_threads := LZHAM_MAX(0, params.max_helper_threads-num_parse_jobs)
-	match_accel_helper_threads = LZHAM_MIN(match_accel_helper_threads, cMatchAccelMaxSupportedThreads)
+	if params.compression_level > cCompressionLevelFaster {
+		lz.num_parse_jobs = cMaxParseThreads
+		if LZHAM_FORCE_SINGLE_THREADED_PARSING == 0 && params.lzham_compress_flags&uint32(LZHAM_COMP_FLAG_FORCE_SINGLE_THREADED_PARSING) == 0 {
+			num_parse_threads = LZHAM_MIN(params.max_helper_threads+1, cMaxParseThreads)
+		}
+	}
+	lz.num_parse_threads = num_parse_threads
+
+	// A block's matches are all found before any of it is parsed, rather than alongside the parse jobs, so the match
+	// finder can have every helper thread. The two take turns on the pool's goroutines.
+	match_accel_helper_threads := LZHAM_MIN(params.max_helper_threads, cMatchAccelMaxSupportedThreads-1)
+	num_pool_threads := LZHAM_MAX(num_parse_threads-1, match_accel_helper_threads)
+	lz.use_task_pool = num_pool_threads > 0
+	if lz.pTask_pool == nil {
+		lz.pTask_pool = &task_pool{}
+	}
+	lz.pTask_pool.init(num_pool_threads)
 
 	var accel_flags uint32 = 0
 	if params.lzham_compress_flags&uint32(LZHAM_COMP_FLAG_DETERMINISTIC_PARSING) > 0 {
@@ -262,33 +349,98 @@ func (lz *lzcompressor) init(params *init_params) bool {
 		accel_flags |= cFlagLen2Matches
 	}
 
-	if !lz.accel.init(match_accel_helper_threads, dict_size, settings.match_accel_max_matches_per_probe, false, settings.match_accel_max_probes, accel_flags) {
+	if (params.lzham_compress_flags & uint32(LZHAM_COMP_FLAG_USE_LOW_MEMORY_MATCH_FINDER)) != 0 {
+		accel_flags |= cFlagLowMemory
+	}
+
+	// The two fastest levels use the hash chain match finder, the rest the binary tree, unless the suffix array was asked for.
+	if (params.lzham_compress_flags & uint32(LZHAM_COMP_FLAG_USE_SUFFIX_ARRAY_MATCH_FINDER)) != 0 {
+		var sx suffix_array_accelerator
+		if !sx.init(dict_size, settings.match_accel_max_matches_per_probe, settings.match_accel_max_probes, accel_flags, params.match_finder_max_bytes) {
+			return false
+		}
+		lz.accel = &sx
+	} else if params.compression_level <= cCompressionLevelFaster {
+		var hc hash_chain_accelerator
+		if !hc.init(dict_size, settings.match_accel_max_matches_per_probe, settings.match_accel_max_probes, accel_flags, params.match_finder_max_bytes) {
+			return false
+		}
+		lz.accel = &hc
+	} else {
+		var sa search_accelerator
+		if !sa.init(lz.pTask_pool, match_accel_helper_threads, dict_size, settings.match_accel_max_matches_per_probe, false, settings.match_accel_max_probes, accel_flags, params.match_finder_max_bytes) {
+			return false
+		}
+		lz.accel = &sa
+	}
+
+	if lz.accel.is_window_reduced() {
+		lz.logger.Warn("match finder memory budget limits the window", "match_finder_max_bytes", params.match_finder_max_bytes,
+			"window_size", lz.accel.get_max_dict_size(), "dict_size", dict_size)
+	}
+
+	// Blocks are clamped to the window the match finder actually has, which may be smaller than the dictionary.
+	var max_block_size uint32 = lz.accel.get_max_dict_size() / 8
+	if params.block_size == 0 {
+		params.block_size = cDefaultBlockSize
+	}
+	if params.block_size > max_block_size {
+		params.block_size = max_block_size
+	}
+
+	lz.params = *params
+	if lz.params.extreme_parsing_max_best_arrivals == 0 {
+		lz.params.extreme_parsing_max_best_arrivals = cDefaultMaxParseNodeStates
+	}
+	lz.params.extreme_parsing_max_best_arrivals = clamp(lz.params.extreme_parsing_max_best_arrivals, 1, cMaxParseNodeStates)
+
+	lz.num_lzx_slots = get_num_lzx_position_slots(dict_size)
+	if !lz.state.init(lz) {
 		return false
 	}
 
-	lz.block_buf = make([]byte, params.block_size)
-	lz.comp_buf = make([]byte, params.block_size*2)
+	if !lz.saved_state.init(lz) {
+		return false
+	}
+
+	if params.lzham_compress_flags&uint32(LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO) != 0 && !lz.reset_state.init(lz) {
+		return false
+	}
+
+	lz.block_buf = make([]byte, 0, params.block_size)
+	lz.comp_buf = make([]byte, 0, params.block_size*2)
 
 	if params.num_seed_bytes > 0 {
 		if !lz.init_seed_bytes() {
-			logger.Errorf("init_seed_bytes failed, code %d", 7010)
+			lz.logger.Error("init_seed_bytes failed", "num_seed_bytes", params.num_seed_bytes)
 			return false
 		}
 	}
 
 	lz.src_size = 0
+	lz.src_adler32 = 1
+	lz.comp_buf_base = 0
+	lz.start_time = time.Now()
 
-	return true
+	return lz.send_zlib_header()
+}
+
+// get_effective_dict_size_log2 returns log2 of the window the match finder searches, which a memory budget may have made smaller than the dictionary.
+func (lz *lzcompressor) get_effective_dict_size_log2() uint32 {
+	return uint32(bits.TrailingZeros32(lz.accel.get_max_dict_size()))
 }
 
 func (lz *lzcompressor) reset() bool {
 	lz.accel.reset()
+	lz.state.reset()
 	lz.codec.reset()
 	lz.stats.clear()
 	lz.src_size = 0
 	lz.src_adler32 = 1
-	lz.block_buf = nil
-	lz.comp_buf = nil
+	lz.block_buf = lz.block_buf[:0]
+	lz.comp_buf = lz.comp_buf[:0]
+	lz.comp_buf_base = 0
+	lz.start_time = time.Now()
 
 	lz.step = 0
 	lz.finished = false
@@ -305,21 +457,22 @@ func (lz *lzcompressor) reset() bool {
 }
 
 func (lz *lzcompressor) init_seed_bytes() bool {
-	// var cur_seed_ofs uint32 = 0
+	var cur_seed_ofs uint32 = 0
 
-	// for cur_seed_ofs < lz.params.num_seed_bytes {
-	// 	var total_bytes_remaining uint32 = lz.params.num_seed_bytes - cur_seed_ofs
-	// 	var num_bytes_to_add uint32 = minimum(total_bytes_remaining, lz.params.block_size)
+	for cur_seed_ofs < lz.params.num_seed_bytes {
+		var total_bytes_remaining uint32 = lz.params.num_seed_bytes - cur_seed_ofs
+		var num_bytes_to_add uint32 = minimum(total_bytes_remaining, lz.params.block_size)
+		num_bytes_to_add = minimum(num_bytes_to_add, lz.accel.get_max_add_bytes())
 
-	// 	if !lz.accel.add_bytes_begin(num_bytes_to_add, (lz.params.pSeed_bytes)+cur_seed_ofs) {
-	// 		return false
-	// 	}
-	// 	lz.accel.add_bytes_end()
+		if !lz.accel.add_bytes_begin(num_bytes_to_add, lz.params.pSeed_bytes[cur_seed_ofs:]) {
+			return false
+		}
+		lz.accel.add_bytes_end()
 
-	// 	lz.accel.advance_bytes(num_bytes_to_add)
+		lz.accel.advance_bytes(num_bytes_to_add)
 
-	// 	cur_seed_ofs += num_bytes_to_add
-	// }
+		cur_seed_ofs += num_bytes_to_add
+	}
 
 	return true
 }
@@ -352,7 +505,258 @@ func (lz *lzcompressor) send_zlib_header() bool {
 		flg += 31 - check
 	}
 
-	// i dont even know anymore
+	lz.comp_buf = append(lz.comp_buf, byte(cmf), byte(flg))
+
+	return true
+}
+
+// put_bytes appends buf to the current block, compressing the block each time it fills.
+func (lz *lzcompressor) put_bytes(buf []byte) bool {
+	if lz.finished {
+		return false
+	}
+
+	for len(buf) > 0 {
+		n := LZHAM_MIN(lz.params.block_size-uint32(len(lz.block_buf)), uint32(len(buf)))
+		lz.block_buf = append(lz.block_buf, buf[:n]...)
+		buf = buf[n:]
+
+		if uint32(len(lz.block_buf)) == lz.params.block_size {
+			if !lz.compress_block(lz.block_buf) {
+				return false
+			}
+			lz.block_buf = lz.block_buf[:0]
+		}
+	}
 
 	return true
 }
+
+// flush compresses what's left of the current block so the decompressor can output everything put so far, then sends
+// flush_type in a sync block and applies it to both sides.
+func (lz *lzcompressor) flush(flush_type uint32) bool {
+	if lz.finished {
+		return false
+	}
+
+	if len(lz.block_buf) > 0 {
+		if !lz.compress_block(lz.block_buf) {
+			return false
+		}
+		lz.block_buf = lz.block_buf[:0]
+	}
+
+	if !lz.send_sync_block(flush_type) {
+		return false
+	}
+	lz.apply_flush(flush_type)
+	return true
+}
+
+// finish compresses what's left of the current block and ends the stream with an EOF block.
+func (lz *lzcompressor) finish() bool {
+	if lz.finished {
+		return false
+	}
+
+	if len(lz.block_buf) > 0 {
+		if !lz.compress_block(lz.block_buf) {
+			return false
+		}
+		lz.block_buf = lz.block_buf[:0]
+	}
+
+	// The EOF block carries the adler32 of all the data, which the decompressor checks.
+	lz.codec.start_encoding(16)
+	lz.codec.encode_bits(cEOFBlock, cBlockHeaderBits)
+	lz.codec.encode_align_to_byte()
+	lz.codec.encode_bits(lz.src_adler32, 32)
+	if !lz.codec.stop_encoding() {
+		return false
+	}
+	lz.comp_buf = append(lz.comp_buf, lz.codec.get_encoding_buf()...)
+
+	lz.finished = true
+	lz.pTask_pool.deinit()
+	lz.report_progress()
+	return true
+}
+
+// send_sync_block sends flush_type on its own, followed by a marker the decompressor checks.
+func (lz *lzcompressor) send_sync_block(flush_type uint32) bool {
+	lz.codec.start_encoding(16)
+	lz.codec.encode_bits(cSyncBlock, cBlockHeaderBits)
+	lz.codec.encode_bits(flush_type, cBlockFlushTypeBits)
+	lz.codec.encode_align_to_byte()
+	lz.codec.encode_bits(cSyncBlockMarkerLo, 16)
+	lz.codec.encode_bits(cSyncBlockMarkerHi, 16)
+	if !lz.codec.stop_encoding() {
+		return false
+	}
+	lz.comp_buf = append(lz.comp_buf, lz.codec.get_encoding_buf()...)
+	return true
+}
+
+// apply_flush does what flush_type asks of the compressor once the sync block holding it has been sent. A full flush
+// forgets all the data so far, so decompression can start over from the next block.
+func (lz *lzcompressor) apply_flush(flush_type uint32) {
+	switch flush_type {
+	case cFullFlush:
+		lz.accel.reset()
+		lz.state.reset()
+	case cTableFlush:
+		lz.state.reset_update_rate()
+	}
+}
+
+// compress_block codes buf as one or more blocks appended to comp_buf. A block is split where the match finder's
+// dictionary wraps, since its lookahead can't.
+func (lz *lzcompressor) compress_block(buf []byte) bool {
+	for len(buf) > 0 {
+		if lz.canceled() {
+			return false
+		}
+		num_bytes := LZHAM_MIN(uint32(len(buf)), lz.accel.get_max_add_bytes())
+
+		if !lz.accel.add_bytes_begin(num_bytes, buf) {
+			return false
+		}
+		lz.accel.add_bytes_end()
+
+		ok := lz.compress_block_internal(buf[:num_bytes])
+
+		lz.accel.advance_bytes(num_bytes)
+		if !ok {
+			return false
+		}
+
+		buf = buf[num_bytes:]
+	}
+
+	return true
+}
+
+// compress_block_internal parses and codes the lookahead, which holds buf. If the coded block isn't smaller than
+// the data it's sent raw instead, and the models go back to where they were before it.
+func (lz *lzcompressor) compress_block_internal(buf []byte) bool {
+	num_bytes := uint32(len(buf))
+
+	lz.block_start_dict_ofs = lz.accel.get_lookahead_pos()
+	lz.src_adler32 = lzham_adler32_update(lz.src_adler32, buf)
+
+	lz.saved_state.assign(&lz.state)
+
+	// Tables that adapt quickly again can suit a block unlike the ones before it, at the cost of slower decoding. With
+	// the tradeoff flag set the block is coded both ways, and sent whichever way is smaller.
+	try_reset := lz.block_index > 0 &&
+		lz.params.lzham_compress_flags&uint32(LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO) != 0
+	lz.reset_decisions = lz.reset_decisions[:0]
+
+	codec := &lz.codec
+	codec.start_encoding(num_bytes)
+	codec.encode_bits(cCompBlock, cBlockHeaderBits)
+	codec.encode_arith_init()
+	codec.encode_bits(cCompBlockNoReset, cBlockFlushTypeBits)
+
+	round_size := lz.get_parse_round_size()
+	for ofs := uint32(0); ofs < num_bytes; ofs += round_size {
+		if lz.canceled() {
+			return false
+		}
+		decisions, ok := lz.parse_block(ofs, LZHAM_MIN(num_bytes-ofs, round_size))
+		if !ok {
+			return false
+		}
+		for _, dec := range decisions {
+			if !lz.state.encode(codec, lz, dec) {
+				return false
+			}
+		}
+		if try_reset {
+			lz.reset_decisions = append(lz.reset_decisions, decisions...)
+		}
+	}
+	if !lz.end_comp_block(codec, &lz.state) {
+		return false
+	}
+
+	comp := codec.get_encoding_buf()
+	if try_reset {
+		if !lz.code_block_with_update_rate_reset(num_bytes) {
+			return false
+		}
+		if reset_comp := lz.reset_codec.get_encoding_buf(); len(reset_comp) < len(comp) {
+			comp = reset_comp
+			lz.state.assign(&lz.reset_state)
+			lz.stats.total_update_rate_resets++
+		}
+	}
+
+	if LZHAM_FORCE_ALL_RAW_BLOCKS == 0 && uint32(len(comp)) < cRawBlockHeaderSize+num_bytes {
+		lz.comp_buf = append(lz.comp_buf, comp...)
+	} else {
+		lz.state.assign(&lz.saved_state)
+
+		codec.start_encoding(16)
+		codec.encode_bits(cRawBlock, cBlockHeaderBits)
+		codec.encode_bits(num_bytes-1, cRawBlockLenBits)
+		codec.encode_bits(get_raw_block_len_check(num_bytes-1), cRawBlockLenCheckBits)
+		codec.encode_align_to_byte()
+		if !codec.stop_encoding() {
+			return false
+		}
+		lz.comp_buf = append(lz.comp_buf, codec.get_encoding_buf()...)
+		lz.comp_buf = append(lz.comp_buf, buf...)
+	}
+
+	lz.src_size += int64(num_bytes)
+	lz.block_index++
+	lz.report_progress()
+
+	return true
+}
+
+// report_progress tells the progress callback, if there is one, how far the stream has got.
+func (lz *lzcompressor) report_progress() {
+	if lz.params.progress == nil {
+		return
+	}
+	comp_size := lz.comp_buf_base + int64(len(lz.comp_buf))
+	lz.params.progress(Progress{
+		Blocks:  lz.block_index,
+		In:      lz.src_size,
+		Out:     comp_size,
+		Ratio:   get_ratio(comp_size, lz.src_size),
+		Elapsed: time.Since(lz.start_time),
+	})
+}
+
+// end_comp_block codes the end of block symbol from s and the block's check bits, and finishes the block.
+func (lz *lzcompressor) end_comp_block(codec *symbol_codec, s *state) bool {
+	if !s.encode_eob(codec) {
+		return false
+	}
+	codec.encode_bits(get_block_check(lz.src_adler32), cBlockCheckBits)
+	codec.encode_align_to_byte()
+	return codec.stop_encoding()
+}
+
+// code_block_with_update_rate_reset codes reset_decisions into reset_codec as a block that starts by resetting the
+// update rates of saved_state's tables, leaving reset_state where the models end up.
+func (lz *lzcompressor) code_block_with_update_rate_reset(num_bytes uint32) bool {
+	s := &lz.reset_state
+	s.assign(&lz.saved_state)
+	s.reset_update_rate()
+
+	codec := &lz.reset_codec
+	codec.start_encoding(num_bytes)
+	codec.encode_bits(cCompBlock, cBlockHeaderBits)
+	codec.encode_arith_init()
+	codec.encode_bits(cCompBlockResetUpdateRate, cBlockFlushTypeBits)
+	for _, dec := range lz.reset_decisions {
+		if !s.encode(codec, lz, dec) {
+			return false
+		}
+	}
+	return lz.end_comp_block(codec, s)
+}
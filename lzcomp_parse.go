@@ -0,0 +1,722 @@
+package lzham
+
+import "math/bits"
+
+// lzdecision is a single step of a parse: a literal (len 0), a match (dist > 0) or a rep match, whose dist is
+// -1 - the index of the distance in the match history. Positions are lookahead offsets.
+type lzdecision struct {
+	pos  int32
+	len  int32
+	dist int32
+}
+
+func (d *lzdecision) init(pos, len, dist int32) {
+	d.pos = pos
+	d.len = len
+	d.dist = dist
+}
+
+func (d lzdecision) is_lit() bool {
+	return d.len == 0
+}
+
+func (d lzdecision) is_match() bool {
+	return d.len > 0
+}
+
+func (d lzdecision) is_rep() bool {
+	return d.dist < 0
+}
+
+func (d lzdecision) is_full_match() bool {
+	return d.dist > 0
+}
+
+// get_len returns the number of bytes the decision covers.
+func (d lzdecision) get_len() uint32 {
+	if d.len == 0 {
+		return 1
+	}
+	return uint32(d.len)
+}
+
+// get_complexity returns how much work the decompressor does for the decision, see cLitComplexity.
+func (d lzdecision) get_complexity() uint32 {
+	return get_decision_complexity(d.len, d.dist)
+}
+
+func get_decision_complexity(len, dist int32) uint32 {
+	switch {
+	case len == 0:
+		return cLitComplexity
+	case dist == -1:
+		return cRep0Complexity
+	case dist < 0:
+		return cRep3Complexity
+	case len >= cLongMatchComplexityLenThresh:
+		return cLongMatchComplexity
+	default:
+		return cShortMatchComplexity
+	}
+}
+
+func (d lzdecision) get_rep_index() uint32 {
+	return uint32(-d.dist - 1)
+}
+
+func (d lzdecision) get_match_dist(match_hist *[cMatchHistSize]uint32) uint32 {
+	if d.is_rep() {
+		return match_hist[d.get_rep_index()]
+	}
+	return uint32(d.dist)
+}
+
+// update_match_hist moves the distance used by a match to the front of the match history.
+func update_match_hist(match_hist *[cMatchHistSize]uint32, d lzdecision) {
+	if d.is_full_match() {
+		copy(match_hist[1:], match_hist[:cMatchHistSize-1])
+		match_hist[0] = uint32(d.dist)
+	} else if d.is_rep() {
+		if i := d.get_rep_index(); i > 0 {
+			dist := match_hist[i]
+			copy(match_hist[1:i+1], match_hist[:i])
+			match_hist[0] = dist
+		}
+	}
+}
+
+// parse_thread_state is the input and output of parsing one range of the lookahead.
+type parse_thread_state struct {
+	start_ofs      uint32
+	bytes_to_match uint32
+
+	initial_state lzstate
+
+	best_decisions []lzdecision
+
+	nodes         []parse_node
+	extreme_nodes []extreme_parse_node
+
+	failed bool
+}
+
+// parse dispatches to the parser for the compression level: greedy for the two fastest levels, optimal for the
+// rest, or extreme if it was asked for at the Uber level.
+func (lz *lzcompressor) parse(parse_state *parse_thread_state) bool {
+	if lz.params.compression_level <= cCompressionLevelFaster {
+		return lz.greedy_parse(parse_state)
+	}
+	if lz.use_extreme_parsing {
+		return lz.extreme_parse(parse_state)
+	}
+	return lz.optimal_parse(parse_state)
+}
+
+// Matches this short are usually more expensive to code than literals once their distance gets large.
+const cGreedyMaxMinLenMatchDist = 1 << 16
+
+// greedy_match_gain estimates the bits saved by coding len bytes as a match at dist instead of as literals.
+func greedy_match_gain(len, dist uint32) int32 {
+	return int32(len*8) - int32(bits.Len32(dist)) - 12
+}
+
+// greedy_rep_match_gain is greedy_match_gain for rep matches, which don't code a distance.
+func greedy_rep_match_gain(len uint32) int32 {
+	return int32(len*8) - 8
+}
+
+// find_greedy_match returns the most profitable match or rep match at lookahead_ofs, and its estimated gain.
+// A zero length decision means a literal is best.
+func (lz *lzcompressor) find_greedy_match(lookahead_ofs, max_len uint32, match_hist *[cMatchHistSize]uint32) (lzdecision, int32) {
+	var best lzdecision
+	best.pos = int32(lookahead_ofs)
+	var best_gain int32
+
+	c := lz.accel.get_char(int32(lookahead_ofs))
+	for i := uint32(0); i < cMatchHistSize; i++ {
+		if i > 0 && match_hist[i] == match_hist[i-1] {
+			continue
+		}
+		if dist := match_hist[i]; dist > lz.accel.get_cur_dict_size()+lookahead_ofs || lz.accel.get_char(int32(lookahead_ofs-dist)) != c {
+			continue
+		}
+		len := lz.accel.match_len(lookahead_ofs, match_hist[i], max_len)
+		if len < cMinMatchLen {
+			continue
+		}
+		if gain := greedy_rep_match_gain(len); gain > best_gain {
+			best.init(int32(lookahead_ofs), int32(len), -1-int32(i))
+			best_gain = gain
+			if len >= lz.fast_bytes {
+				return best, best_gain
+			}
+		}
+	}
+
+	for _, m := range lz.accel.find_matches(lookahead_ofs) {
+		len := LZHAM_MIN(uint32(m.get_len()), max_len)
+		dist := m.get_dist()
+		if len > cMinMatchLen+1 || (len == cMinMatchLen+1 && dist < cGreedyMaxMinLenMatchDist) {
+			if gain := greedy_match_gain(len, dist); gain > best_gain {
+				best.init(int32(lookahead_ofs), int32(len), int32(dist))
+				best_gain = gain
+			}
+		}
+		if m.is_last() {
+			break
+		}
+	}
+
+	return best, best_gain
+}
+
+// greedy_parse parses the lookahead range in parse_state taking the most profitable match at each position, with one
+// step of lazy evaluation: a match shorter than fast_bytes is deferred by a literal if the next position has a better one.
+func (lz *lzcompressor) greedy_parse(parse_state *parse_thread_state) bool {
+	parse_state.failed = true
+	parse_state.best_decisions = parse_state.best_decisions[:0]
+
+	match_hist := parse_state.initial_state.match_hist
+
+	end_ofs := parse_state.start_ofs + parse_state.bytes_to_match
+	cur_ofs := parse_state.start_ofs
+
+	var next lzdecision
+	var next_gain int32
+	have_next := false
+
+	for cur_ofs < end_ofs {
+		var dec lzdecision
+		var gain int32
+		if have_next {
+			dec, gain = next, next_gain
+		} else {
+			dec, gain = lz.find_greedy_match(cur_ofs, end_ofs-cur_ofs, &match_hist)
+		}
+		have_next = false
+
+		if dec.is_match() && dec.get_len() < lz.fast_bytes && cur_ofs+1 < end_ofs {
+			next, next_gain = lz.find_greedy_match(cur_ofs+1, end_ofs-cur_ofs-1, &match_hist)
+			if next_gain > gain+8 {
+				dec.init(int32(cur_ofs), 0, 0)
+				have_next = true
+			}
+		}
+
+		parse_state.best_decisions = append(parse_state.best_decisions, dec)
+		update_match_hist(&match_hist, dec)
+
+		cur_ofs += dec.get_len()
+	}
+
+	parse_state.failed = false
+	return true
+}
+
+// parse_node is a position in the parse graph, reached at total_cost by coding lzdec from the node at parent_index.
+type parse_node struct {
+	total_cost       bit_cost_t
+	total_complexity uint32
+	parent_index     int32
+
+	lzdec lzdecision
+
+	// The LZ state after lzdec, filled in when the node is expanded.
+	lzstate lzstate
+}
+
+// node_matches is every match available at one parse graph node.
+type node_matches struct {
+	max_len uint32
+
+	rep_lens  [cMatchHistSize]uint32
+	matches   []dict_match
+	len2_dist uint32
+
+	// The longest of the rep and full matches, as a decision length and distance.
+	longest_len  uint32
+	longest_dist int32
+}
+
+// find_node_matches finds the rep matches from lzs, the full matches and the len2 match at lookahead_ofs, none longer than max_len.
+func (lz *lzcompressor) find_node_matches(nm *node_matches, lookahead_ofs, max_len uint32, lzs *lzstate) {
+	nm.max_len = max_len
+	nm.longest_len = 0
+	nm.longest_dist = 0
+
+	for i := uint32(0); i < cMatchHistSize; i++ {
+		nm.rep_lens[i] = lz.accel.match_len(lookahead_ofs, lzs.match_hist[i], max_len)
+		if nm.rep_lens[i] > nm.longest_len {
+			nm.longest_len = nm.rep_lens[i]
+			nm.longest_dist = -1 - int32(i)
+		}
+	}
+
+	nm.matches = lz.accel.find_matches(lookahead_ofs)
+	for _, m := range nm.matches {
+		if m.is_last() {
+			if len := LZHAM_MIN(uint32(m.get_len()), max_len); len > nm.longest_len {
+				nm.longest_len = len
+				nm.longest_dist = int32(m.get_dist())
+			}
+			break
+		}
+	}
+
+	nm.len2_dist = 0
+	if max_len >= cMinMatchLen {
+		nm.len2_dist = lz.accel.get_len2_match(lookahead_ofs)
+	}
+}
+
+// relax records a cheaper way of reaching node, from a parent whose path has parent_complexity. Equal costs go to
+// the path that's quicker to decode, and penalty per unit of decision complexity is added to the cost so that near
+// ties do too.
+func (node *parse_node) relax(total_cost bit_cost_t, parent_complexity uint32, penalty bit_cost_t, parent_index int32, pos, len, dist int32) {
+	complexity := get_decision_complexity(len, dist)
+	total_cost += penalty * bit_cost_t(complexity)
+	total_complexity := parent_complexity + complexity
+
+	if total_cost < node.total_cost || (total_cost == node.total_cost && total_complexity < node.total_complexity) {
+		node.total_cost = total_cost
+		node.total_complexity = total_complexity
+		node.parent_index = parent_index
+		node.lzdec.init(pos, len, dist)
+	}
+}
+
+// optimal_parse parses the lookahead range in parse_state in graphs of up to cMaxParseGraphNodes positions, finding
+// the cheapest path of literals, matches and rep matches through each with the current model costs. Every node
+// tracks its own LZ state and match history, the models themselves stay fixed for the whole range.
+func (lz *lzcompressor) optimal_parse(parse_state *parse_thread_state) bool {
+	parse_state.failed = true
+	parse_state.best_decisions = parse_state.best_decisions[:0]
+
+	if len(parse_state.nodes) < int(cMaxParseGraphNodes)+1 {
+		parse_state.nodes = make([]parse_node, cMaxParseGraphNodes+1)
+	}
+
+	lzs := parse_state.initial_state
+	end_ofs := parse_state.start_ofs + parse_state.bytes_to_match
+
+	for cur_ofs := parse_state.start_ofs; cur_ofs < end_ofs; {
+		// A graph at the higher levels can take a while, so a cancel is noticed between them.
+		if lz.canceled() {
+			return false
+		}
+		bytes_to_parse := LZHAM_MIN(end_ofs-cur_ofs, cMaxParseGraphNodes)
+		bytes_to_parse = lz.parse_graph(parse_state.nodes, cur_ofs, bytes_to_parse, &lzs)
+
+		// Walk back from the end of the graph, then emit the decisions in order.
+		first := len(parse_state.best_decisions)
+		for i := int32(bytes_to_parse); i > 0; i = parse_state.nodes[i].parent_index {
+			parse_state.best_decisions = append(parse_state.best_decisions, parse_state.nodes[i].lzdec)
+		}
+		decisions := parse_state.best_decisions[first:]
+		for i, j := 0, len(decisions)-1; i < j; i, j = i+1, j-1 {
+			decisions[i], decisions[j] = decisions[j], decisions[i]
+		}
+
+		lzs = parse_state.nodes[bytes_to_parse].lzstate
+		cur_ofs += bytes_to_parse
+	}
+
+	parse_state.failed = false
+	return true
+}
+
+// parse_graph finds the cheapest path through the bytes_to_parse positions starting at lookahead offset start_ofs,
+// leaving it in nodes. A match of at least fast_bytes is always taken and ends the graph early, the number of
+// positions actually parsed is returned. The final LZ state is left in the last node.
+func (lz *lzcompressor) parse_graph(nodes []parse_node, start_ofs, bytes_to_parse uint32, initial_state *lzstate) uint32 {
+	s := &lz.state
+	penalty := lz.complexity_penalty
+
+	for i := uint32(0); i <= bytes_to_parse; i++ {
+		nodes[i].total_cost = cBitCostMax
+	}
+	nodes[0].total_cost = 0
+	nodes[0].total_complexity = 0
+	nodes[0].parent_index = -1
+	nodes[0].lzstate = *initial_state
+
+	for cur_node_index := uint32(0); cur_node_index < bytes_to_parse; cur_node_index++ {
+		cur_node := &nodes[cur_node_index]
+		if cur_node.total_cost == cBitCostMax {
+			continue
+		}
+
+		if cur_node_index > 0 {
+			cur_node.lzstate = nodes[cur_node.parent_index].lzstate
+			cur_node.lzstate.partial_advance(cur_node.lzdec)
+		}
+
+		lzs := &cur_node.lzstate
+		cur_state := lzs.cur_state
+		cur_cost := cur_node.total_cost
+		cur_complexity := cur_node.total_complexity
+		lookahead_ofs := start_ofs + cur_node_index
+		pos := int32(lookahead_ofs)
+		parent := int32(cur_node_index)
+
+		var nm node_matches
+		lz.find_node_matches(&nm, lookahead_ofs, LZHAM_MIN(cMaxMatchLen, bytes_to_parse-cur_node_index), lzs)
+
+		// A match of at least fast_bytes is taken unconditionally, and nothing past it is parsed in this graph.
+		if nm.longest_len >= lz.fast_bytes {
+			next := cur_node_index + nm.longest_len
+			nodes[next].relax(cur_cost+s.get_match_cost(cur_state, nm.longest_len, nm.longest_dist, lzs), cur_complexity, penalty, parent, pos, int32(nm.longest_len), nm.longest_dist)
+			bytes_to_parse = next
+			continue
+		}
+
+		nodes[cur_node_index+1].relax(cur_cost+s.get_lit_cost(lz, lzs, pos), cur_complexity, penalty, parent, pos, 0, 0)
+
+		for i := uint32(0); i < cMatchHistSize; i++ {
+			if i == 0 && nm.rep_lens[0] >= 1 {
+				nodes[cur_node_index+1].relax(cur_cost+s.get_rep_cost(cur_state, 0, 1), cur_complexity, penalty, parent, pos, 1, -1)
+			}
+			for len := uint32(cMinMatchLen); len <= nm.rep_lens[i]; len++ {
+				nodes[cur_node_index+len].relax(cur_cost+s.get_rep_cost(cur_state, i, len), cur_complexity, penalty, parent, pos, int32(len), -1-int32(i))
+			}
+		}
+
+		if nm.len2_dist != 0 {
+			cost := cur_cost + s.get_full_match_cost(cur_state, nm.len2_dist, cMinMatchLen)
+			nodes[cur_node_index+cMinMatchLen].relax(cost, cur_complexity, penalty, parent, pos, cMinMatchLen, int32(nm.len2_dist))
+		}
+
+		max_admissable_match_len := nm.max_len
+		matches := nm.matches
+		prev_len := uint32(cMinMatchLen)
+		for _, m := range matches {
+			dist := m.get_dist()
+			match_len := LZHAM_MIN(uint32(m.get_len()), max_admissable_match_len)
+			if match_len > prev_len {
+				dist_cost, slot := s.get_dist_cost(cur_state, dist)
+				dist_cost += cur_cost
+				for len := prev_len + 1; len <= match_len; len++ {
+					nodes[cur_node_index+len].relax(dist_cost+s.get_match_len_cost(cur_state, slot, len), cur_complexity, penalty, parent, pos, int32(len), int32(dist))
+				}
+				prev_len = match_len
+			}
+			if m.is_last() {
+				break
+			}
+		}
+	}
+
+	final_node := &nodes[bytes_to_parse]
+	final_node.lzstate = nodes[final_node.parent_index].lzstate
+	final_node.lzstate.partial_advance(final_node.lzdec)
+
+	return bytes_to_parse
+}
+
+// parse_arrival is one way of reaching an extreme parse node: its cost, the arrival of the parent node it came
+// from, the decision taken there and the LZ state it leads to.
+type parse_arrival struct {
+	total_cost         bit_cost_t
+	total_complexity   uint32
+	parent_index       int32
+	parent_state_index int32
+
+	lzdec   lzdecision
+	lzstate lzstate
+}
+
+// extreme_parse_node keeps the cheapest arrivals at a position, in increasing cost order, each with a different LZ state.
+type extreme_parse_node struct {
+	num_arrivals uint32
+	arrivals     [cMaxParseNodeStates]parse_arrival
+}
+
+// cheaper reports whether a path of total_cost and total_complexity is better than arrival, breaking ties by
+// complexity like parse_node.relax.
+func (arrival *parse_arrival) cheaper(total_cost bit_cost_t, total_complexity uint32) bool {
+	return total_cost < arrival.total_cost || (total_cost == arrival.total_cost && total_complexity < arrival.total_complexity)
+}
+
+// add_arrival offers an arrival from parent to the node, which keeps it if it's among the max_arrivals cheapest. An
+// arrival that leads to the same LZ state as a kept one only replaces it if it's cheaper. penalty is added per unit
+// of decision complexity, see parse_node.relax.
+func (node *extreme_parse_node) add_arrival(max_arrivals uint32, penalty, total_cost bit_cost_t, parent_index, parent_state_index int32, parent *parse_arrival, dec lzdecision) {
+	complexity := dec.get_complexity()
+	total_cost += penalty * bit_cost_t(complexity)
+	total_complexity := parent.total_complexity + complexity
+
+	if node.num_arrivals == max_arrivals && !node.arrivals[max_arrivals-1].cheaper(total_cost, total_complexity) {
+		return
+	}
+
+	lzs := parent.lzstate
+	lzs.partial_advance(dec)
+
+	i := node.num_arrivals
+	for j := uint32(0); j < node.num_arrivals; j++ {
+		if node.arrivals[j].lzstate == lzs {
+			if !node.arrivals[j].cheaper(total_cost, total_complexity) {
+				return
+			}
+			i = j
+			break
+		}
+	}
+
+	if i == node.num_arrivals {
+		if node.num_arrivals < max_arrivals {
+			node.num_arrivals++
+		} else {
+			i = max_arrivals - 1
+		}
+	}
+
+	for i > 0 && node.arrivals[i-1].cheaper(total_cost, total_complexity) {
+		node.arrivals[i] = node.arrivals[i-1]
+		i--
+	}
+
+	node.arrivals[i] = parse_arrival{
+		total_cost:         total_cost,
+		total_complexity:   total_complexity,
+		parent_index:       parent_index,
+		parent_state_index: parent_state_index,
+		lzdec:              dec,
+		lzstate:            lzs,
+	}
+}
+
+// extreme_parse is optimal_parse keeping up to extreme_parsing_max_best_arrivals arrivals per node instead of one,
+// so a path that is slightly more expensive early but leaves a better LZ state or match history can still win.
+func (lz *lzcompressor) extreme_parse(parse_state *parse_thread_state) bool {
+	parse_state.failed = true
+	parse_state.best_decisions = parse_state.best_decisions[:0]
+
+	if len(parse_state.extreme_nodes) < int(cMaxParseGraphNodes)+1 {
+		parse_state.extreme_nodes = make([]extreme_parse_node, cMaxParseGraphNodes+1)
+	}
+	nodes := parse_state.extreme_nodes
+
+	lzs := parse_state.initial_state
+	end_ofs := parse_state.start_ofs + parse_state.bytes_to_match
+
+	for cur_ofs := parse_state.start_ofs; cur_ofs < end_ofs; {
+		if lz.canceled() {
+			return false
+		}
+		bytes_to_parse := LZHAM_MIN(end_ofs-cur_ofs, cMaxParseGraphNodes)
+		bytes_to_parse = lz.extreme_parse_graph(nodes, cur_ofs, bytes_to_parse, &lzs)
+
+		first := len(parse_state.best_decisions)
+		for i, state_index := int32(bytes_to_parse), int32(0); i > 0; {
+			arrival := &nodes[i].arrivals[state_index]
+			parse_state.best_decisions = append(parse_state.best_decisions, arrival.lzdec)
+			i, state_index = arrival.parent_index, arrival.parent_state_index
+		}
+		decisions := parse_state.best_decisions[first:]
+		for i, j := 0, len(decisions)-1; i < j; i, j = i+1, j-1 {
+			decisions[i], decisions[j] = decisions[j], decisions[i]
+		}
+
+		lzs = nodes[bytes_to_parse].arrivals[0].lzstate
+		cur_ofs += bytes_to_parse
+	}
+
+	parse_state.failed = false
+	return true
+}
+
+// extreme_parse_graph is parse_graph for extreme_parse_nodes. The cheapest arrival at the last node is the result.
+func (lz *lzcompressor) extreme_parse_graph(nodes []extreme_parse_node, start_ofs, bytes_to_parse uint32, initial_state *lzstate) uint32 {
+	s := &lz.state
+	max_arrivals := lz.params.extreme_parsing_max_best_arrivals
+	penalty := lz.complexity_penalty
+
+	for i := uint32(0); i <= bytes_to_parse; i++ {
+		nodes[i].num_arrivals = 0
+	}
+	nodes[0].num_arrivals = 1
+	nodes[0].arrivals[0] = parse_arrival{parent_index: -1, lzstate: *initial_state}
+
+	var nm node_matches
+	var dec lzdecision
+
+	for cur_node_index := uint32(0); cur_node_index < bytes_to_parse; cur_node_index++ {
+		cur_node := &nodes[cur_node_index]
+		if cur_node.num_arrivals == 0 {
+			continue
+		}
+
+		lookahead_ofs := start_ofs + cur_node_index
+		pos := int32(lookahead_ofs)
+		parent := int32(cur_node_index)
+		max_len := LZHAM_MIN(cMaxMatchLen, bytes_to_parse-cur_node_index)
+
+		// Full and len2 matches don't depend on the arrival.
+		lz.find_node_matches(&nm, lookahead_ofs, max_len, &cur_node.arrivals[0].lzstate)
+		matches := nm.matches
+		len2_dist := nm.len2_dist
+
+		ended := false
+		for state_index := uint32(0); state_index < cur_node.num_arrivals; state_index++ {
+			arrival := &cur_node.arrivals[state_index]
+			lzs := &arrival.lzstate
+			cur_state := lzs.cur_state
+			cur_cost := arrival.total_cost
+			parent_state := int32(state_index)
+
+			if state_index > 0 {
+				lz.find_node_matches(&nm, lookahead_ofs, max_len, lzs)
+			}
+
+			if nm.longest_len >= lz.fast_bytes {
+				next := cur_node_index + nm.longest_len
+				dec.init(pos, int32(nm.longest_len), nm.longest_dist)
+				nodes[next].add_arrival(max_arrivals, penalty, cur_cost+s.get_match_cost(cur_state, nm.longest_len, nm.longest_dist, lzs), parent, parent_state, arrival, dec)
+				if !ended {
+					bytes_to_parse = next
+					ended = true
+				}
+				continue
+			}
+
+			dec.init(pos, 0, 0)
+			nodes[cur_node_index+1].add_arrival(max_arrivals, penalty, cur_cost+s.get_lit_cost(lz, lzs, pos), parent, parent_state, arrival, dec)
+
+			for i := uint32(0); i < cMatchHistSize; i++ {
+				if i == 0 && nm.rep_lens[0] >= 1 {
+					dec.init(pos, 1, -1)
+					nodes[cur_node_index+1].add_arrival(max_arrivals, penalty, cur_cost+s.get_rep_cost(cur_state, 0, 1), parent, parent_state, arrival, dec)
+				}
+				for len := uint32(cMinMatchLen); len <= nm.rep_lens[i] && cur_node_index+len <= bytes_to_parse; len++ {
+					dec.init(pos, int32(len), -1-int32(i))
+					nodes[cur_node_index+len].add_arrival(max_arrivals, penalty, cur_cost+s.get_rep_cost(cur_state, i, len), parent, parent_state, arrival, dec)
+				}
+			}
+
+			if len2_dist != 0 {
+				dec.init(pos, cMinMatchLen, int32(len2_dist))
+				cost := cur_cost + s.get_full_match_cost(cur_state, len2_dist, cMinMatchLen)
+				nodes[cur_node_index+cMinMatchLen].add_arrival(max_arrivals, penalty, cost, parent, parent_state, arrival, dec)
+			}
+
+			prev_len := uint32(cMinMatchLen)
+			for _, m := range matches {
+				dist := m.get_dist()
+				match_len := LZHAM_MIN(uint32(m.get_len()), max_len)
+				if match_len > prev_len {
+					dist_cost, slot := s.get_dist_cost(cur_state, dist)
+					dist_cost += cur_cost
+					for len := prev_len + 1; len <= match_len && cur_node_index+len <= bytes_to_parse; len++ {
+						dec.init(pos, int32(len), int32(dist))
+						nodes[cur_node_index+len].add_arrival(max_arrivals, penalty, dist_cost+s.get_match_len_cost(cur_state, slot, len), parent, parent_state, arrival, dec)
+					}
+					prev_len = match_len
+				}
+				if m.is_last() {
+					break
+				}
+			}
+		}
+	}
+
+	return bytes_to_parse
+}
+
+// get_parse_round_size returns how many bytes to give parse_block at a time: a parse graph for each job. The models
+// only move on as decisions are coded between rounds, so short rounds keep the costs the parsers see current.
+func (lz *lzcompressor) get_parse_round_size() uint32 {
+	return lz.num_parse_jobs * cMaxParseGraphNodes
+}
+
+// parse_block parses bytes_to_parse bytes of the lookahead from start_ofs, splitting them into up to num_parse_jobs
+// jobs of about a parse graph each, which num_parse_threads goroutines share: this one and the task pool's. The
+// models are fixed while parsing and only read, so the jobs share them. Every job starts from the LZ state at
+// start_ofs, which is only exact for the first one, and stitch_decisions fixes up the rest in order, so the result
+// depends on neither scheduling nor the number of goroutines.
+func (lz *lzcompressor) parse_block(start_ofs, bytes_to_parse uint32) ([]lzdecision, bool) {
+	num_jobs := LZHAM_MAX(LZHAM_MIN(lz.num_parse_jobs, (bytes_to_parse+cMaxParseGraphNodes-1)/cMaxParseGraphNodes), 1)
+	job_size := (bytes_to_parse + num_jobs - 1) / num_jobs
+
+	for i := uint32(0); i < num_jobs; i++ {
+		job := &lz.parse_thread_states[i]
+		job.start_ofs = start_ofs + i*job_size
+		job.bytes_to_match = LZHAM_MIN(job_size, bytes_to_parse-i*job_size)
+		job.initial_state = lz.state.lzstate
+	}
+
+	// Goroutine t parses jobs t, t+num_threads, and so on.
+	num_threads := LZHAM_MIN(lz.num_parse_threads, num_jobs)
+	parse_jobs := func(first uint32) {
+		for i := first; i < num_jobs; i += num_threads {
+			lz.parse(&lz.parse_thread_states[i])
+		}
+	}
+
+	for t := uint32(1); t < num_threads; t++ {
+		first := t
+		lz.pTask_pool.queue_task(func() { parse_jobs(first) })
+	}
+	parse_jobs(0)
+	lz.pTask_pool.join()
+
+	// A lone job started from the real state, so there's nothing to stitch.
+	if num_jobs == 1 {
+		job := &lz.parse_thread_states[0]
+		return job.best_decisions, !job.failed
+	}
+
+	lz.block_decisions = lz.block_decisions[:0]
+	actual := lz.state.lzstate
+	for i := uint32(0); i < num_jobs; i++ {
+		job := &lz.parse_thread_states[i]
+		if job.failed {
+			return nil, false
+		}
+		lz.block_decisions = stitch_decisions(lz.block_decisions, job.best_decisions, job.initial_state, &actual)
+	}
+
+	return lz.block_decisions, true
+}
+
+// stitch_decisions appends a job's decisions to dst, rewriting its rep matches for the LZ state the previous jobs
+// actually left in actual. A rep match whose distance isn't in the real history becomes a full match, or literals
+// where a full match can't be coded (a short rep, or a len2 match too far away).
+func stitch_decisions(dst, decisions []lzdecision, job_state lzstate, actual *lzstate) []lzdecision {
+	for _, dec := range decisions {
+		out := dec
+		if dec.is_rep() {
+			dist := dec.get_match_dist(&job_state.match_hist)
+			rep_index := -1
+			for i := 0; i < cMatchHistSize; i++ {
+				if actual.match_hist[i] == dist {
+					rep_index = i
+					break
+				}
+			}
+
+			switch {
+			case dec.len == 1 && rep_index != 0:
+				out.init(dec.pos, 0, 0)
+			case rep_index >= 0:
+				out.dist = -1 - int32(rep_index)
+			case dec.len > cMinMatchLen || dist <= cMaxLen2MatchDist:
+				out.dist = int32(dist)
+			default:
+				for i := int32(0); i < dec.len; i++ {
+					var lit lzdecision
+					lit.init(dec.pos+i, 0, 0)
+					dst = append(dst, lit)
+					actual.partial_advance(lit)
+				}
+				job_state.partial_advance(dec)
+				continue
+			}
+		}
+
+		dst = append(dst, out)
+		job_state.partial_advance(dec)
+		actual.partial_advance(out)
+	}
+	return dst
+}
@@ -0,0 +1,440 @@
+package lzham
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// parse_test_data runs the compressor's match finder and parser over data one block at a time, in graph sized
+// pieces, moving the compressor's models past each piece before parsing the next. It returns the decisions and
+// their total cost.
+func parse_test_data(tb testing.TB, lz *lzcompressor, data []byte, parse func(*parse_thread_state) bool) ([]lzdecision, bit_cost_t) {
+	var decisions []lzdecision
+	var parse_state parse_thread_state
+	var total_cost bit_cost_t
+
+	for ofs := 0; ofs < len(data); {
+		num_bytes := minimum(uint32(len(data)-ofs), lz.params.block_size)
+		num_bytes = minimum(num_bytes, lz.accel.get_max_add_bytes())
+
+		if !lz.accel.add_bytes_begin(num_bytes, data[ofs:]) {
+			tb.Fatalf("add_bytes_begin failed at %d", ofs)
+		}
+		lz.accel.add_bytes_end()
+
+		for start_ofs := uint32(0); start_ofs < num_bytes; start_ofs += parse_state.bytes_to_match {
+			parse_state.start_ofs = start_ofs
+			parse_state.bytes_to_match = minimum(num_bytes-start_ofs, cMaxParseGraphNodes)
+			parse_state.initial_state = lz.state.lzstate
+			if !parse(&parse_state) || parse_state.failed {
+				tb.Fatalf("parse failed at %d", ofs)
+			}
+
+			for _, dec := range parse_state.best_decisions {
+				total_cost += lz.state.get_cost(lz, &lz.state.lzstate, dec)
+				lz.state.update(lz, dec)
+
+				dec.pos += int32(ofs)
+				decisions = append(decisions, dec)
+			}
+		}
+
+		lz.accel.advance_bytes(num_bytes)
+		ofs += int(num_bytes)
+	}
+
+	return decisions, total_cost
+}
+
+// parse_blocks_test_data is parse_test_data for lz.parse_block, parsing each block a round at a time.
+func parse_blocks_test_data(tb testing.TB, lz *lzcompressor, data []byte) ([]lzdecision, bit_cost_t) {
+	var decisions []lzdecision
+	var total_cost bit_cost_t
+
+	for ofs := 0; ofs < len(data); {
+		num_bytes := minimum(uint32(len(data)-ofs), lz.params.block_size)
+		num_bytes = minimum(num_bytes, lz.accel.get_max_add_bytes())
+
+		if !lz.accel.add_bytes_begin(num_bytes, data[ofs:]) {
+			tb.Fatalf("add_bytes_begin failed at %d", ofs)
+		}
+		lz.accel.add_bytes_end()
+
+		round_size := lz.get_parse_round_size()
+		for start_ofs := uint32(0); start_ofs < num_bytes; start_ofs += round_size {
+			block_decisions, ok := lz.parse_block(start_ofs, minimum(num_bytes-start_ofs, round_size))
+			if !ok {
+				tb.Fatalf("parse failed at %d", ofs)
+			}
+			for _, dec := range block_decisions {
+				total_cost += lz.state.get_cost(lz, &lz.state.lzstate, dec)
+				lz.state.update(lz, dec)
+
+				dec.pos += int32(ofs)
+				decisions = append(decisions, dec)
+			}
+		}
+
+		lz.accel.advance_bytes(num_bytes)
+		ofs += int(num_bytes)
+	}
+
+	return decisions, total_cost
+}
+
+// replay_decisions rebuilds the data a list of decisions describes, checking that every decision is valid.
+func replay_decisions(tb testing.TB, data []byte, decisions []lzdecision) {
+	match_hist := [cMatchHistSize]uint32{1, 1, 1, 1}
+	out := make([]byte, 0, len(data))
+
+	for _, dec := range decisions {
+		if int(dec.pos) != len(out) {
+			tb.Fatalf("decision at %d, expected %d", dec.pos, len(out))
+		}
+		if dec.is_lit() {
+			out = append(out, data[len(out)])
+		} else {
+			dist := int(dec.get_match_dist(&match_hist))
+			if dist == 0 || dist > len(out) {
+				tb.Fatalf("pos %d: bad match dist %d", dec.pos, dist)
+			}
+			for i := uint32(0); i < dec.get_len(); i++ {
+				out = append(out, out[len(out)-dist])
+			}
+		}
+		update_match_hist(&match_hist, dec)
+	}
+
+	if len(out) != len(data) {
+		tb.Fatalf("decisions cover %d bytes, want %d", len(out), len(data))
+	}
+	for i := range out {
+		if out[i] != data[i] {
+			tb.Fatalf("mismatch at %d", i)
+		}
+	}
+}
+
+func new_test_compressor(tb testing.TB, level compression_level, dict_size_log2 uint32) *lzcompressor {
+	lz := &lzcompressor{}
+	params := init_params{compression_level: level, dict_size_log2: dict_size_log2}
+	if !lz.init(&params) {
+		tb.Fatal("init failed")
+	}
+	return lz
+}
+
+func Test_greedy_parse(t *testing.T) {
+	tests := []struct {
+		name  string
+		level compression_level
+	}{
+		{name: "fastest", level: cCompressionLevelFastest},
+		{name: "faster", level: cCompressionLevelFaster},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := gen_test_data(5, 1<<20)
+			lz := new_test_compressor(t, tt.level, 18)
+
+			decisions, _ := parse_test_data(t, lz, data, lz.greedy_parse)
+			replay_decisions(t, data, decisions)
+
+			var num_lits, num_reps int
+			for _, dec := range decisions {
+				if dec.is_lit() {
+					num_lits++
+				} else if dec.is_rep() {
+					num_reps++
+				}
+			}
+			if num_lits >= len(data)/4 || num_reps == 0 {
+				t.Errorf("%d decisions: %d literals, %d rep matches", len(decisions), num_lits, num_reps)
+			}
+		})
+	}
+}
+
+// gen_test_log returns log-like text: timestamped lines built from a handful of templates and fields.
+func gen_test_log(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+
+	levels := []string{"INFO", "WARN", "DEBUG", "ERROR"}
+	msgs := []string{"request completed", "cache miss for key", "connection reset by peer", "retrying upload of chunk", "user session refreshed"}
+	hosts := []string{"api-1", "api-2", "worker-7", "db-primary"}
+
+	buf := make([]byte, 0, size+256)
+	t := int64(1700000000000)
+	for len(buf) < size {
+		t += int64(rng.Intn(5000))
+		buf = fmt.Appendf(buf, "%d host=%s level=%s msg=\"%s\" id=%08x latency_ms=%d\n",
+			t, hosts[rng.Intn(len(hosts))], levels[rng.Intn(len(levels))], msgs[rng.Intn(len(msgs))], rng.Uint32(), rng.Intn(2000))
+	}
+	return buf[:size]
+}
+
+func Test_optimal_parse(t *testing.T) {
+	tests := []struct {
+		name  string
+		level compression_level
+	}{
+		{name: "default", level: cCompressionLevelDefault},
+		{name: "better", level: cCompressionLevelBetter},
+		{name: "uber", level: cCompressionLevelUber},
+	}
+
+	size := 512 << 10
+	if testing.Short() || race_enabled {
+		size = 256 << 10
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := gen_test_data(7, size)
+
+			lz := new_test_compressor(t, tt.level, 18)
+			decisions, cost := parse_test_data(t, lz, data, lz.optimal_parse)
+			replay_decisions(t, data, decisions)
+
+			// The greedy parser over the same match finder and models must not come out cheaper.
+			greedy_lz := new_test_compressor(t, tt.level, 18)
+			_, greedy_cost := parse_test_data(t, greedy_lz, data, greedy_lz.greedy_parse)
+
+			t.Logf("%d decisions, %.0f bytes, greedy %.0f bytes", len(decisions), float64(cost)/cBitCostScale/8, float64(greedy_cost)/cBitCostScale/8)
+			if cost >= greedy_cost {
+				t.Errorf("optimal parse cost %d, greedy parse %d", cost, greedy_cost)
+			}
+		})
+	}
+}
+
+func Benchmark_greedy_parse(b *testing.B) {
+	data := gen_test_log(6, 16<<20)
+	for _, level := range []compression_level{cCompressionLevelFastest, cCompressionLevelFaster} {
+		b.Run([]string{"fastest", "faster"}[level], func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				lz := new_test_compressor(b, level, 22)
+				parse_test_data(b, lz, data, lz.greedy_parse)
+			}
+		})
+	}
+}
+
+func new_test_extreme_compressor(tb testing.TB, max_best_arrivals uint32) *lzcompressor {
+	lz := &lzcompressor{}
+	params := init_params{
+		compression_level:                 cCompressionLevelUber,
+		dict_size_log2:                    18,
+		lzham_compress_flags:              uint32(LZHAM_COMP_FLAG_EXTREME_PARSING),
+		extreme_parsing_max_best_arrivals: max_best_arrivals,
+	}
+	if !lz.init(&params) {
+		tb.Fatal("init failed")
+	}
+	return lz
+}
+
+func Test_extreme_parse(t *testing.T) {
+	tests := []struct {
+		name              string
+		max_best_arrivals uint32
+	}{
+		{name: "1 arrival", max_best_arrivals: 1},
+		{name: "4 arrivals", max_best_arrivals: 4},
+		{name: "8 arrivals", max_best_arrivals: 8},
+	}
+
+	size := 256 << 10
+	if testing.Short() || race_enabled {
+		size = 128 << 10
+	}
+
+	data := gen_test_data(7, size)
+
+	// Keeping a single arrival per node is the optimal parser with the extreme fast_bytes.
+	base_lz := new_test_extreme_compressor(t, 1)
+	_, optimal_cost := parse_test_data(t, base_lz, data, base_lz.optimal_parse)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lz := new_test_extreme_compressor(t, tt.max_best_arrivals)
+			if !lz.use_extreme_parsing {
+				t.Fatal("extreme parsing not enabled")
+			}
+
+			decisions, cost := parse_test_data(t, lz, data, lz.parse)
+			replay_decisions(t, data, decisions)
+
+			t.Logf("%d decisions, %.0f bytes, optimal %.0f bytes", len(decisions), float64(cost)/cBitCostScale/8, float64(optimal_cost)/cBitCostScale/8)
+			if tt.max_best_arrivals == 1 && cost != optimal_cost {
+				t.Errorf("extreme parse cost %d with one arrival, optimal parse %d", cost, optimal_cost)
+			}
+			if cost > optimal_cost {
+				t.Errorf("extreme parse cost %d, optimal parse %d", cost, optimal_cost)
+			}
+		})
+	}
+}
+
+// Benchmark_extreme_parse reports the ratio (estimated compressed size over input size) and speed for each
+// number of arrivals kept per node.
+func Benchmark_extreme_parse(b *testing.B) {
+	data := gen_test_log(6, 1<<20)
+	for n := uint32(2); n <= cMaxParseNodeStates; n++ {
+		b.Run(fmt.Sprintf("arrivals=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var cost bit_cost_t
+			for i := 0; i < b.N; i++ {
+				lz := new_test_extreme_compressor(b, n)
+				_, cost = parse_test_data(b, lz, data, lz.extreme_parse)
+			}
+			b.ReportMetric(float64(cost)/cBitCostScale/8/float64(len(data)), "ratio")
+		})
+	}
+}
+
+func new_test_threaded_compressor(tb testing.TB, level compression_level, max_helper_threads, flags uint32) *lzcompressor {
+	lz := &lzcompressor{}
+	params := init_params{compression_level: level, dict_size_log2: 20, max_helper_threads: max_helper_threads, lzham_compress_flags: flags}
+	if !lz.init(&params) {
+		tb.Fatal("init failed")
+	}
+	return lz
+}
+
+func Test_parse_block(t *testing.T) {
+	tests := []struct {
+		name               string
+		level              compression_level
+		max_helper_threads uint32
+		flags              uint32
+		want_threads       uint32
+	}{
+		{name: "faster stays single threaded", level: cCompressionLevelFaster, max_helper_threads: 3, want_threads: 1},
+		{name: "default 2 threads", level: cCompressionLevelDefault, max_helper_threads: 1, want_threads: 2},
+		{name: "uber 4 threads", level: cCompressionLevelUber, max_helper_threads: 3, want_threads: 4},
+		{name: "uber 8 threads", level: cCompressionLevelUber, max_helper_threads: 20, want_threads: cMaxParseThreads},
+		{name: "uber forced single threaded", level: cCompressionLevelUber, max_helper_threads: 20, flags: uint32(LZHAM_COMP_FLAG_FORCE_SINGLE_THREADED_PARSING), want_threads: 1},
+	}
+
+	size := 512 << 10
+	if testing.Short() || race_enabled {
+		size = 256 << 10
+	}
+
+	data := gen_test_data(11, size)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			single_lz := new_test_threaded_compressor(t, tt.level, 0, 0)
+			single, single_cost := parse_blocks_test_data(t, single_lz, data)
+
+			lz := new_test_threaded_compressor(t, tt.level, tt.max_helper_threads, tt.flags)
+			if lz.num_parse_threads != tt.want_threads {
+				t.Fatalf("%d parse threads, want %d", lz.num_parse_threads, tt.want_threads)
+			}
+			decisions, cost := parse_blocks_test_data(t, lz, data)
+			replay_decisions(t, data, decisions)
+
+			// The result must depend on neither how the goroutines were scheduled nor how many there were.
+			if single_cost != cost || len(single) != len(decisions) {
+				t.Fatalf("cost %d with %d decisions, single threaded %d with %d", cost, len(decisions), single_cost, len(single))
+			}
+			for i := range decisions {
+				if single[i] != decisions[i] {
+					t.Fatalf("decision %d differs from the single threaded parse", i)
+				}
+			}
+
+			// Starting jobs from an approximate state should cost very little over parsing a graph at a time.
+			serial_lz := new_test_threaded_compressor(t, tt.level, 0, 0)
+			serial_lz.num_parse_jobs = 1
+			_, serial_cost := parse_blocks_test_data(t, serial_lz, data)
+			t.Logf("%.0f bytes, serial %.0f bytes", float64(cost)/cBitCostScale/8, float64(serial_cost)/cBitCostScale/8)
+			if cost > serial_cost+serial_cost/100 {
+				t.Errorf("parse cost %d, serial %d", cost, serial_cost)
+			}
+		})
+	}
+}
+
+func Benchmark_parse_block(b *testing.B) {
+	data := gen_test_log(6, 4<<20)
+	for _, threads := range []uint32{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				lz := new_test_threaded_compressor(b, cCompressionLevelUber, threads-1, 0)
+				parse_blocks_test_data(b, lz, data)
+			}
+		})
+	}
+}
+
+func Test_parse_complexity_weight(t *testing.T) {
+	tests := []struct {
+		name  string
+		level compression_level
+		flags uint32
+	}{
+		{name: "default", level: cCompressionLevelDefault},
+		{name: "extreme", level: cCompressionLevelUber, flags: uint32(LZHAM_COMP_FLAG_EXTREME_PARSING)},
+	}
+
+	size := 256 << 10
+	if testing.Short() || race_enabled {
+		size = 64 << 10
+	}
+
+	data := gen_test_log(30, size)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parse := func(weight, flags uint32) ([]lzdecision, bit_cost_t, uint64) {
+				lz := &lzcompressor{}
+				params := init_params{compression_level: tt.level, dict_size_log2: 20, lzham_compress_flags: tt.flags | flags, decompression_complexity_weight: weight}
+				if !lz.init(&params) {
+					t.Fatal("init failed")
+				}
+				decisions, cost := parse_blocks_test_data(t, lz, data)
+				replay_decisions(t, data, decisions)
+
+				var complexity uint64
+				for _, dec := range decisions {
+					complexity += uint64(dec.get_complexity())
+				}
+				return decisions, cost, complexity
+			}
+
+			// Each step up in weight should make the data simpler to decode. The parser only gives up the weight's
+			// worth of bits for the complexity it saves, give or take the models adapting to a different parse.
+			base, base_cost, base_complexity := parse(0, 0)
+			prev_complexity := base_complexity
+			for _, weight := range []uint32{16, 64, 256} {
+				_, cost, complexity := parse(weight, 0)
+				t.Logf("weight %d: %.0f bytes (%+.2f%%), complexity %d (%+.2f%%)", weight, float64(cost)/cBitCostScale/8,
+					100*(float64(cost)/float64(base_cost)-1), complexity, 100*(float64(complexity)/float64(base_complexity)-1))
+
+				if complexity >= prev_complexity {
+					t.Errorf("weight %d: complexity %d, the lower weight gave %d", weight, complexity, prev_complexity)
+				}
+				penalty := bit_cost_t(weight) << (cBitCostScaleShift - cComplexityWeightScaleBits)
+				if max_cost := base_cost + penalty*bit_cost_t(base_complexity-complexity) + base_cost/20; cost > max_cost {
+					t.Errorf("weight %d: cost %d, at most %d expected", weight, cost, max_cost)
+				}
+				prev_complexity = complexity
+			}
+
+			// Trading decompression rate for ratio ignores the weight.
+			tradeoff, _, _ := parse(256, uint32(LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO))
+			if len(tradeoff) != len(base) {
+				t.Fatalf("%d decisions, %d without a weight", len(tradeoff), len(base))
+			}
+			for i := range base {
+				if tradeoff[i] != base[i] {
+					t.Fatalf("decision %d differs from the parse without a weight", i)
+				}
+			}
+		})
+	}
+}
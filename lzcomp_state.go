@@ -0,0 +1,421 @@
+package lzham
+
+// lzstate is the part of the coder state a parse has to track per position: the LZ state machine and the match history.
+type lzstate struct {
+	cur_state  uint32
+	match_hist [cMatchHistSize]uint32
+}
+
+func (s *lzstate) clear() {
+	s.cur_state = 0
+	for i := range s.match_hist {
+		s.match_hist[i] = 1
+	}
+}
+
+// partial_advance moves the state machine and match history past a decision.
+func (s *lzstate) partial_advance(dec lzdecision) {
+	if dec.is_lit() {
+		if s.cur_state < 4 {
+			s.cur_state = 0
+		} else if s.cur_state < 10 {
+			s.cur_state -= 3
+		} else {
+			s.cur_state -= 6
+		}
+		return
+	}
+
+	if dec.is_rep() {
+		if dec.get_rep_index() == 0 && dec.len == 1 {
+			s.cur_state = next_state(s.cur_state, 9, 11)
+		} else {
+			s.cur_state = next_state(s.cur_state, 8, 11)
+		}
+	} else {
+		s.cur_state = next_state(s.cur_state, 7, 10)
+	}
+
+	update_match_hist(&s.match_hist, dec)
+}
+
+func next_state(cur_state, after_lit, after_match uint32) uint32 {
+	if cur_state < cNumLitStates {
+		return after_lit
+	}
+	return after_match
+}
+
+// state is the compressor's model of the coder: the LZ state plus every adaptive model, which together give the
+// cost of coding each decision.
+type state struct {
+	lzstate
+
+	is_match_model            [cNumStates]adaptive_bit_model
+	is_rep_model              [cNumStates]adaptive_bit_model
+	is_rep0_model             [cNumStates]adaptive_bit_model
+	is_rep0_single_byte_model [cNumStates]adaptive_bit_model
+	is_rep1_model             [cNumStates]adaptive_bit_model
+	is_rep2_model             [cNumStates]adaptive_bit_model
+
+	lit_table       quasi_adaptive_huffman_data_model
+	delta_lit_table quasi_adaptive_huffman_data_model
+	main_table      quasi_adaptive_huffman_data_model
+	rep_len_table   [2]quasi_adaptive_huffman_data_model
+	large_len_table [2]quasi_adaptive_huffman_data_model
+	dist_lsb_table  quasi_adaptive_huffman_data_model
+}
+
+// A full match's main symbol holds its position slot and up to cNumMainLens lengths. Longer ones code the rest of
+// the length in the secondary large_len_table, rep matches code theirs in rep_len_table. Both length tables are
+// picked by whether the state is a literal state, and end with a huge match code for lengths over cMaxMatchLen,
+// which this compressor never produces.
+const (
+	cNumMainLens     = 8
+	cNumRepLenSyms   = cNumHugeMatchCodes + (cMaxMatchLen - cMinMatchLen + 1)
+	cNumLargeLenSyms = cNumHugeMatchCodes + cLZXNumSecondaryLengths
+)
+
+func get_main_table_size(num_lzx_slots uint32) uint32 {
+	return cLZXNumSpecialLengths + (num_lzx_slots-cLZXLowestUsableMatchSlot)*cNumMainLens
+}
+
+// get_main_sym returns the main symbol of a full match in slot, and whether its length needs a large_len_table symbol.
+func get_main_sym(slot, len uint32) (uint32, bool) {
+	len_index := LZHAM_MIN(len-cMinMatchLen, cNumMainLens-1)
+	return cLZXNumSpecialLengths + (slot-cLZXLowestUsableMatchSlot)*cNumMainLens + len_index, len_index == cNumMainLens-1
+}
+
+// get_len_table_index picks the rep_len_table and large_len_table for the state.
+func get_len_table_index(cur_state uint32) uint32 {
+	if cur_state >= cNumLitStates {
+		return 1
+	}
+	return 0
+}
+
+func (s *state) init(lz *lzcompressor) bool {
+	return s.init_models(true, lz.num_lzx_slots, lz.params.table_max_update_interval, lz.params.table_update_interval_slow_rate)
+}
+
+// init_models sets up the models for encoding or, in the decompressor, decoding.
+func (s *state) init_models(encoding bool, num_lzx_slots, max_update_interval, slow_rate uint32) bool {
+	if !s.lit_table.init(encoding, 256, max_update_interval, slow_rate) {
+		return false
+	}
+	if !s.delta_lit_table.init(encoding, 256, max_update_interval, slow_rate) {
+		return false
+	}
+	if !s.main_table.init(encoding, get_main_table_size(num_lzx_slots), max_update_interval, slow_rate) {
+		return false
+	}
+	for i := range s.rep_len_table {
+		if !s.rep_len_table[i].init(encoding, cNumRepLenSyms, max_update_interval, slow_rate) {
+			return false
+		}
+		if !s.large_len_table[i].init(encoding, cNumLargeLenSyms, max_update_interval, slow_rate) {
+			return false
+		}
+	}
+	if !s.dist_lsb_table.init(encoding, 16, max_update_interval, slow_rate) {
+		return false
+	}
+
+	s.reset()
+	return true
+}
+
+// reset returns the LZ state and every model to its initial state.
+func (s *state) reset() {
+	s.lzstate.clear()
+	s.reset_tables()
+}
+
+// reset_tables returns every model to its initial state, keeping the LZ state.
+func (s *state) reset_tables() {
+	for i := 0; i < cNumStates; i++ {
+		s.is_match_model[i].clear()
+		s.is_rep_model[i].clear()
+		s.is_rep0_model[i].clear()
+		s.is_rep0_single_byte_model[i].clear()
+		s.is_rep1_model[i].clear()
+		s.is_rep2_model[i].clear()
+	}
+
+	s.lit_table.reset()
+	s.delta_lit_table.reset()
+	s.main_table.reset()
+	for i := range s.rep_len_table {
+		s.rep_len_table[i].reset()
+		s.large_len_table[i].reset()
+	}
+	s.dist_lsb_table.reset()
+}
+
+// reset_update_rate makes every Huffman table adapt quickly again.
+func (s *state) reset_update_rate() {
+	s.lit_table.reset_update_rate()
+	s.delta_lit_table.reset_update_rate()
+	s.main_table.reset_update_rate()
+	for i := range s.rep_len_table {
+		s.rep_len_table[i].reset_update_rate()
+		s.large_len_table[i].reset_update_rate()
+	}
+	s.dist_lsb_table.reset_update_rate()
+}
+
+// assign makes s a copy of other that shares nothing with it.
+func (s *state) assign(other *state) {
+	s.lzstate = other.lzstate
+
+	s.is_match_model = other.is_match_model
+	s.is_rep_model = other.is_rep_model
+	s.is_rep0_model = other.is_rep0_model
+	s.is_rep0_single_byte_model = other.is_rep0_single_byte_model
+	s.is_rep1_model = other.is_rep1_model
+	s.is_rep2_model = other.is_rep2_model
+
+	s.lit_table.assign(&other.lit_table)
+	s.delta_lit_table.assign(&other.delta_lit_table)
+	s.main_table.assign(&other.main_table)
+	for i := range s.rep_len_table {
+		s.rep_len_table[i].assign(&other.rep_len_table[i])
+		s.large_len_table[i].assign(&other.large_len_table[i])
+	}
+	s.dist_lsb_table.assign(&other.dist_lsb_table)
+}
+
+// get_delta_lit returns the literal at lookahead offset pos XORed with the byte at the rep0 distance. A literal
+// straight after a match is coded this way, since it often differs from the byte the match would have continued with
+// in only a few bits.
+func get_delta_lit(lz *lzcompressor, lzs *lzstate, pos int32) uint32 {
+	return uint32(lz.accel.get_char(pos) ^ lz.accel.get_hist_char(pos, lzs.match_hist[0]))
+}
+
+// get_lit_cost returns the cost of coding the byte at lookahead offset pos as a literal from the LZ state lzs.
+func (s *state) get_lit_cost(lz *lzcompressor, lzs *lzstate, pos int32) bit_cost_t {
+	cost := s.is_match_model[lzs.cur_state].get_cost(0)
+	if lzs.cur_state < cNumLitStates {
+		return cost + s.lit_table.get_cost(uint32(lz.accel.get_char(pos)))
+	}
+	return cost + s.delta_lit_table.get_cost(get_delta_lit(lz, lzs, pos))
+}
+
+// is_rep2_bit is the bit that tells rep2 from rep3 matches.
+func is_rep2_bit(rep_index uint32) uint32 {
+	if rep_index == 2 {
+		return 1
+	}
+	return 0
+}
+
+// get_rep_cost returns the cost of a rep match of len bytes. A rep0 match of length 1 codes no length.
+func (s *state) get_rep_cost(cur_state uint32, rep_index uint32, len uint32) bit_cost_t {
+	cost := s.is_match_model[cur_state].get_cost(1) + s.is_rep_model[cur_state].get_cost(1)
+
+	switch rep_index {
+	case 0:
+		cost += s.is_rep0_model[cur_state].get_cost(1)
+		if len == 1 {
+			return cost + s.is_rep0_single_byte_model[cur_state].get_cost(1)
+		}
+		cost += s.is_rep0_single_byte_model[cur_state].get_cost(0)
+	case 1:
+		cost += s.is_rep0_model[cur_state].get_cost(0) + s.is_rep1_model[cur_state].get_cost(1)
+	default:
+		cost += s.is_rep0_model[cur_state].get_cost(0) + s.is_rep1_model[cur_state].get_cost(0) +
+			s.is_rep2_model[cur_state].get_cost(is_rep2_bit(rep_index))
+	}
+
+	return cost + s.rep_len_table[get_len_table_index(cur_state)].get_cost(len-cMinMatchLen)
+}
+
+// get_dist_cost returns the cost of the is_match/is_rep flags and extra bits of a match at dist, which is the same
+// for every length, and the position slot get_match_len_cost needs.
+func (s *state) get_dist_cost(cur_state uint32, dist uint32) (bit_cost_t, uint32) {
+	cost := s.is_match_model[cur_state].get_cost(1) + s.is_rep_model[cur_state].get_cost(0)
+
+	slot, ofs := compute_lzx_position_slot(dist)
+	num_extra_bits := uint32(lzx_position_extra_bits[slot])
+	if num_extra_bits < 3 {
+		cost += convert_to_scaled_bitcost(num_extra_bits)
+	} else {
+		if num_extra_bits > 4 {
+			cost += convert_to_scaled_bitcost(num_extra_bits - 4)
+		}
+		cost += s.dist_lsb_table.get_cost(ofs & 15)
+	}
+
+	return cost, slot
+}
+
+// get_match_len_cost returns the cost of the main symbol and any large length symbol of a len byte full match in slot.
+func (s *state) get_match_len_cost(cur_state, slot, len uint32) bit_cost_t {
+	main_sym, large := get_main_sym(slot, len)
+	cost := s.main_table.get_cost(main_sym)
+	if large {
+		cost += s.large_len_table[get_len_table_index(cur_state)].get_cost(len - cMinMatchLen - (cNumMainLens - 1))
+	}
+	return cost
+}
+
+// get_full_match_cost returns the cost of a full match of len bytes at dist.
+func (s *state) get_full_match_cost(cur_state, dist, len uint32) bit_cost_t {
+	cost, slot := s.get_dist_cost(cur_state, dist)
+	return cost + s.get_match_len_cost(cur_state, slot, len)
+}
+
+// get_match_cost returns the cost of a match or rep match of len bytes, with dist as in an lzdecision.
+func (s *state) get_match_cost(cur_state, len uint32, dist int32, lzs *lzstate) bit_cost_t {
+	if dist < 0 {
+		return s.get_rep_cost(cur_state, uint32(-dist-1), len)
+	}
+	return s.get_full_match_cost(cur_state, uint32(dist), len)
+}
+
+// get_cost returns the cost of coding dec from the LZ state lzs.
+func (s *state) get_cost(lz *lzcompressor, lzs *lzstate, dec lzdecision) bit_cost_t {
+	if dec.is_lit() {
+		return s.get_lit_cost(lz, lzs, dec.pos)
+	}
+	if dec.is_rep() {
+		return s.get_rep_cost(lzs.cur_state, dec.get_rep_index(), uint32(dec.len))
+	}
+	return s.get_full_match_cost(lzs.cur_state, uint32(dec.dist), uint32(dec.len))
+}
+
+// update moves the models past dec the way coding it would, without producing any output.
+func (s *state) update(lz *lzcompressor, dec lzdecision) {
+	cur_state := s.cur_state
+
+	if dec.is_lit() {
+		s.is_match_model[cur_state].update(0)
+		if cur_state < cNumLitStates {
+			s.lit_table.update(uint32(lz.accel.get_char(dec.pos)))
+		} else {
+			s.delta_lit_table.update(get_delta_lit(lz, &s.lzstate, dec.pos))
+		}
+	} else {
+		s.is_match_model[cur_state].update(1)
+
+		if dec.is_rep() {
+			s.is_rep_model[cur_state].update(1)
+
+			rep_index := dec.get_rep_index()
+			switch rep_index {
+			case 0:
+				s.is_rep0_model[cur_state].update(1)
+				if dec.len == 1 {
+					s.is_rep0_single_byte_model[cur_state].update(1)
+				} else {
+					s.is_rep0_single_byte_model[cur_state].update(0)
+				}
+			case 1:
+				s.is_rep0_model[cur_state].update(0)
+				s.is_rep1_model[cur_state].update(1)
+			default:
+				s.is_rep0_model[cur_state].update(0)
+				s.is_rep1_model[cur_state].update(0)
+				s.is_rep2_model[cur_state].update(is_rep2_bit(rep_index))
+			}
+
+			if dec.len > 1 {
+				s.rep_len_table[get_len_table_index(cur_state)].update(uint32(dec.len) - cMinMatchLen)
+			}
+		} else {
+			s.is_rep_model[cur_state].update(0)
+
+			slot, ofs := compute_lzx_position_slot(uint32(dec.dist))
+			main_sym, large := get_main_sym(slot, uint32(dec.len))
+			s.main_table.update(main_sym)
+			if large {
+				s.large_len_table[get_len_table_index(cur_state)].update(uint32(dec.len) - cMinMatchLen - (cNumMainLens - 1))
+			}
+
+			if lzx_position_extra_bits[slot] >= 3 {
+				s.dist_lsb_table.update(ofs & 15)
+			}
+		}
+	}
+
+	s.partial_advance(dec)
+}
+
+// encode codes dec, moving the models and LZ state past it like update.
+func (s *state) encode(codec *symbol_codec, lz *lzcompressor, dec lzdecision) bool {
+	cur_state := s.cur_state
+
+	if dec.is_lit() {
+		codec.encode_bit(0, &s.is_match_model[cur_state])
+		if cur_state < cNumLitStates {
+			if !codec.encode_sym(uint32(lz.accel.get_char(dec.pos)), &s.lit_table) {
+				return false
+			}
+		} else if !codec.encode_sym(get_delta_lit(lz, &s.lzstate, dec.pos), &s.delta_lit_table) {
+			return false
+		}
+		s.partial_advance(dec)
+		return true
+	}
+
+	codec.encode_bit(1, &s.is_match_model[cur_state])
+
+	if dec.is_rep() {
+		codec.encode_bit(1, &s.is_rep_model[cur_state])
+
+		rep_index := dec.get_rep_index()
+		switch rep_index {
+		case 0:
+			codec.encode_bit(1, &s.is_rep0_model[cur_state])
+			if dec.len == 1 {
+				codec.encode_bit(1, &s.is_rep0_single_byte_model[cur_state])
+			} else {
+				codec.encode_bit(0, &s.is_rep0_single_byte_model[cur_state])
+			}
+		case 1:
+			codec.encode_bit(0, &s.is_rep0_model[cur_state])
+			codec.encode_bit(1, &s.is_rep1_model[cur_state])
+		default:
+			codec.encode_bit(0, &s.is_rep0_model[cur_state])
+			codec.encode_bit(0, &s.is_rep1_model[cur_state])
+			codec.encode_bit(is_rep2_bit(rep_index), &s.is_rep2_model[cur_state])
+		}
+
+		if dec.len > 1 && !codec.encode_sym(uint32(dec.len)-cMinMatchLen, &s.rep_len_table[get_len_table_index(cur_state)]) {
+			return false
+		}
+	} else {
+		codec.encode_bit(0, &s.is_rep_model[cur_state])
+
+		slot, ofs := compute_lzx_position_slot(uint32(dec.dist))
+		main_sym, large := get_main_sym(slot, uint32(dec.len))
+		if !codec.encode_sym(main_sym, &s.main_table) {
+			return false
+		}
+		if large && !codec.encode_sym(uint32(dec.len)-cMinMatchLen-(cNumMainLens-1), &s.large_len_table[get_len_table_index(cur_state)]) {
+			return false
+		}
+
+		num_extra_bits := uint32(lzx_position_extra_bits[slot])
+		if num_extra_bits < 3 {
+			codec.encode_bits(ofs, num_extra_bits)
+		} else {
+			if num_extra_bits > 4 {
+				codec.encode_bits(ofs>>4, num_extra_bits-4)
+			}
+			if !codec.encode_sym(ofs&15, &s.dist_lsb_table) {
+				return false
+			}
+		}
+	}
+
+	s.partial_advance(dec)
+	return true
+}
+
+// encode_eob codes the end of block symbol, a full match whose main symbol is cLZXSpecialCodeEndOfBlockCode.
+func (s *state) encode_eob(codec *symbol_codec) bool {
+	codec.encode_bit(1, &s.is_match_model[s.cur_state])
+	codec.encode_bit(0, &s.is_rep_model[s.cur_state])
+	return codec.encode_sym(cLZXSpecialCodeEndOfBlockCode, &s.main_table)
+}
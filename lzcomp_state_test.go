@@ -0,0 +1,150 @@
+package lzham
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func Test_get_main_sym(t *testing.T) {
+	tests := []struct {
+		slot, len  uint32
+		want_sym   uint32
+		want_large bool
+	}{
+		{slot: 1, len: cMinMatchLen, want_sym: cLZXNumSpecialLengths},
+		{slot: 1, len: 8, want_sym: cLZXNumSpecialLengths + 6},
+		{slot: 1, len: 9, want_sym: cLZXNumSpecialLengths + 7, want_large: true},
+		{slot: 1, len: cMaxMatchLen, want_sym: cLZXNumSpecialLengths + 7, want_large: true},
+		{slot: 2, len: 3, want_sym: cLZXNumSpecialLengths + cNumMainLens + 1},
+		{slot: 40, len: 20, want_sym: cLZXNumSpecialLengths + 39*cNumMainLens + 7, want_large: true},
+	}
+
+	for _, tt := range tests {
+		sym, large := get_main_sym(tt.slot, tt.len)
+		if sym != tt.want_sym || large != tt.want_large {
+			t.Errorf("get_main_sym(%d, %d) = %d, %v, want %d, %v", tt.slot, tt.len, sym, large, tt.want_sym, tt.want_large)
+		}
+	}
+
+	// The largest match length is the last large length symbol before the huge match code.
+	if got := cMaxMatchLen - cMinMatchLen - (cNumMainLens - 1); got != cNumLargeLenSyms-cNumHugeMatchCodes-1 {
+		t.Errorf("largest large length symbol %d, table has %d", got, cNumLargeLenSyms)
+	}
+}
+
+// gen_test_sensor_records returns 16-byte sensor records whose readings drift by a few units from one record to the
+// next, so a literal after a match against the previous record differs from the byte it lines up with in low bits.
+func gen_test_sensor_records(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+
+	buf := make([]byte, 0, size+16)
+	var rec [16]byte
+	temp, humidity := uint16(20000), uint16(40000)
+	for seq := uint32(0); len(buf) < size; seq++ {
+		temp += uint16(rng.Intn(7) - 3)
+		humidity += uint16(rng.Intn(5) - 2)
+		copy(rec[0:], "SNSR")
+		rec[4] = 7
+		binary.LittleEndian.PutUint16(rec[5:], temp)
+		binary.LittleEndian.PutUint16(rec[7:], humidity)
+		copy(rec[9:], "OK\x00")
+		binary.LittleEndian.PutUint32(rec[12:], seq)
+		buf = append(buf, rec[:]...)
+	}
+	return buf[:size]
+}
+
+// test_entropy_bits returns the bits an order-0 coder needs for symbols with the given counts.
+func test_entropy_bits(counts []int) float64 {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	var bits float64
+	for _, n := range counts {
+		if n > 0 {
+			bits -= float64(n) * math.Log2(float64(n)/float64(total))
+		}
+	}
+	return bits
+}
+
+func Test_delta_literals(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		// The most the delta form of the literals after matches may cost, as a fraction of the plain form.
+		max_ratio float64
+	}{
+		{name: "sensor records", data: gen_test_sensor_records(12, 256<<10), max_ratio: 0.7},
+		{name: "log text", data: gen_test_log(12, 256<<10), max_ratio: 1.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			lz := new_test_compressor(t, cCompressionLevelDefault, 20)
+
+			// The reference definition: the literal XORed with the byte rep0 bytes back, tracked here independently
+			// of the compressor's own state.
+			match_hist := [cMatchHistSize]uint32{1, 1, 1, 1}
+			after_match := false
+			var plain_counts, delta_counts [256]int
+
+			for ofs := 0; ofs < len(data); {
+				num_bytes := minimum(uint32(len(data)-ofs), lz.params.block_size)
+				num_bytes = minimum(num_bytes, lz.accel.get_max_add_bytes())
+				if !lz.accel.add_bytes_begin(num_bytes, data[ofs:]) {
+					t.Fatalf("add_bytes_begin failed at %d", ofs)
+				}
+				lz.accel.add_bytes_end()
+
+				round_size := lz.get_parse_round_size()
+				for start_ofs := uint32(0); start_ofs < num_bytes; start_ofs += round_size {
+					decisions, ok := lz.parse_block(start_ofs, minimum(num_bytes-start_ofs, round_size))
+					if !ok {
+						t.Fatalf("parse failed at %d", ofs)
+					}
+					for _, dec := range decisions {
+						pos := ofs + int(dec.pos)
+						if dec.is_lit() {
+							if is_delta := lz.state.cur_state >= cNumLitStates; is_delta != after_match {
+								t.Fatalf("pos %d: delta literal %v, but the last decision was a match %v", pos, is_delta, after_match)
+							}
+							if after_match {
+								want := data[pos] ^ data[pos-int(match_hist[0])]
+								if got := get_delta_lit(lz, &lz.state.lzstate, dec.pos); got != uint32(want) {
+									t.Fatalf("pos %d: delta literal %#x, want %#x", pos, got, want)
+								}
+								plain_counts[data[pos]]++
+								delta_counts[want]++
+							}
+						} else {
+							dist := dec.get_match_dist(&match_hist)
+							i := cMatchHistSize - 1
+							if dec.is_rep() {
+								i = int(dec.get_rep_index())
+							}
+							copy(match_hist[1:i+1], match_hist[:i])
+							match_hist[0] = dist
+						}
+						after_match = !dec.is_lit()
+						lz.state.update(lz, dec)
+					}
+				}
+
+				lz.accel.advance_bytes(num_bytes)
+				ofs += int(num_bytes)
+			}
+
+			plain_bits := test_entropy_bits(plain_counts[:])
+			delta_bits := test_entropy_bits(delta_counts[:])
+			t.Logf("literals after matches: plain %.0f bytes, delta %.0f bytes", plain_bits/8, delta_bits/8)
+			if delta_bits > plain_bits*tt.max_ratio {
+				t.Errorf("delta literals need %.0f bits, plain %.0f", delta_bits, plain_bits)
+			}
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package lzham
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// compress_test_stream compresses data through LZHAM_lib_compress, taking in_size bytes of input and giving
+// out_size bytes of output room per call, and flushing with flush_type after every flush_interval bytes.
+func compress_test_stream(tb testing.TB, pState *LZHAM_compress_state, data []byte, in_size, out_size, flush_interval int, flush_type lzham_flush_t) []byte {
+	var comp []byte
+	out := make([]byte, out_size)
+
+	call := func(in []byte, flush lzham_flush_t, want lzham_compress_status_t) {
+		for {
+			in_used, out_used, status := LZHAM_lib_compress(pState, in, out, flush)
+			comp = append(comp, out[:out_used]...)
+			in = in[in_used:]
+			if status == LZHAM_COMP_STATUS_HAS_MORE_OUTPUT {
+				continue
+			}
+			if status != want || len(in) > 0 {
+				tb.Fatalf("status %d with %d bytes left, want %d", status, len(in), want)
+			}
+			return
+		}
+	}
+
+	next_flush := flush_interval
+	for ofs := 0; ofs < len(data); {
+		end := ofs + in_size
+		if end > len(data) {
+			end = len(data)
+		}
+		if flush_interval > 0 && end > next_flush {
+			end = next_flush
+		}
+
+		call(data[ofs:end], LZHAM_NO_FLUSH, LZHAM_COMP_STATUS_NEEDS_MORE_INPUT)
+		if flush_interval > 0 && end == next_flush {
+			call(nil, flush_type, LZHAM_COMP_STATUS_NOT_FINISHED)
+			next_flush += flush_interval
+		}
+		ofs = end
+	}
+	call(nil, LZHAM_FINISH, LZHAM_COMP_STATUS_SUCCESS)
+
+	return comp
+}
+
+func Test_LZHAM_lib_compress(t *testing.T) {
+	data := append(gen_test_log(20, 100<<10), gen_test_records(21, 100<<10)...)
+
+	tests := []struct {
+		name           string
+		level          lzham_compress_level
+		in_size        int
+		out_size       int
+		flush_interval int
+		flush_type     lzham_flush_t
+	}{
+		{name: "whole buffers", level: LZHAM_COMP_LEVEL_DEFAULT, in_size: len(data), out_size: len(data)},
+		{name: "tiny output", level: LZHAM_COMP_LEVEL_FASTEST, in_size: 10000, out_size: 1},
+		{name: "small input and output", level: LZHAM_COMP_LEVEL_BETTER, in_size: 333, out_size: 77},
+		{name: "sync flushes", level: LZHAM_COMP_LEVEL_DEFAULT, in_size: 5000, out_size: 4096, flush_interval: 12345, flush_type: LZHAM_SYNC_FLUSH},
+		{name: "full flushes", level: LZHAM_COMP_LEVEL_UBER, in_size: 5000, out_size: 4096, flush_interval: 30000, flush_type: LZHAM_FULL_FLUSH},
+		{name: "table flushes", level: LZHAM_COMP_LEVEL_FASTER, in_size: 5000, out_size: 4096, flush_interval: 1000, flush_type: LZHAM_TABLE_FLUSH},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := LZHAM_compress_params{dict_size_log2: 17, level: tt.level}
+			pState, err := LZHAM_lib_compress_init(&params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			comp := compress_test_stream(t, pState, data, tt.in_size, tt.out_size, tt.flush_interval, tt.flush_type)
+
+			var d lzdecompressor
+			if !d.init(17, 0, 0, nil) {
+				t.Fatal("init failed")
+			}
+			got, num_blocks := decompress_test_stream(t, &d, comp)
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(data))
+			}
+
+			t.Logf("%d -> %d bytes, %d sync blocks", len(data), len(comp), num_blocks[cSyncBlock])
+			if tt.flush_interval == 0 && num_blocks[cSyncBlock] != 0 {
+				t.Errorf("%d sync blocks without flushing", num_blocks[cSyncBlock])
+			}
+
+			// Once finished, further calls do nothing.
+			if in_used, out_used, status := LZHAM_lib_compress(pState, data, make([]byte, 100), LZHAM_FINISH); in_used != 0 || out_used != 0 || status != LZHAM_COMP_STATUS_SUCCESS {
+				t.Errorf("after finishing: %d, %d, status %d", in_used, out_used, status)
+			}
+
+			// Reinitializing gives the same stream again.
+			if _, err := LZHAM_lib_compress_reinit(&params, pState); err != nil {
+				t.Fatal(err)
+			}
+			if again := compress_test_stream(t, pState, data, tt.in_size, tt.out_size, tt.flush_interval, tt.flush_type); !bytes.Equal(again, comp) {
+				t.Errorf("after reinit, compressed to %d bytes instead of %d", len(again), len(comp))
+			}
+		})
+	}
+}
+
+// Everything put before a sync flush can be decoded from the output so far.
+func Test_LZHAM_lib_compress_sync_flush(t *testing.T) {
+	data := gen_test_log(22, 50<<10)
+
+	params := LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT}
+	pState, err := LZHAM_lib_compress_init(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d lzdecompressor
+	if !d.init(16, 0, 0, nil) {
+		t.Fatal("init failed")
+	}
+
+	var comp, got []byte
+	var ofs uint64
+	out := make([]byte, 64<<10)
+	for _, end := range []int{100, 101, 5000, 30000, len(data)} {
+		in_used, out_used, status := LZHAM_lib_compress(pState, data[len(got):end], out, LZHAM_SYNC_FLUSH)
+		if status != LZHAM_COMP_STATUS_NOT_FINISHED || in_used != uint64(end-len(got)) {
+			t.Fatalf("status %d, %d bytes used", status, in_used)
+		}
+		comp = append(comp, out[:out_used]...)
+
+		for ofs < uint64(len(comp)) {
+			n, block_out, status := d.decompress_block(comp[ofs:], got)
+			if status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+				t.Fatalf("status %d at %d", status, ofs)
+			}
+			got, ofs = block_out, ofs+n
+		}
+		if !bytes.Equal(got, data[:end]) {
+			t.Fatalf("decoded %d bytes after flushing %d", len(got), end)
+		}
+	}
+}
+
+// A flush ends with a sync block laid out as the C library's send_sync_block writes it: the block type and flush code
+// in the top four bits of a byte, then 16 zero bits and 16 one bits.
+func Test_send_sync_block(t *testing.T) {
+	tests := []struct {
+		name       string
+		flush_type lzham_flush_t
+		data       []byte
+		want       []byte
+	}{
+		{name: "sync", flush_type: LZHAM_SYNC_FLUSH, want: []byte{0x00, 0x00, 0x00, 0xFF, 0xFF}},
+		{name: "table", flush_type: LZHAM_TABLE_FLUSH, want: []byte{0x10, 0x00, 0x00, 0xFF, 0xFF}},
+		{name: "full", flush_type: LZHAM_FULL_FLUSH, want: []byte{0x20, 0x00, 0x00, 0xFF, 0xFF}},
+		{name: "sync after data", flush_type: LZHAM_SYNC_FLUSH, data: gen_test_log(23, 10000), want: []byte{0x00, 0x00, 0x00, 0xFF, 0xFF}},
+		{name: "full after data", flush_type: LZHAM_FULL_FLUSH, data: gen_test_log(23, 10000), want: []byte{0x20, 0x00, 0x00, 0xFF, 0xFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pState, err := LZHAM_lib_compress_init(&LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := make([]byte, 64<<10)
+			_, out_used, status := LZHAM_lib_compress(pState, tt.data, out, tt.flush_type)
+			if status != LZHAM_COMP_STATUS_NOT_FINISHED {
+				t.Fatalf("status %d", status)
+			}
+			comp := out[:out_used]
+			if len(tt.data) == 0 && len(comp) != len(tt.want) {
+				t.Errorf("flushing nothing gave %d bytes, want just the %d byte sync block", len(comp), len(tt.want))
+			}
+			if !bytes.HasSuffix(comp, tt.want) {
+				t.Errorf("flush ends with % x, want % x", comp[max(0, len(comp)-len(tt.want)):], tt.want)
+			}
+		})
+	}
+}
+
+func Test_raw_block_header(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(27)).Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{name: "5000 bytes", data: random, want: []byte{0x80, 0x04, 0xE1, 0xE5, 0x00}},
+		{name: "4096 bytes", data: random[:4096], want: []byte{0x80, 0x03, 0xFF, 0xFC, 0x00}},
+		{name: "one byte", data: random[:1], want: []byte{0x80, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pState, err := LZHAM_lib_compress_init(&LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := make([]byte, 64<<10)
+			_, out_used, status := LZHAM_lib_compress(pState, tt.data, out, LZHAM_FINISH)
+			if status != LZHAM_COMP_STATUS_SUCCESS {
+				t.Fatalf("status %d", status)
+			}
+			comp := out[:out_used]
+			if len(comp) != cRawBlockHeaderSize+len(tt.data)+cEOFBlockSize {
+				t.Fatalf("%d bytes, want a raw block of %d bytes and the EOF block", len(comp), len(tt.data))
+			}
+			if !bytes.Equal(comp[:cRawBlockHeaderSize], tt.want) {
+				t.Errorf("raw block header % x, want % x", comp[:cRawBlockHeaderSize], tt.want)
+			}
+			if !bytes.Equal(comp[cRawBlockHeaderSize:cRawBlockHeaderSize+len(tt.data)], tt.data) {
+				t.Error("raw block doesn't hold the data")
+			}
+
+			var d lzdecompressor
+			if !d.init(16, 0, 0, nil) {
+				t.Fatal("init failed")
+			}
+			got, _ := decompress_test_stream(t, &d, comp)
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("decoded %d bytes, which don't match the %d input bytes", len(got), len(tt.data))
+			}
+
+			bad := append([]byte(nil), comp...)
+			bad[3] ^= 0x04
+			d.reset()
+			if _, _, status := d.decompress_block(bad, nil); status != LZHAM_DECOMP_STATUS_FAILED_BAD_RAW_BLOCK {
+				t.Errorf("header with a bad length check decoded with status %d", status)
+			}
+		})
+	}
+}
+
+func Test_LZHAM_lib_compress_memory(t *testing.T) {
+	random := make([]byte, 100<<10)
+	rand.New(rand.NewSource(23)).Read(random)
+	seed := gen_test_log(24, 20<<10)
+
+	tests := []struct {
+		name   string
+		params LZHAM_compress_params
+		data   []byte
+	}{
+		{name: "text", params: LZHAM_compress_params{dict_size_log2: 20, level: LZHAM_COMP_LEVEL_DEFAULT}, data: gen_test_data(25, 300<<10)},
+		{name: "random", params: LZHAM_compress_params{dict_size_log2: 15, level: LZHAM_COMP_LEVEL_FASTEST}, data: random},
+		{name: "empty", params: LZHAM_compress_params{dict_size_log2: 15, level: LZHAM_COMP_LEVEL_UBER}},
+		{name: "one byte", params: LZHAM_compress_params{dict_size_log2: 15, level: LZHAM_COMP_LEVEL_UBER}, data: []byte{42}},
+		{name: "zlib stream", params: LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_BETTER, compress_flags: uint32(LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM)}, data: gen_test_records(26, 100<<10)},
+		{name: "seeded random", params: LZHAM_compress_params{dict_size_log2: 15, level: LZHAM_COMP_LEVEL_FASTER, num_seed_bytes: uint32(len(seed)), pSeed_bytes: seed}, data: random},
+		{name: "seeded log", params: LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT, num_seed_bytes: uint32(len(seed)), pSeed_bytes: seed}, data: seed[:10000]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound := LZHAM_lib_compress_bound(uint64(len(tt.data)), &tt.params)
+			comp := make([]byte, bound)
+			comp_len, adler, status := LZHAM_lib_compress_memory(&tt.params, comp, tt.data)
+			if status != LZHAM_COMP_STATUS_SUCCESS {
+				t.Fatalf("status %d", status)
+			}
+			comp = comp[:comp_len]
+			t.Logf("%d -> %d bytes, bound %d", len(tt.data), comp_len, bound)
+
+			if want := lzham_adler32_update(1, tt.data); adler != want {
+				t.Errorf("adler32 %#x, want %#x", adler, want)
+			}
+
+			if _, _, status := LZHAM_lib_compress_memory(&tt.params, make([]byte, comp_len-1), tt.data); status != LZHAM_COMP_STATUS_OUTPUT_BUF_TOO_SMALL {
+				t.Errorf("one byte short: status %d", status)
+			}
+
+			dparams := LZHAM_decompress_params{
+				dict_size_log2: tt.params.dict_size_log2,
+				num_seed_bytes: tt.params.num_seed_bytes,
+				pSeed_bytes:    tt.params.pSeed_bytes,
+			}
+			if tt.params.compress_flags&uint32(LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM) != 0 {
+				dparams.decompress_flags = uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM)
+			}
+			got := make([]byte, len(tt.data))
+			n, dadler, dstatus := LZHAM_lib_decompress_memory(&dparams, got, comp)
+			if dstatus != LZHAM_DECOMP_STATUS_SUCCESS {
+				t.Fatalf("decompress status %d", dstatus)
+			}
+			if !bytes.Equal(got[:n], tt.data) || dadler != adler {
+				t.Errorf("decompressed %d bytes with adler32 %#x, which don't match", n, dadler)
+			}
+		})
+	}
+}
+
+func TestCompressMemory(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *CompressOptions
+		data []byte
+	}{
+		{name: "default options", data: gen_test_log(65, 100<<10)},
+		{name: "zlib", opts: &CompressOptions{DictSizeLog2: 16, Level: LZHAM_COMP_LEVEL_FASTEST, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM}, data: gen_test_records(66, 100<<10)},
+		{name: "seeded", opts: &CompressOptions{DictSizeLog2: 16, SeedBytes: gen_test_log(67, 10<<10)}, data: gen_test_log(67, 50<<10)},
+		{name: "empty", opts: &CompressOptions{DictSizeLog2: 15}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp := make([]byte, CompressBound(uint64(len(tt.data)), tt.opts))
+			n, err := CompressMemory(comp, tt.data, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			comp = comp[:n]
+
+			var decomp_opts *DecompressOptions
+			if tt.opts != nil {
+				decomp_opts = &DecompressOptions{DictSizeLog2: tt.opts.DictSizeLog2, SeedBytes: tt.opts.SeedBytes}
+				if tt.opts.Flags&LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM != 0 {
+					decomp_opts.Flags = LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM
+				}
+			}
+			out := make([]byte, len(tt.data))
+			out_len, err := DecompressMemory(out, comp, decomp_opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out[:out_len], tt.data) {
+				t.Fatalf("decompressed %d bytes, which don't match", out_len)
+			}
+
+			if _, err := CompressMemory(make([]byte, n-1), tt.data, tt.opts); !errors.Is(err, ErrOutputBufTooSmall) {
+				t.Errorf("CompressMemory() one byte short = %v, want ErrOutputBufTooSmall", err)
+			}
+		})
+	}
+
+	if _, err := CompressMemory(make([]byte, 100), nil, &CompressOptions{DictSizeLog2: 40}); !errors.Is(err, ErrInvalidDictSizeLog2) {
+		t.Errorf("CompressMemory() with a bad dictionary = %v", err)
+	}
+}
+
+// Test_LZHAM_lib_compress_memory_speed holds the greedy levels to the throughput Benchmark_LZHAM_lib_compress_memory
+// measures on log text, and to a better ratio than deflate's fastest level. Timings mean nothing under the race
+// detector, so it only runs in full test runs without it.
+func Test_LZHAM_lib_compress_memory_speed(t *testing.T) {
+	if testing.Short() || race_enabled {
+		t.Skip("timing compression needs a full test run without the race detector")
+	}
+	data := gen_test_log(6, 16<<20)
+
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		params     LZHAM_compress_params
+		min_mb_sec float64
+	}{
+		{name: "fastest", params: LZHAM_compress_params{dict_size_log2: 22, level: LZHAM_COMP_LEVEL_FASTEST}, min_mb_sec: 10},
+		{name: "fastest with fastest tables", params: LZHAM_compress_params{dict_size_log2: 22, level: LZHAM_COMP_LEVEL_FASTEST, table_update_rate: uint32(LZHAM_FASTEST_TABLE_UPDATE_RATE)}, min_mb_sec: 18},
+		{name: "faster", params: LZHAM_compress_params{dict_size_log2: 22, level: LZHAM_COMP_LEVEL_FASTER}, min_mb_sec: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &tt.params))
+
+			// The best of a few runs, so that a stall elsewhere on the machine doesn't fail the test.
+			var comp_len uint64
+			best := time.Duration(math.MaxInt64)
+			for i := 0; i < 3; i++ {
+				start := time.Now()
+				var status lzham_compress_status_t
+				comp_len, _, status = LZHAM_lib_compress_memory(&tt.params, comp, data)
+				if status != LZHAM_COMP_STATUS_SUCCESS {
+					t.Fatalf("status %d", status)
+				}
+				if elapsed := time.Since(start); elapsed < best {
+					best = elapsed
+				}
+			}
+
+			mb_sec := float64(len(data)) / 1e6 / best.Seconds()
+			t.Logf("%.1f MB/s, %d bytes, deflate %d bytes", mb_sec, comp_len, deflated.Len())
+			if mb_sec < tt.min_mb_sec {
+				t.Errorf("%.1f MB/s, want at least %.0f MB/s", mb_sec, tt.min_mb_sec)
+			}
+			if comp_len >= uint64(deflated.Len()) {
+				t.Errorf("%d bytes, not smaller than deflate's %d", comp_len, deflated.Len())
+			}
+		})
+	}
+}
+
+// Benchmark_compress_helper_threads measures whole-stream compression at the levels that find matches and parse on
+// helper threads, reporting each thread count's speedup over one thread.
+func Benchmark_compress_helper_threads(b *testing.B) {
+	data := append(gen_test_log(7, 2<<20), gen_test_records(8, 2<<20)...)
+
+	for _, level := range []lzham_compress_level{LZHAM_COMP_LEVEL_DEFAULT, LZHAM_COMP_LEVEL_UBER} {
+		var single_ns float64
+		for _, threads := range []int32{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("level=%d/threads=%d", level, threads), func(b *testing.B) {
+				params := LZHAM_compress_params{dict_size_log2: 22, level: level, max_helper_threads: threads - 1}
+				comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &params))
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					if _, _, status := LZHAM_lib_compress_memory(&params, comp, data); status != LZHAM_COMP_STATUS_SUCCESS {
+						b.Fatalf("status %d", status)
+					}
+				}
+
+				ns := float64(b.Elapsed().Nanoseconds()) / float64(b.N)
+				if threads == 1 {
+					single_ns = ns
+				}
+				b.ReportMetric(single_ns/ns, "speedup")
+			})
+		}
+	}
+}
+
+// Benchmark_LZHAM_lib_compress_memory measures whole-stream compression of log text at the greedy levels, next to
+// deflate's fastest level. The table update rate is a stream parameter the decompressor has to be given too, so the
+// default rate is what a plain stream pays for its Huffman table rebuilds; "fastest/fastest_tables" shows what a
+// stream compressed and decompressed with LZHAM_FASTEST_TABLE_UPDATE_RATE gains.
+func Benchmark_LZHAM_lib_compress_memory(b *testing.B) {
+	data := gen_test_log(6, 16<<20)
+
+	benchmarks := []struct {
+		name   string
+		params LZHAM_compress_params
+	}{
+		{name: "fastest", params: LZHAM_compress_params{dict_size_log2: 22, level: LZHAM_COMP_LEVEL_FASTEST}},
+		{name: "fastest/fastest_tables", params: LZHAM_compress_params{dict_size_log2: 22, level: LZHAM_COMP_LEVEL_FASTEST, table_update_rate: uint32(LZHAM_FASTEST_TABLE_UPDATE_RATE)}},
+		{name: "faster", params: LZHAM_compress_params{dict_size_log2: 22, level: LZHAM_COMP_LEVEL_FASTER}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &bm.params))
+			var comp_len uint64
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var status lzham_compress_status_t
+				comp_len, _, status = LZHAM_lib_compress_memory(&bm.params, comp, data)
+				if status != LZHAM_COMP_STATUS_SUCCESS {
+					b.Fatalf("status %d", status)
+				}
+			}
+			b.ReportMetric(float64(comp_len)/float64(len(data)), "ratio")
+		})
+	}
+
+	b.Run("flate", func(b *testing.B) {
+		var comp bytes.Buffer
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			comp.Reset()
+			w, err := flate.NewWriter(&comp, flate.BestSpeed)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				b.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(comp.Len())/float64(len(data)), "ratio")
+	})
+}
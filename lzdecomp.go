@@ -0,0 +1,313 @@
+package lzham
+
+import (
+	"errors"
+	"log/slog"
+)
+
+var ErrNilDecompressState = errors.New("nil decompress state")
+
+type LZHAM_decompress_params struct {
+	dict_size_log2    uint32 // set to the log2(dictionary_size), must be the same as the compressor's
+	table_update_rate uint32 // must be the same as the compressor's, 0=default
+	decompress_flags  uint32 // optional decompression flags (see lzham_decompress_flags enum)
+	num_seed_bytes    uint32 // for delta compression (optional) - number of seed bytes pointed to by pSeed_bytes
+	pSeed_bytes       []byte // for delta compression (optional) - the seed bytes the data was compressed against
+
+	// Advanced settings, which must be the same as the compressor's, see LZHAM_compress_params.
+	table_max_update_interval       uint32
+	table_update_interval_slow_rate uint32
+
+	// Where the decompressor logs to, nil for nowhere.
+	logger *slog.Logger
+
+	// Called with the progress of the stream after each block of data and after the EOF block, if set.
+	progress func(Progress)
+}
+
+// create_decompressor sets up d for a stream compressed with matching parameters.
+func create_decompressor(d *lzdecompressor, pParams *LZHAM_decompress_params) lzham_decompress_status_t {
+	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
+		return LZHAM_DECOMP_STATUS_INVALID_PARAMETER
+	}
+
+	var seed_bytes []byte
+	if pParams.num_seed_bytes > 0 {
+		if pParams.pSeed_bytes == nil || uint64(len(pParams.pSeed_bytes)) < uint64(pParams.num_seed_bytes) || pParams.num_seed_bytes > 1<<pParams.dict_size_log2 {
+			return LZHAM_DECOMP_STATUS_INVALID_PARAMETER
+		}
+		seed_bytes = pParams.pSeed_bytes[:pParams.num_seed_bytes]
+	}
+
+	d.logger = get_logger(pParams.logger)
+	d.progress = pParams.progress
+
+	max_update_interval, slow_rate := get_table_update_settings(pParams.table_update_rate, pParams.table_max_update_interval, pParams.table_update_interval_slow_rate)
+	if !d.init(pParams.dict_size_log2, max_update_interval, slow_rate, seed_bytes) {
+		return LZHAM_DECOMP_STATUS_FAILED_INITIALIZING
+	}
+
+	return LZHAM_DECOMP_STATUS_NOT_FINISHED
+}
+
+// check_zlib_header checks the two byte header LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM puts before the stream against the
+// parameters it's being decompressed with.
+func check_zlib_header(cmf, flg byte, pParams *LZHAM_decompress_params) lzham_decompress_status_t {
+	if (uint32(cmf)<<8|uint32(flg))%31 != 0 || cmf&15 != LZHAM_Z_LZHAM || uint32(cmf>>4)+15 != pParams.dict_size_log2 {
+		return LZHAM_DECOMP_STATUS_FAILED_BAD_ZLIB_HEADER
+	}
+
+	has_seed_bytes := flg&32 != 0
+	if has_seed_bytes && pParams.num_seed_bytes == 0 {
+		return LZHAM_DECOMP_STATUS_FAILED_NEED_SEED_BYTES
+	}
+	if !has_seed_bytes && pParams.num_seed_bytes > 0 {
+		return LZHAM_DECOMP_STATUS_FAILED_BAD_SEED_BYTES
+	}
+
+	return LZHAM_DECOMP_STATUS_NOT_FINISHED
+}
+
+// LZHAM_lib_decompress_memory decompresses the whole stream in pSrc_buf into pDst_buf, returning the decompressed
+// size and its adler32. LZHAM_DECOMP_STATUS_FAILED_DEST_BUF_TOO_SMALL means the data doesn't fit in pDst_buf.
+func LZHAM_lib_decompress_memory(pParams *LZHAM_decompress_params, pDst_buf, pSrc_buf []byte) (uint64, uint32, lzham_decompress_status_t) {
+	if pParams == nil {
+		return 0, 0, LZHAM_DECOMP_STATUS_INVALID_PARAMETER
+	}
+
+	var d lzdecompressor
+	if status := create_decompressor(&d, pParams); status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+		return 0, 0, status
+	}
+
+	if pParams.decompress_flags&uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM) != 0 {
+		if len(pSrc_buf) < 2 {
+			return 0, 0, LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES
+		}
+		if status := check_zlib_header(pSrc_buf[0], pSrc_buf[1], pParams); status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+			return 0, 0, status
+		}
+		pSrc_buf = pSrc_buf[2:]
+		d.comp_size += 2
+	}
+
+	// Capping the capacity makes append copy rather than write past pDst_buf, which is then too small anyway.
+	out := pDst_buf[:0:len(pDst_buf)]
+	for {
+		n, block_out, status := d.decompress_block(pSrc_buf, out)
+		pSrc_buf = pSrc_buf[n:]
+
+		if len(block_out) > len(pDst_buf) {
+			return uint64(len(out)), 0, LZHAM_DECOMP_STATUS_FAILED_DEST_BUF_TOO_SMALL
+		}
+		out = block_out
+
+		switch status {
+		case LZHAM_DECOMP_STATUS_NOT_FINISHED:
+		case LZHAM_DECOMP_STATUS_SUCCESS:
+			return uint64(len(out)), d.adler32, status
+		case LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT:
+			return uint64(len(out)), 0, LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES
+		default:
+			return uint64(len(out)), 0, status
+		}
+	}
+}
+
+// LZHAM_decompress_state is a decompressor fed a buffer at a time by LZHAM_lib_decompress.
+type LZHAM_decompress_state struct {
+	s *decompress_stream
+}
+
+// decompress_stream decodes the blocks of a stream as the caller's buffers complete them. The decompressor stops
+// ahead of whatever part of a block it runs out of input for, keeping the few bytes it hadn't got to, and goes on
+// from there with the next buffer.
+type decompress_stream struct {
+	params LZHAM_decompress_params
+	d      lzdecompressor
+
+	// The zlib header has been checked, or there isn't one.
+	header_checked bool
+
+	// The input taken but not yet read by the decompressor, ahead of the caller's next buffer.
+	pending []byte
+
+	// The last block decoded, of which out[out_ofs:] is still to be returned.
+	out     []byte
+	out_ofs int
+
+	status lzham_decompress_status_t
+}
+
+// cStreamResumeBytes is how much of the caller's input is added to the pending bytes at a time, which is plenty for
+// the part of a block the decompressor stopped ahead of.
+const cStreamResumeBytes = 64
+
+// LZHAM_lib_decompress_init returns a decompressor for LZHAM_lib_decompress.
+func LZHAM_lib_decompress_init(pParams *LZHAM_decompress_params) (*LZHAM_decompress_state, error) {
+	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
+		return nil, ErrInvalidDictSizeLog2
+	}
+
+	s := &decompress_stream{}
+	if create_decompressor(&s.d, pParams) != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+		return nil, ErrDecompressorInitFailed
+	}
+	s.start(pParams)
+
+	return &LZHAM_decompress_state{s: s}, nil
+}
+
+// LZHAM_lib_decompress_reinit makes ptr decompress a new stream with pParams, keeping its dictionary if it's the
+// same size.
+func LZHAM_lib_decompress_reinit(pParams *LZHAM_decompress_params, ptr *LZHAM_decompress_state) (*LZHAM_decompress_state, error) {
+	if ptr == nil {
+		return nil, ErrNilDecompressState
+	}
+	if pParams.dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || pParams.dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
+		return nil, ErrInvalidDictSizeLog2
+	}
+
+	s := ptr.s
+	if create_decompressor(&s.d, pParams) != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+		return nil, ErrDecompressorInitFailed
+	}
+	s.start(pParams)
+
+	return ptr, nil
+}
+
+// LZHAM_lib_decompress_deinit ends the stream of pState, returning the adler32 of the data decompressed.
+func LZHAM_lib_decompress_deinit(pState *LZHAM_decompress_state) uint32 {
+	if pState == nil {
+		return 0
+	}
+	return pState.s.d.adler32
+}
+
+// LZHAM_lib_decompress decompresses pIn_buf into pOut_buf, returning how many bytes of each it used. Input is taken
+// as far as the blocks it completes, or all of it when it ends part way into one, and only while there's room for
+// their data: LZHAM_DECOMP_STATUS_HAS_MORE_OUTPUT means pOut_buf filled up and the call should be repeated with
+// more room. LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT means all of pIn_buf was taken without reaching the end of the
+// stream, which is a failure instead once no_more_input_bytes_flag says there's no more of it. The status is
+// LZHAM_DECOMP_STATUS_SUCCESS once all the data has been returned, and nothing past the end of the stream is taken.
+func LZHAM_lib_decompress(pState *LZHAM_decompress_state, pIn_buf, pOut_buf []byte, no_more_input_bytes_flag bool) (uint64, uint64, lzham_decompress_status_t) {
+	if pState == nil {
+		return 0, 0, LZHAM_DECOMP_STATUS_INVALID_PARAMETER
+	}
+
+	s := pState.s
+	var in_ofs, out_ofs int
+	var status lzham_decompress_status_t
+	for {
+		n := copy(pOut_buf[out_ofs:], s.out[s.out_ofs:])
+		out_ofs += n
+		s.out_ofs += n
+		if s.out_ofs < len(s.out) {
+			status = LZHAM_DECOMP_STATUS_HAS_MORE_OUTPUT
+			break
+		}
+		if s.status >= LZHAM_DECOMP_STATUS_FIRST_SUCCESS_OR_FAILURE_CODE {
+			status = s.status
+			break
+		}
+
+		// Decoding goes on from the pending bytes, when there are any, with some of the caller's input after them.
+		src := pIn_buf[in_ofs:]
+		num_pending := len(s.pending)
+		if num_pending > 0 {
+			if len(src) > cStreamResumeBytes {
+				src = src[:cStreamResumeBytes]
+			}
+			s.pending = append(s.pending, src...)
+			in_ofs += len(src)
+			src = s.pending
+		}
+		if len(src) == 0 && !no_more_input_bytes_flag {
+			status = LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT
+			break
+		}
+
+		used, step_status := s.step(src, no_more_input_bytes_flag && in_ofs == len(pIn_buf))
+		if step_status != LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT {
+			s.status = step_status
+		}
+
+		if num_pending == 0 {
+			in_ofs += used
+			if step_status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT {
+				s.pending = append(s.pending, pIn_buf[in_ofs:]...)
+				in_ofs = len(pIn_buf)
+			}
+		} else {
+			// The caller's input that wasn't read is given back, unless the pending bytes weren't all read either.
+			if step_status != LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT || used >= num_pending {
+				back := len(s.pending) - num_pending
+				if used > num_pending {
+					back = len(s.pending) - used
+				}
+				in_ofs -= back
+				s.pending = s.pending[:len(s.pending)-back]
+			}
+			s.pending = s.pending[:copy(s.pending, s.pending[used:])]
+		}
+
+		if step_status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT && in_ofs == len(pIn_buf) {
+			status = step_status
+			break
+		}
+	}
+
+	return uint64(in_ofs), uint64(out_ofs), status
+}
+
+// start readies s to decode a new stream with pParams.
+func (s *decompress_stream) start(pParams *LZHAM_decompress_params) {
+	s.params = *pParams
+	s.header_checked = pParams.decompress_flags&uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM) == 0
+	s.pending = s.pending[:0]
+	s.out, s.out_ofs = s.out[:0], 0
+	s.status = LZHAM_DECOMP_STATUS_NOT_FINISHED
+}
+
+// step decodes the zlib header or as much of the next block as it can from the start of src, returning how much of
+// src it used. Running out of src part way is a failure if final says there's no more input to come.
+func (s *decompress_stream) step(src []byte, final bool) (int, lzham_decompress_status_t) {
+	status := LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT
+	var used uint64
+	if !s.header_checked {
+		if len(src) >= 2 {
+			s.header_checked = true
+			s.d.comp_size += 2
+			used, status = 2, check_zlib_header(src[0], src[1], &s.params)
+		}
+	} else {
+		used, s.out, status = s.d.decompress_block(src, s.out[:0])
+		s.out_ofs = 0
+	}
+
+	if status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT && final {
+		status = LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES
+	}
+	return int(used), status
+}
+
+// DecompressMemory decompresses the whole stream in src into dst with opts, or with DefaultDecompressOptions if opts
+// is nil, and returns the decompressed length. It gives ErrDestBufTooSmall if dst can't hold all of it, and
+// io.ErrUnexpectedEOF if src ends before the stream does.
+func DecompressMemory(dst, src []byte, opts *DecompressOptions) (int, error) {
+	if opts == nil {
+		default_opts := DefaultDecompressOptions()
+		opts = &default_opts
+	}
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	params := opts.params()
+	n, _, status := LZHAM_lib_decompress_memory(&params, dst, src)
+	if status != LZHAM_DECOMP_STATUS_SUCCESS {
+		return 0, decompress_status_error(status)
+	}
+	return int(n), nil
+}
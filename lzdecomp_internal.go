@@ -0,0 +1,510 @@
+package lzham
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// lzdecompressor rebuilds the data from the decisions in a stream. It mirrors the compressor's state: the same
+// models, LZ state machine and match history, updated the same way as each decision is decoded.
+type lzdecompressor struct {
+	dict_size_log2 uint32
+	num_lzx_slots  uint32
+
+	state state
+	codec symbol_codec
+
+	// The dictionary is a ring of the last dict_size decoded bytes. dict_ofs counts every byte ever decoded.
+	dict      []byte
+	dict_mask uint32
+	dict_ofs  uint64
+
+	// Running adler32 of the decoded data, checked against each block's check bits and the EOF block.
+	adler32  uint32
+	finished bool
+
+	// Blocks of data decoded, bytes of the stream they took up and when the stream was started, for progress.
+	block_index uint32
+	comp_size   int64
+	start_time  time.Time
+
+	// Seed bytes the stream was compressed against, put in the dictionary ahead of the data on every reset.
+	seed_bytes []byte
+
+	logger   *slog.Logger
+	progress func(Progress)
+
+	// How far into the current block decompress_block has got, so it can go on with more input when the last ran
+	// out part way into it: where the block's data starts, the block_phase and the raw block bytes still to come.
+	block_start    uint64
+	block_phase    uint32
+	raw_bytes_left uint32
+}
+
+// The parts of a block decompress_block goes through, each of which it can stop ahead of for more input.
+const (
+	cBlockPhaseHeader = iota
+	cBlockPhaseDecisions
+	cBlockPhaseTrailer
+	cBlockPhaseRawBytes
+	cBlockPhaseDone
+)
+
+func (d *lzdecompressor) init(dict_size_log2, max_update_interval, slow_rate uint32, seed_bytes []byte) bool {
+	if dict_size_log2 < cMinDictSizeLog2 || dict_size_log2 > cMaxDictSizeLog2 {
+		return false
+	}
+	if uint64(len(seed_bytes)) > uint64(1)<<dict_size_log2 {
+		return false
+	}
+	d.seed_bytes = seed_bytes
+	d.logger = get_logger(d.logger)
+
+	dict_size := uint32(1) << dict_size_log2
+	d.dict_size_log2 = dict_size_log2
+	d.num_lzx_slots = get_num_lzx_position_slots(dict_size)
+	if uint32(len(d.dict)) != dict_size {
+		d.dict = make([]byte, dict_size)
+	}
+	d.dict_mask = dict_size - 1
+
+	if !d.state.init_models(false, d.num_lzx_slots, max_update_interval, slow_rate) {
+		return false
+	}
+
+	d.reset()
+	return true
+}
+
+func (d *lzdecompressor) reset() {
+	d.state.reset()
+	d.codec.reset()
+	d.dict_ofs = 0
+	d.adler32 = 1
+	d.finished = false
+	d.block_index = 0
+	d.comp_size = 0
+	d.start_time = time.Now()
+	d.block_phase = cBlockPhaseHeader
+
+	for _, c := range d.seed_bytes {
+		d.put_byte(c)
+	}
+}
+
+// set_input makes decompress_block read whatever it needs past the end of its buffer from src, a byte at a time.
+func (d *lzdecompressor) set_input(src io.ByteReader) {
+	d.codec.decode_src = src
+	d.codec.decode_src_err = nil
+}
+
+// get_input_err returns the error reading from the set_input source, which a block that needs more input ran into.
+func (d *lzdecompressor) get_input_err() error {
+	return d.codec.decode_src_err
+}
+
+// get_max_block_size returns the most data a block can hold, the compressor's limit of an eighth of the dictionary.
+func (d *lzdecompressor) get_max_block_size() uint32 {
+	return (d.dict_mask + 1) / 8
+}
+
+// decompress_block decodes the block at the start of buf, appending its data to out. It returns how many bytes of
+// buf the block took up, and the EOF block gives LZHAM_DECOMP_STATUS_SUCCESS once the adler32 of all the data checks
+// out. A block cut short by the end of buf gives LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT: the bytes it returns as taken
+// are those it has read up to the last decision or part of the block it got through, and the next call goes on from
+// the bytes after them.
+func (d *lzdecompressor) decompress_block(buf, out []byte) (uint64, []byte, lzham_decompress_status_t) {
+	if d.finished {
+		return 0, out, LZHAM_DECOMP_STATUS_SUCCESS
+	}
+
+	codec := &d.codec
+	if d.block_phase == cBlockPhaseHeader {
+		codec.start_decoding(buf)
+		d.block_start = d.dict_ofs
+	} else {
+		codec.resume_decoding(buf)
+	}
+
+	status := LZHAM_DECOMP_STATUS_NOT_FINISHED
+	for status == LZHAM_DECOMP_STATUS_NOT_FINISHED && d.block_phase != cBlockPhaseDone {
+		// Running out of input trumps anything decoded from the zeros past its end, and the step is taken back.
+		pos := codec.get_decode_pos()
+		status = d.decode_block_step()
+		if codec.decode_overran() {
+			codec.set_decode_pos(pos)
+			status = LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT
+		}
+	}
+
+	if status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT {
+		consumed := codec.decode_get_bytes_read()
+		codec.stop_decoding()
+		d.comp_size += int64(consumed)
+		return consumed, out, status
+	}
+
+	consumed := codec.stop_decoding()
+	d.block_phase = cBlockPhaseHeader
+	if status != LZHAM_DECOMP_STATUS_NOT_FINISHED && status != LZHAM_DECOMP_STATUS_SUCCESS {
+		d.logger.Debug("bad block", "status", status, "dict_ofs", d.block_start)
+		return consumed, out, status
+	}
+
+	out = d.append_dict(out, d.block_start)
+	d.finished = status == LZHAM_DECOMP_STATUS_SUCCESS
+
+	d.comp_size += int64(consumed)
+	if d.dict_ofs != d.block_start {
+		d.block_index++
+	}
+	if d.dict_ofs != d.block_start || d.finished {
+		d.report_progress()
+	}
+
+	return consumed, out, status
+}
+
+// decode_block_step decodes the next part of the block: its header, a decision or raw byte of its data, or the
+// trailer of a compressed block. Nothing is changed when the codec overran, as the step is then taken back.
+func (d *lzdecompressor) decode_block_step() lzham_decompress_status_t {
+	codec := &d.codec
+
+	switch d.block_phase {
+	case cBlockPhaseHeader:
+		return d.decode_block_header()
+	case cBlockPhaseDecisions:
+		end_of_block, ok := d.decode_decision()
+		if !ok {
+			return LZHAM_DECOMP_STATUS_FAILED_BAD_CODE
+		}
+		if end_of_block {
+			d.block_phase = cBlockPhaseTrailer
+		} else if d.dict_ofs-d.block_start > uint64(d.get_max_block_size()) {
+			return LZHAM_DECOMP_STATUS_FAILED_BAD_CODE
+		}
+	case cBlockPhaseTrailer:
+		check := codec.decode_bits(cBlockCheckBits)
+		codec.decode_align_to_byte()
+		if codec.decode_overran() {
+			break
+		}
+
+		d.update_adler32(d.block_start)
+		if check != get_block_check(d.adler32) {
+			return LZHAM_DECOMP_STATUS_FAILED_BAD_COMP_BLOCK_SYNC_CHECK
+		}
+		d.block_phase = cBlockPhaseDone
+	case cBlockPhaseRawBytes:
+		c := byte(codec.decode_bits(8))
+		if codec.decode_overran() {
+			break
+		}
+
+		d.put_byte(c)
+		d.raw_bytes_left--
+		if d.raw_bytes_left == 0 {
+			d.update_adler32(d.block_start)
+			d.block_phase = cBlockPhaseDone
+		}
+	}
+
+	return LZHAM_DECOMP_STATUS_NOT_FINISHED
+}
+
+// decode_block_header decodes the header of the block, and all of a sync or EOF block.
+func (d *lzdecompressor) decode_block_header() lzham_decompress_status_t {
+	codec := &d.codec
+
+	switch codec.decode_bits(cBlockHeaderBits) {
+	case cCompBlock:
+		codec.decode_arith_init()
+		reset := codec.decode_bits(cBlockFlushTypeBits)
+		if codec.decode_overran() {
+			break
+		}
+
+		switch reset {
+		case cCompBlockResetUpdateRate:
+			d.state.reset_update_rate()
+		case cCompBlockResetAllTables:
+			d.state.reset_tables()
+		}
+		d.block_phase = cBlockPhaseDecisions
+	case cRawBlock:
+		num_bytes := codec.decode_bits(cRawBlockLenBits)
+		check := codec.decode_bits(cRawBlockLenCheckBits)
+		codec.decode_align_to_byte()
+		if codec.decode_overran() {
+			break
+		}
+		if check != get_raw_block_len_check(num_bytes) || num_bytes >= d.get_max_block_size() {
+			return LZHAM_DECOMP_STATUS_FAILED_BAD_RAW_BLOCK
+		}
+
+		d.raw_bytes_left = num_bytes + 1
+		d.block_phase = cBlockPhaseRawBytes
+	case cSyncBlock:
+		flush_type := codec.decode_bits(cBlockFlushTypeBits)
+		codec.decode_align_to_byte()
+		if codec.decode_bits(16) != cSyncBlockMarkerLo || codec.decode_bits(16) != cSyncBlockMarkerHi {
+			return LZHAM_DECOMP_STATUS_FAILED_BAD_SYNC_BLOCK
+		}
+		if codec.decode_overran() {
+			break
+		}
+
+		d.apply_flush(flush_type)
+		d.block_phase = cBlockPhaseDone
+	case cEOFBlock:
+		codec.decode_align_to_byte()
+		if codec.decode_bits(32) != d.adler32 {
+			return LZHAM_DECOMP_STATUS_FAILED_ADLER32
+		}
+		if codec.decode_overran() {
+			break
+		}
+
+		d.block_phase = cBlockPhaseDone
+		return LZHAM_DECOMP_STATUS_SUCCESS
+	}
+
+	return LZHAM_DECOMP_STATUS_NOT_FINISHED
+}
+
+// report_progress tells the progress callback, if there is one, how far the stream has got.
+func (d *lzdecompressor) report_progress() {
+	if d.progress == nil {
+		return
+	}
+	size := int64(d.dict_ofs) - int64(len(d.seed_bytes))
+	d.progress(Progress{
+		Blocks:  d.block_index,
+		In:      d.comp_size,
+		Out:     size,
+		Ratio:   get_ratio(d.comp_size, size),
+		Elapsed: time.Since(d.start_time),
+	})
+}
+
+// apply_flush mirrors the compressor's apply_flush after a sync block.
+func (d *lzdecompressor) apply_flush(flush_type uint32) {
+	switch flush_type {
+	case cFullFlush:
+		d.state.reset()
+	case cTableFlush:
+		d.state.reset_update_rate()
+	}
+}
+
+// update_adler32 adds the data decoded since dict_ofs start to the running adler32.
+func (d *lzdecompressor) update_adler32(start uint64) {
+	var buf [2][]byte
+	for _, b := range d.get_dict_bytes(start, &buf) {
+		d.adler32 = lzham_adler32_update(d.adler32, b)
+	}
+}
+
+// append_dict appends the data decoded since dict_ofs start to out.
+func (d *lzdecompressor) append_dict(out []byte, start uint64) []byte {
+	var buf [2][]byte
+	for _, b := range d.get_dict_bytes(start, &buf) {
+		out = append(out, b...)
+	}
+	return out
+}
+
+// get_dict_bytes returns the data decoded since dict_ofs start, which is split in two where it wraps around the
+// dictionary.
+func (d *lzdecompressor) get_dict_bytes(start uint64, buf *[2][]byte) [][]byte {
+	from := uint32(start) & d.dict_mask
+	to := uint32(d.dict_ofs) & d.dict_mask
+	if d.dict_ofs == start {
+		return buf[:0]
+	}
+	if from < to {
+		buf[0] = d.dict[from:to]
+		return buf[:1]
+	}
+	buf[0] = d.dict[from:]
+	buf[1] = d.dict[:to]
+	return buf[:2]
+}
+
+// decode_decision decodes one decision from codec into the dictionary. It returns end_of_block when it decodes
+// the end of block symbol instead, and ok false on anything a compressor couldn't have produced. A decision cut short
+// by the end of the codec's input changes nothing: its bit models are put back, and its Huffman models are updated
+// and its data decoded only once all of it has been read.
+func (d *lzdecompressor) decode_decision() (end_of_block, ok bool) {
+	s := &d.state
+	codec := &d.codec
+	cur_state := s.cur_state
+
+	saved_bit_models := [...]adaptive_bit_model{
+		s.is_match_model[cur_state],
+		s.is_rep_model[cur_state],
+		s.is_rep0_model[cur_state],
+		s.is_rep0_single_byte_model[cur_state],
+		s.is_rep1_model[cur_state],
+		s.is_rep2_model[cur_state],
+	}
+
+	var dec lzdecision
+	var huff_models [3]*quasi_adaptive_huffman_data_model
+	var huff_syms [3]uint32
+	num_huff_syms, ok := d.read_decision(&dec, &huff_models, &huff_syms)
+
+	if codec.decode_overran() {
+		s.is_match_model[cur_state] = saved_bit_models[0]
+		s.is_rep_model[cur_state] = saved_bit_models[1]
+		s.is_rep0_model[cur_state] = saved_bit_models[2]
+		s.is_rep0_single_byte_model[cur_state] = saved_bit_models[3]
+		s.is_rep1_model[cur_state] = saved_bit_models[4]
+		s.is_rep2_model[cur_state] = saved_bit_models[5]
+		return false, true
+	}
+	if !ok {
+		return false, false
+	}
+
+	for i := 0; i < num_huff_syms; i++ {
+		codec.total_model_updates++
+		if !huff_models[i].update(huff_syms[i]) {
+			return false, false
+		}
+	}
+
+	switch {
+	case dec.len == 0:
+		d.put_byte(byte(dec.dist))
+		dec.dist = 0
+	case dec.len < 0:
+		return true, true
+	case dec.dist < 0:
+		if !d.copy_match(s.match_hist[-1-dec.dist], uint32(dec.len)) {
+			return false, false
+		}
+	default:
+		if !d.copy_match(uint32(dec.dist), uint32(dec.len)) {
+			return false, false
+		}
+	}
+
+	s.partial_advance(dec)
+	return false, true
+}
+
+// read_decision decodes the symbols of a decision into dec, without the Huffman model updates, which it returns in
+// huff_models and huff_syms. A literal has a len of 0 and the byte in dist, and the end of block a negative len.
+func (d *lzdecompressor) read_decision(dec *lzdecision, huff_models *[3]*quasi_adaptive_huffman_data_model, huff_syms *[3]uint32) (int, bool) {
+	s := &d.state
+	codec := &d.codec
+	cur_state := s.cur_state
+
+	num_huff_syms := 0
+	decode_sym := func(model *quasi_adaptive_huffman_data_model) (uint32, bool) {
+		sym, ok := codec.decode_sym_no_update(model)
+		huff_models[num_huff_syms], huff_syms[num_huff_syms] = model, sym
+		num_huff_syms++
+		return sym, ok
+	}
+
+	if codec.decode_bit(&s.is_match_model[cur_state]) == 0 {
+		if cur_state < cNumLitStates {
+			lit, ok := decode_sym(&s.lit_table)
+			dec.init(0, 0, int32(lit))
+			return num_huff_syms, ok
+		}
+
+		// Literals straight after a match are XORed with the byte at the rep0 distance, see get_delta_lit.
+		delta_lit, ok := decode_sym(&s.delta_lit_table)
+		lit := byte(delta_lit) ^ d.dict[(uint32(d.dict_ofs)-s.match_hist[0])&d.dict_mask]
+		dec.init(0, 0, int32(lit))
+		return num_huff_syms, ok
+	}
+
+	if codec.decode_bit(&s.is_rep_model[cur_state]) != 0 {
+		var rep_index uint32
+		match_len := uint32(1)
+
+		if codec.decode_bit(&s.is_rep0_model[cur_state]) != 0 {
+			if codec.decode_bit(&s.is_rep0_single_byte_model[cur_state]) == 0 {
+				match_len = 0
+			}
+		} else if codec.decode_bit(&s.is_rep1_model[cur_state]) != 0 {
+			rep_index = 1
+		} else if codec.decode_bit(&s.is_rep2_model[cur_state]) != 0 {
+			rep_index = 2
+		} else {
+			rep_index = 3
+		}
+
+		if rep_index != 0 || match_len == 0 {
+			len_sym, ok := decode_sym(&s.rep_len_table[get_len_table_index(cur_state)])
+			if !ok || len_sym >= cNumRepLenSyms-cNumHugeMatchCodes {
+				return num_huff_syms, false
+			}
+			match_len = len_sym + cMinMatchLen
+		}
+
+		dec.init(0, int32(match_len), -1-int32(rep_index))
+		return num_huff_syms, true
+	}
+
+	main_sym, ok := decode_sym(&s.main_table)
+	if !ok {
+		return num_huff_syms, false
+	}
+	if main_sym < cLZXNumSpecialLengths {
+		dec.init(0, -1, 0)
+		return num_huff_syms, main_sym == cLZXSpecialCodeEndOfBlockCode
+	}
+
+	main_sym -= cLZXNumSpecialLengths
+	slot := main_sym/cNumMainLens + cLZXLowestUsableMatchSlot
+	match_len := main_sym%cNumMainLens + cMinMatchLen
+	if match_len == cMinMatchLen+cNumMainLens-1 {
+		large_sym, ok := decode_sym(&s.large_len_table[get_len_table_index(cur_state)])
+		if !ok || large_sym >= cNumLargeLenSyms-cNumHugeMatchCodes {
+			return num_huff_syms, false
+		}
+		match_len += large_sym
+	}
+
+	num_extra_bits := uint32(lzx_position_extra_bits[slot])
+	var ofs uint32
+	if num_extra_bits < 3 {
+		ofs = codec.decode_bits(num_extra_bits)
+	} else {
+		if num_extra_bits > 4 {
+			ofs = codec.decode_bits(num_extra_bits-4) << 4
+		}
+		lsb, ok := decode_sym(&s.dist_lsb_table)
+		if !ok {
+			return num_huff_syms, false
+		}
+		ofs |= lsb
+	}
+
+	dec.init(0, int32(match_len), int32(lzx_position_base[slot]+ofs))
+	return num_huff_syms, true
+}
+
+func (d *lzdecompressor) put_byte(c byte) {
+	d.dict[uint32(d.dict_ofs)&d.dict_mask] = c
+	d.dict_ofs++
+}
+
+// copy_match appends len bytes from dist bytes back, which must be inside the dictionary and the decoded data.
+func (d *lzdecompressor) copy_match(dist, len uint32) bool {
+	if dist == 0 || uint64(dist) > d.dict_ofs || dist > d.dict_mask+1 {
+		return false
+	}
+
+	src := uint32(d.dict_ofs) - dist
+	for i := uint32(0); i < len; i++ {
+		d.put_byte(d.dict[(src+i)&d.dict_mask])
+	}
+	return true
+}
@@ -0,0 +1,684 @@
+package lzham
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// gen_test_records returns table-like binary data: fixed size records whose fields mostly repeat or count up.
+func gen_test_records(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+
+	buf := make([]byte, 0, size+32)
+	var rec [32]byte
+	ts := uint64(1700000000000)
+	for id := uint32(0); len(buf) < size; id++ {
+		ts += uint64(rng.Intn(1000))
+		binary.LittleEndian.PutUint32(rec[0:], id)
+		binary.LittleEndian.PutUint64(rec[4:], ts)
+		rec[12] = byte(rng.Intn(4))
+		binary.LittleEndian.PutUint16(rec[13:], uint16(rng.Intn(3)*1000))
+		copy(rec[15:], "ACTIVE\x00\x00")
+		if rng.Intn(8) == 0 {
+			copy(rec[15:], "PENDING\x00")
+		}
+		binary.LittleEndian.PutUint32(rec[23:], uint32(rng.Intn(100)))
+		binary.LittleEndian.PutUint32(rec[27:], 0xDEADBEEF)
+		rec[31] = byte(rng.Intn(256))
+		buf = append(buf, rec[:]...)
+	}
+	return buf[:size]
+}
+
+// gen_test_repeats returns copies of a few random chunks with the odd byte changed, for matches up to cMaxMatchLen.
+func gen_test_repeats(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+
+	chunks := make([][]byte, 4)
+	for i := range chunks {
+		chunks[i] = make([]byte, 1000+rng.Intn(3000))
+		rng.Read(chunks[i])
+	}
+
+	buf := make([]byte, 0, size+4096)
+	for len(buf) < size {
+		start := len(buf)
+		buf = append(buf, chunks[rng.Intn(len(chunks))]...)
+		for n := rng.Intn(4); n > 0; n-- {
+			buf[start+rng.Intn(len(buf)-start)] ^= byte(1 + rng.Intn(255))
+		}
+	}
+	return buf[:size]
+}
+
+// encode_test_data parses data with lz and codes every decision as a single run of arithmetic coded bits ending
+// with an end of block symbol. It returns the coded bytes, the decisions and their estimated cost.
+func encode_test_data(tb testing.TB, lz *lzcompressor, data []byte) ([]byte, []lzdecision, bit_cost_t) {
+	var codec symbol_codec
+	var decisions []lzdecision
+	var total_cost bit_cost_t
+
+	codec.start_encoding(uint32(len(data)))
+	codec.encode_arith_init()
+
+	for ofs := 0; ofs < len(data); {
+		num_bytes := minimum(uint32(len(data)-ofs), lz.params.block_size)
+		num_bytes = minimum(num_bytes, lz.accel.get_max_add_bytes())
+
+		if !lz.accel.add_bytes_begin(num_bytes, data[ofs:]) {
+			tb.Fatalf("add_bytes_begin failed at %d", ofs)
+		}
+		lz.accel.add_bytes_end()
+
+		round_size := lz.get_parse_round_size()
+		for start_ofs := uint32(0); start_ofs < num_bytes; start_ofs += round_size {
+			block_decisions, ok := lz.parse_block(start_ofs, minimum(num_bytes-start_ofs, round_size))
+			if !ok {
+				tb.Fatalf("parse failed at %d", ofs)
+			}
+			for _, dec := range block_decisions {
+				total_cost += lz.state.get_cost(lz, &lz.state.lzstate, dec)
+				if !lz.state.encode(&codec, lz, dec) {
+					tb.Fatalf("encode failed at %d", ofs+int(dec.pos))
+				}
+
+				dec.pos += int32(ofs)
+				decisions = append(decisions, dec)
+			}
+		}
+
+		lz.accel.advance_bytes(num_bytes)
+		ofs += int(num_bytes)
+	}
+
+	if !lz.state.encode_eob(&codec) || !codec.stop_encoding() {
+		tb.Fatal("stop_encoding failed")
+	}
+
+	return append([]byte(nil), codec.get_encoding_buf()...), decisions, total_cost
+}
+
+// decode_test_data decodes what encode_test_data produced. The data must fit in the dictionary.
+func decode_test_data(tb testing.TB, d *lzdecompressor, buf []byte) []byte {
+	d.reset()
+	d.codec.start_decoding(buf)
+	d.codec.decode_arith_init()
+
+	for {
+		end_of_block, ok := d.decode_decision()
+		if !ok {
+			tb.Fatalf("bad code after %d bytes", d.dict_ofs)
+		}
+		if end_of_block {
+			break
+		}
+	}
+
+	if d.codec.decode_overran() {
+		tb.Fatalf("decoding read past the %d byte buffer", len(buf))
+	}
+	d.codec.stop_decoding()
+
+	return d.dict[:d.dict_ofs]
+}
+
+func Test_lzdecompressor(t *testing.T) {
+	tests := []struct {
+		name          string
+		level         compression_level
+		data          []byte
+		want_all_reps bool
+		want_max_len  bool
+	}{
+		{name: "fastest text", level: cCompressionLevelFastest, data: gen_test_data(3, 300<<10)},
+		{name: "default records", level: cCompressionLevelDefault, data: gen_test_records(4, 300<<10), want_all_reps: true},
+		{name: "uber records", level: cCompressionLevelUber, data: gen_test_records(5, 300<<10), want_all_reps: true},
+		{name: "uber log", level: cCompressionLevelUber, data: gen_test_log(6, 300<<10), want_all_reps: true},
+		{name: "better long repeats", level: cCompressionLevelBetter, data: gen_test_repeats(7, 300<<10), want_max_len: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lz := new_test_compressor(t, tt.level, 20)
+			buf, decisions, cost := encode_test_data(t, lz, tt.data)
+
+			var d lzdecompressor
+			if !d.init(20, 0, 0, nil) {
+				t.Fatal("init failed")
+			}
+			if got := decode_test_data(t, &d, buf); !bytes.Equal(got, tt.data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(tt.data))
+			}
+
+			var num_max_len int
+			for _, dec := range decisions {
+				if dec.len == cMaxMatchLen {
+					num_max_len++
+				}
+			}
+			if tt.want_max_len && num_max_len == 0 {
+				t.Errorf("no matches of %d bytes", cMaxMatchLen)
+			}
+
+			var num_reps [cMatchHistSize]int
+			var num_short_reps, num_delta_lits int
+			var lzs lzstate
+			lzs.clear()
+			for _, dec := range decisions {
+				if dec.is_lit() && lzs.cur_state >= cNumLitStates {
+					num_delta_lits++
+				}
+				lzs.partial_advance(dec)
+				if dec.is_rep() {
+					num_reps[dec.get_rep_index()]++
+					if dec.len == 1 {
+						num_short_reps++
+					}
+				}
+			}
+
+			estimate := float64(cost) / cBitCostScale / 8
+			t.Logf("%d bytes, estimated %.0f, rep matches %v, %d short, %d delta literals", len(buf), estimate, num_reps, num_short_reps, num_delta_lits)
+			if diff := float64(len(buf)) - estimate; diff < -estimate/50 || diff > estimate/50 {
+				t.Errorf("coded %d bytes, estimated %.0f", len(buf), estimate)
+			}
+			if tt.want_all_reps && (num_reps[3] == 0 || num_short_reps == 0) {
+				t.Errorf("rep matches %v, %d short, want all kinds", num_reps, num_short_reps)
+			}
+			if num_delta_lits == 0 {
+				t.Error("no literals were delta coded")
+			}
+		})
+	}
+}
+
+// decompress_test_stream decodes a whole stream block by block, returning the data and how many blocks of each type
+// it held.
+func decompress_test_stream(tb testing.TB, d *lzdecompressor, buf []byte) ([]byte, [4]int) {
+	var out []byte
+	var num_blocks [4]int
+
+	d.reset()
+	for ofs := uint64(0); ; {
+		num_blocks[buf[ofs]>>(8-cBlockHeaderBits)]++
+
+		n, block_out, status := d.decompress_block(buf[ofs:], out)
+		out = block_out
+		ofs += n
+		if status == LZHAM_DECOMP_STATUS_SUCCESS {
+			if ofs != uint64(len(buf)) {
+				tb.Fatalf("stream ended after %d of %d bytes", ofs, len(buf))
+			}
+			return out, num_blocks
+		}
+		if status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+			tb.Fatalf("status %d at byte %d, after %d decoded bytes", status, ofs, len(out))
+		}
+	}
+}
+
+func Test_compress_block(t *testing.T) {
+	random := make([]byte, 20000)
+	rand.New(rand.NewSource(9)).Read(random)
+
+	tests := []struct {
+		name       string
+		level      compression_level
+		block_size uint32
+		data       []byte
+		want_raw   bool
+	}{
+		{name: "fastest text", level: cCompressionLevelFastest, data: gen_test_data(10, 200<<10)},
+		{name: "default records", level: cCompressionLevelDefault, data: gen_test_records(11, 200<<10)},
+		// Delta literals after rep0 matches reaching bytes the lookahead has overwritten in the window.
+		{name: "fastest log", level: cCompressionLevelFastest, data: gen_test_log(60, 300<<10)},
+		{name: "uber small blocks", level: cCompressionLevelUber, block_size: 1000, data: gen_test_log(12, 100<<10)},
+		{name: "better text and random", level: cCompressionLevelBetter, data: append(append(gen_test_data(13, 50<<10), random...), gen_test_data(13, 50<<10)...), want_raw: true},
+		{name: "empty", level: cCompressionLevelDefault},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lz := &lzcompressor{}
+			params := init_params{compression_level: tt.level, dict_size_log2: 16, block_size: tt.block_size}
+			if !lz.init(&params) {
+				t.Fatal("init failed")
+			}
+
+			// Odd sized writes so blocks fill across them.
+			for ofs := 0; ofs < len(tt.data); ofs += 3001 {
+				end := ofs + 3001
+				if end > len(tt.data) {
+					end = len(tt.data)
+				}
+				if !lz.put_bytes(tt.data[ofs:end]) {
+					t.Fatalf("put_bytes failed at %d", ofs)
+				}
+			}
+			if !lz.finish() {
+				t.Fatal("finish failed")
+			}
+			if lz.put_bytes([]byte{1}) {
+				t.Error("put_bytes succeeded after finish")
+			}
+
+			var d lzdecompressor
+			if !d.init(16, 0, 0, nil) {
+				t.Fatal("init failed")
+			}
+			got, num_blocks := decompress_test_stream(t, &d, lz.comp_buf)
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(tt.data))
+			}
+
+			t.Logf("%d -> %d bytes, %d compressed blocks, %d raw", len(tt.data), len(lz.comp_buf), num_blocks[cCompBlock], num_blocks[cRawBlock])
+			if num_blocks[cEOFBlock] != 1 {
+				t.Errorf("%d EOF blocks", num_blocks[cEOFBlock])
+			}
+			if tt.want_raw != (num_blocks[cRawBlock] > 0) {
+				t.Errorf("%d raw blocks", num_blocks[cRawBlock])
+			}
+			if len(tt.data) > 0 && num_blocks[cCompBlock] == 0 {
+				t.Error("no compressed blocks")
+			}
+			if len(tt.data) > 0 && len(lz.comp_buf) > len(tt.data)/2+len(random) {
+				t.Errorf("compressed to %d bytes", len(lz.comp_buf))
+			}
+
+			// Corrupting the stream is caught by a block check or the adler32 at the latest.
+			if len(tt.data) > 0 {
+				bad := append([]byte(nil), lz.comp_buf...)
+				bad[len(bad)/2] ^= 0x10
+				d.reset()
+				var status lzham_decompress_status_t
+				for ofs := uint64(0); ofs < uint64(len(bad)); {
+					var n uint64
+					n, _, status = d.decompress_block(bad[ofs:], nil)
+					ofs += n
+					if status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+						break
+					}
+				}
+				if status == LZHAM_DECOMP_STATUS_SUCCESS || status == LZHAM_DECOMP_STATUS_NOT_FINISHED {
+					t.Errorf("corrupt stream decoded with status %d", status)
+				}
+			}
+		})
+	}
+}
+
+func Test_compress_block_update_rate_resets(t *testing.T) {
+	// Text and binary members alternating like files in a tar.
+	var mixed []byte
+	for i := int64(0); i < 4; i++ {
+		mixed = append(mixed, gen_test_data(20+i, 64<<10)...)
+		mixed = append(mixed, gen_test_records(30+i, 64<<10)...)
+	}
+
+	compress := func(t *testing.T, data []byte, flags uint32) *lzcompressor {
+		lz := &lzcompressor{}
+		params := init_params{compression_level: cCompressionLevelDefault, dict_size_log2: 20, block_size: 16 << 10, lzham_compress_flags: flags}
+		if !lz.init(&params) {
+			t.Fatal("init failed")
+		}
+		if !lz.put_bytes(data) || !lz.finish() {
+			t.Fatal("compression failed")
+		}
+		return lz
+	}
+
+	tests := []struct {
+		name       string
+		flags      uint32
+		data       []byte
+		min_resets uint32
+		max_resets uint32
+		// Whether the stream must come out smaller than without the tradeoff flag.
+		want_smaller bool
+	}{
+		{name: "mixed", flags: uint32(LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO), data: mixed, min_resets: 4, max_resets: 16, want_smaller: true},
+		{name: "mixed without tradeoff", data: mixed},
+		{name: "text", flags: uint32(LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO), data: gen_test_data(40, 512<<10), max_resets: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lz := compress(t, tt.data, tt.flags)
+
+			var d lzdecompressor
+			if !d.init(20, 0, 0, nil) {
+				t.Fatal("init failed")
+			}
+			got, _ := decompress_test_stream(t, &d, lz.comp_buf)
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(tt.data))
+			}
+
+			resets := lz.stats.total_update_rate_resets
+			t.Logf("%d -> %d bytes, %d update rate resets", len(tt.data), len(lz.comp_buf), resets)
+			if resets < tt.min_resets || resets > tt.max_resets {
+				t.Errorf("%d update rate resets, want %d to %d", resets, tt.min_resets, tt.max_resets)
+			}
+
+			if tt.want_smaller {
+				no_reset := compress(t, tt.data, 0)
+				if len(lz.comp_buf) >= len(no_reset.comp_buf) {
+					t.Errorf("%d bytes with resets, not smaller than %d bytes without", len(lz.comp_buf), len(no_reset.comp_buf))
+				}
+			}
+		})
+	}
+}
+
+// A block cut short by the end of the buffer is picked up where it stopped, whatever part of it that was.
+func Test_decompress_block_resume(t *testing.T) {
+	random := make([]byte, 20000)
+	rand.New(rand.NewSource(14)).Read(random)
+	data := append(append(gen_test_log(84, 50<<10), random...), gen_test_records(85, 50<<10)...)
+
+	params := LZHAM_compress_params{dict_size_log2: 16}
+	pState, err := LZHAM_lib_compress_init(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := compress_test_stream(t, pState, data, 5000, 4096, 9999, LZHAM_SYNC_FLUSH)
+
+	tests := []struct {
+		name       string
+		chunk_size uint64
+	}{
+		{name: "one byte", chunk_size: 1},
+		{name: "three bytes", chunk_size: 3},
+		{name: "odd size", chunk_size: 1001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d lzdecompressor
+			if !d.init(16, 0, 0, nil) {
+				t.Fatal("init failed")
+			}
+
+			var got []byte
+			var num_cut_short int
+			ofs, end := uint64(0), uint64(0)
+			for {
+				end = LZHAM_MIN64(end+tt.chunk_size, uint64(len(comp)))
+				n, block_out, status := d.decompress_block(comp[ofs:end], got)
+				got = block_out
+				ofs += n
+				if status == LZHAM_DECOMP_STATUS_SUCCESS {
+					break
+				}
+				if status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT && end < uint64(len(comp)) {
+					num_cut_short++
+					continue
+				}
+				if status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+					t.Fatalf("status %d at byte %d, after %d decoded bytes", status, ofs, len(got))
+				}
+			}
+
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(data))
+			}
+			if ofs != uint64(len(comp)) {
+				t.Errorf("stream ended after %d of %d bytes", ofs, len(comp))
+			}
+			if num_cut_short == 0 {
+				t.Error("no blocks were cut short")
+			}
+		})
+	}
+}
+
+func Test_LZHAM_lib_decompress_memory(t *testing.T) {
+	data := gen_test_data(27, 50<<10)
+	seed := gen_test_data(28, 1000)
+
+	compress := func(params LZHAM_compress_params) []byte {
+		comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &params))
+		n, _, status := LZHAM_lib_compress_memory(&params, comp, data)
+		if status != LZHAM_COMP_STATUS_SUCCESS {
+			t.Fatalf("compress status %d", status)
+		}
+		return comp[:n]
+	}
+	comp := compress(LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT})
+	zlib_comp := compress(LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT, compress_flags: uint32(LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM)})
+	seeded_comp := compress(LZHAM_compress_params{dict_size_log2: 16, level: LZHAM_COMP_LEVEL_DEFAULT, compress_flags: uint32(LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM), num_seed_bytes: uint32(len(seed)), pSeed_bytes: seed})
+
+	corrupt := append([]byte(nil), comp...)
+	corrupt[len(corrupt)/3] ^= 0x01
+
+	tests := []struct {
+		name     string
+		params   LZHAM_decompress_params
+		dst_size int
+		src      []byte
+		want     lzham_decompress_status_t
+	}{
+		{name: "ok", params: LZHAM_decompress_params{dict_size_log2: 16}, dst_size: len(data), src: comp, want: LZHAM_DECOMP_STATUS_SUCCESS},
+		{name: "dest one byte short", params: LZHAM_decompress_params{dict_size_log2: 16}, dst_size: len(data) - 1, src: comp, want: LZHAM_DECOMP_STATUS_FAILED_DEST_BUF_TOO_SMALL},
+		{name: "truncated", params: LZHAM_decompress_params{dict_size_log2: 16}, dst_size: len(data), src: comp[:len(comp)-1], want: LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES},
+		{name: "corrupt", params: LZHAM_decompress_params{dict_size_log2: 16}, dst_size: len(data), src: corrupt},
+		{name: "bad dict size", params: LZHAM_decompress_params{dict_size_log2: 14}, dst_size: len(data), src: comp, want: LZHAM_DECOMP_STATUS_INVALID_PARAMETER},
+		{name: "zlib", params: LZHAM_decompress_params{dict_size_log2: 16, decompress_flags: uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM)}, dst_size: len(data), src: zlib_comp, want: LZHAM_DECOMP_STATUS_SUCCESS},
+		{name: "zlib wrong dict size", params: LZHAM_decompress_params{dict_size_log2: 17, decompress_flags: uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM)}, dst_size: len(data), src: zlib_comp, want: LZHAM_DECOMP_STATUS_FAILED_BAD_ZLIB_HEADER},
+		{name: "zlib missing seed", params: LZHAM_decompress_params{dict_size_log2: 16, decompress_flags: uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM)}, dst_size: len(data), src: seeded_comp, want: LZHAM_DECOMP_STATUS_FAILED_NEED_SEED_BYTES},
+		{name: "zlib seeded", params: LZHAM_decompress_params{dict_size_log2: 16, decompress_flags: uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM), num_seed_bytes: uint32(len(seed)), pSeed_bytes: seed}, dst_size: len(data), src: seeded_comp, want: LZHAM_DECOMP_STATUS_SUCCESS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := make([]byte, tt.dst_size+100)
+			n, adler, status := LZHAM_lib_decompress_memory(&tt.params, dst[:tt.dst_size], tt.src)
+
+			if tt.want == 0 {
+				if status < LZHAM_DECOMP_STATUS_FIRST_FAILURE_CODE {
+					t.Errorf("status %d, want a failure", status)
+				}
+			} else if status != tt.want {
+				t.Errorf("status %d, want %d", status, tt.want)
+			}
+
+			for _, c := range dst[tt.dst_size:] {
+				if c != 0 {
+					t.Fatal("wrote past the end of the destination")
+				}
+			}
+			if status == LZHAM_DECOMP_STATUS_SUCCESS && (!bytes.Equal(dst[:n], data) || adler != lzham_adler32_update(1, data)) {
+				t.Errorf("decompressed %d bytes with adler32 %#x, which don't match", n, adler)
+			}
+		})
+	}
+}
+
+// decompress_test_chunks decompresses comp through LZHAM_lib_decompress, handing it in_size bytes of input and
+// out_size bytes of room at a time. It returns the data and how much of comp the stream took.
+func decompress_test_chunks(tb testing.TB, pState *LZHAM_decompress_state, comp []byte, in_size, out_size int) ([]byte, int, lzham_decompress_status_t) {
+	var out []byte
+	out_buf := make([]byte, out_size)
+	in_ofs := 0
+	for {
+		in_end := in_ofs + in_size
+		if in_end > len(comp) {
+			in_end = len(comp)
+		}
+		in_n, out_n, status := LZHAM_lib_decompress(pState, comp[in_ofs:in_end], out_buf, in_end == len(comp))
+		in_ofs += int(in_n)
+		out = append(out, out_buf[:out_n]...)
+
+		switch status {
+		case LZHAM_DECOMP_STATUS_HAS_MORE_OUTPUT, LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT:
+			if status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT && in_ofs != in_end {
+				tb.Fatalf("needs more input with %d bytes left", in_end-in_ofs)
+			}
+		default:
+			return out, in_ofs, status
+		}
+	}
+}
+
+func Test_LZHAM_lib_decompress(t *testing.T) {
+	data := append(gen_test_log(80, 100<<10), gen_test_records(81, 100<<10)...)
+	trailer := []byte("trailer")
+
+	tests := []struct {
+		name     string
+		opts     CompressOptions
+		in_size  int
+		out_size int
+	}{
+		{name: "whole", opts: CompressOptions{DictSizeLog2: 16}, in_size: 1 << 30, out_size: 1 << 30},
+		{name: "one byte in", opts: CompressOptions{DictSizeLog2: 16}, in_size: 1, out_size: 4096},
+		{name: "one byte out", opts: CompressOptions{DictSizeLog2: 16}, in_size: 4096, out_size: 1},
+		{name: "odd sizes", opts: CompressOptions{DictSizeLog2: 18, Level: LZHAM_COMP_LEVEL_BETTER}, in_size: 777, out_size: 3001},
+		{name: "zlib", opts: CompressOptions{DictSizeLog2: 16, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM}, in_size: 1, out_size: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp := compress_test_writer(t, &tt.opts, data)
+
+			decomp_opts := DecompressOptions{DictSizeLog2: tt.opts.DictSizeLog2}
+			if tt.opts.Flags&LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM != 0 {
+				decomp_opts.Flags = LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM
+			}
+			params := decomp_opts.params()
+			pState, err := LZHAM_lib_decompress_init(&params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, in_used, status := decompress_test_chunks(t, pState, append(comp, trailer...), tt.in_size, tt.out_size)
+			if status != LZHAM_DECOMP_STATUS_SUCCESS {
+				t.Fatalf("status %d", status)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("decompressed %d bytes, which don't match", len(out))
+			}
+			if in_used != len(comp) {
+				t.Errorf("took %d bytes of a %d byte stream", in_used, len(comp))
+			}
+			if adler := LZHAM_lib_decompress_deinit(pState); adler != lzham_adler32_update(1, data) {
+				t.Errorf("adler32 %#x", adler)
+			}
+		})
+	}
+}
+
+func Test_LZHAM_lib_decompress_errors(t *testing.T) {
+	data := gen_test_log(82, 100<<10)
+	opts := CompressOptions{DictSizeLog2: 16}
+	comp := compress_test_writer(t, &opts, data)
+	params := LZHAM_decompress_params{dict_size_log2: 16}
+
+	corrupt := append([]byte(nil), comp...)
+	corrupt[len(corrupt)/2] ^= 0x10
+
+	tests := []struct {
+		name string
+		src  []byte
+		want lzham_decompress_status_t
+	}{
+		{name: "truncated", src: comp[:len(comp)/2], want: LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES},
+		{name: "empty", src: nil, want: LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES},
+		{name: "corrupt", src: corrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pState, err := LZHAM_lib_decompress_init(&params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer LZHAM_lib_decompress_deinit(pState)
+
+			_, _, status := decompress_test_chunks(t, pState, tt.src, 1000, 1000)
+			if tt.want == 0 {
+				if status < LZHAM_DECOMP_STATUS_FIRST_FAILURE_CODE {
+					t.Errorf("status %d, want a failure", status)
+				}
+			} else if status != tt.want {
+				t.Errorf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func Test_LZHAM_lib_decompress_reinit(t *testing.T) {
+	data := gen_test_log(83, 100<<10)
+	opts := CompressOptions{DictSizeLog2: 16}
+	comp := compress_test_writer(t, &opts, data)
+	params := LZHAM_decompress_params{dict_size_log2: 16}
+
+	num_goroutines := runtime.NumGoroutine()
+
+	pState, err := LZHAM_lib_decompress_init(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		// Leave the stream part way through a block.
+		out := make([]byte, len(data))
+		if _, _, status := LZHAM_lib_decompress(pState, comp[:len(comp)/2], out, false); status != LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT {
+			t.Fatalf("status %d", status)
+		}
+		if _, err := LZHAM_lib_decompress_reinit(&params, pState); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, _, status := decompress_test_chunks(t, pState, comp, 5000, 5000)
+	if status != LZHAM_DECOMP_STATUS_SUCCESS || !bytes.Equal(out, data) {
+		t.Fatalf("status %d after reinit, decompressed %d bytes", status, len(out))
+	}
+
+	if _, _, status := LZHAM_lib_decompress(pState, comp[:100], make([]byte, 100), false); status != LZHAM_DECOMP_STATUS_SUCCESS {
+		t.Errorf("status %d after the end of the stream", status)
+	}
+	LZHAM_lib_decompress_deinit(pState)
+
+	if n := runtime.NumGoroutine(); n > num_goroutines {
+		t.Errorf("%d goroutines left running, from %d", n, num_goroutines)
+	}
+}
+
+func TestDecompressMemory(t *testing.T) {
+	data := gen_test_log(68, 100<<10)
+	comp := make([]byte, CompressBound(uint64(len(data)), &CompressOptions{DictSizeLog2: 16}))
+	n, err := CompressMemory(comp, data, &CompressOptions{DictSizeLog2: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp = comp[:n]
+
+	tests := []struct {
+		name     string
+		dst_size int
+		comp     []byte
+		opts     *DecompressOptions
+		want     error
+	}{
+		{name: "whole stream", dst_size: len(data), comp: comp, opts: &DecompressOptions{DictSizeLog2: 16}},
+		{name: "room to spare", dst_size: len(data) + 100, comp: comp, opts: &DecompressOptions{DictSizeLog2: 16}},
+		{name: "dst too small", dst_size: len(data) - 1, comp: comp, opts: &DecompressOptions{DictSizeLog2: 16}, want: ErrDestBufTooSmall},
+		{name: "truncated", dst_size: len(data), comp: comp[:len(comp)/2], opts: &DecompressOptions{DictSizeLog2: 16}, want: io.ErrUnexpectedEOF},
+		{name: "bad options", dst_size: len(data), comp: comp, opts: &DecompressOptions{DictSizeLog2: 14}, want: ErrInvalidDictSizeLog2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := make([]byte, tt.dst_size)
+			n, err := DecompressMemory(out, tt.comp, tt.opts)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DecompressMemory() = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && !bytes.Equal(out[:n], data) {
+				t.Errorf("decompressed %d bytes, which don't match", n)
+			}
+		})
+	}
+}
@@ -150,3 +150,65 @@ var (
 		0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19, 0x19,
 	}
 )
+
+// Flush types, coded in the sync block every flush ends with. A sync flush only lets the decompressor output all the
+// data so far, a table flush also makes the Huffman tables adapt quickly again, and a full flush resets the models
+// and starts the dictionary over.
+const (
+	cSyncFlush  = 0
+	cTableFlush = 1
+	cFullFlush  = 2
+)
+
+// What a compressed block's header asks of the models, in cBlockFlushTypeBits after the arithmetic coder starts. The
+// update rates are reset by a block unlike the ones before it, when that makes it smaller.
+const (
+	cCompBlockNoReset         = 0
+	cCompBlockResetUpdateRate = 1
+	cCompBlockResetAllTables  = 2
+)
+
+// A sync block's flush type is padded to a byte and followed by 16 zero bits and 16 one bits, like an empty stored
+// block in deflate.
+const (
+	cSyncBlockMarkerLo = 0x0000
+	cSyncBlockMarkerHi = 0xFFFF
+)
+
+// A raw block's header bits are followed by its length minus one in 24 bits and get_raw_block_len_check of that in 8,
+// padded to a byte.
+const (
+	cRawBlockLenBits      = 24
+	cRawBlockLenCheckBits = 8
+	cRawBlockHeaderSize   = 5
+)
+
+// The EOF block is its header bits padded to a byte, then the adler32 of all the data.
+const cEOFBlockSize = 5
+
+// get_raw_block_len_check xors together the three bytes of a raw block's length minus one.
+func get_raw_block_len_check(len_minus_1 uint32) uint32 {
+	return (len_minus_1 ^ len_minus_1>>8 ^ len_minus_1>>16) & 0xFF
+}
+
+// get_block_check folds the adler32 of everything up to the end of a block into the cBlockCheckBits check at its end.
+func get_block_check(adler32 uint32) uint32 {
+	adler32 ^= adler32 >> 16
+	adler32 ^= adler32 >> 8
+	adler32 ^= adler32 >> 4
+	return adler32 & (1<<cBlockCheckBits - 1)
+}
+
+// get_table_update_settings returns the Huffman tables' max update interval and slow rate. Either override wins
+// over table_update_rate, which is 0 for the default. The compressor and decompressor must agree on them.
+func get_table_update_settings(table_update_rate, max_update_interval, slow_rate uint32) (uint32, uint32) {
+	if max_update_interval > 0 || slow_rate > 0 {
+		return max_update_interval, slow_rate
+	}
+
+	if table_update_rate == 0 {
+		table_update_rate = uint32(LZHAM_DEFAULT_TABLE_UPDATE_RATE)
+	}
+	table_update_rate = clamp(table_update_rate, 1, uint32(LZHAM_FASTEST_TABLE_UPDATE_RATE)) - 1
+	return uint32(g_table_update_settings[table_update_rate].m_max_update_interval), uint32(g_table_update_settings[table_update_rate].m_slow_rate)
+}
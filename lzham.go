@@ -11,3 +11,52 @@ const (
 	LZHAM_Z_VER_REVISION    = 1
 	LZHAM_Z_VER_SUBREVISION = 0
 )
+
+// Compression strategies, which are accepted but ignored.
+const (
+	LZHAM_Z_DEFAULT_STRATEGY = 0
+	LZHAM_Z_FILTERED         = 1
+	LZHAM_Z_HUFFMAN_ONLY     = 2
+	LZHAM_Z_RLE              = 3
+	LZHAM_Z_FIXED            = 4
+)
+
+// Flush values, the same as the lzham_flush_t they map to. LZHAM_Z_PARTIAL_FLUSH is taken as LZHAM_Z_SYNC_FLUSH.
+const (
+	LZHAM_Z_NO_FLUSH      = 0
+	LZHAM_Z_PARTIAL_FLUSH = 1
+	LZHAM_Z_SYNC_FLUSH    = 2
+	LZHAM_Z_FULL_FLUSH    = 3
+	LZHAM_Z_FINISH        = 4
+	LZHAM_Z_BLOCK         = 5
+	LZHAM_Z_TABLE_FLUSH   = 10
+)
+
+// Return status codes.
+const (
+	LZHAM_Z_OK            = 0
+	LZHAM_Z_STREAM_END    = 1
+	LZHAM_Z_NEED_DICT     = 2
+	LZHAM_Z_ERRNO         = -1
+	LZHAM_Z_STREAM_ERROR  = -2
+	LZHAM_Z_DATA_ERROR    = -3
+	LZHAM_Z_MEM_ERROR     = -4
+	LZHAM_Z_BUF_ERROR     = -5
+	LZHAM_Z_VERSION_ERROR = -6
+	LZHAM_Z_PARAM_ERROR   = -10000
+)
+
+// Compression levels. LZHAM_Z_UBER_COMPRESSION is LZHAM_COMP_LEVEL_UBER with LZHAM_COMP_FLAG_EXTREME_PARSING.
+const (
+	LZHAM_Z_NO_COMPRESSION      = 0
+	LZHAM_Z_BEST_SPEED          = 1
+	LZHAM_Z_BEST_COMPRESSION    = 9
+	LZHAM_Z_UBER_COMPRESSION    = 10
+	LZHAM_Z_DEFAULT_COMPRESSION = -1
+)
+
+// LZHAM_Z_DEFAULT_WINDOW_BITS is the dictionary size log2 of LZHAM_z_deflateInit, LZHAM_z_inflateInit and the one
+// call functions.
+const LZHAM_Z_DEFAULT_WINDOW_BITS = 15
+
+const LZHAM_Z_ADLER32_INIT = 1
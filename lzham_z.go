@@ -0,0 +1,499 @@
+package lzham
+
+import "hash/crc32"
+
+// LZHAM_z_stream is the state of a zlib style stream, laid out like zlib's z_stream so code written against the
+// lzham_z_* API carries over field for field. Next_in and Next_out are slices, of which the first Avail_in and
+// Avail_out bytes are used, and the functions advance them by reslicing.
+type LZHAM_z_stream struct {
+	Next_in  []byte // next input byte
+	Avail_in uint32 // number of bytes available at Next_in
+	Total_in uint64 // total number of bytes consumed so far
+
+	Next_out  []byte // next output byte should be put here
+	Avail_out uint32 // remaining free space at Next_out
+	Total_out uint64 // total number of bytes produced so far
+
+	Msg   string      // error msg (unused)
+	state interface{} // *LZHAM_compress_state or *lzham_z_inflate_state
+
+	Data_type int    // data_type (unused)
+	Adler     uint64 // adler32 of the source or uncompressed data
+	Reserved  uint64 // not used
+}
+
+// lzham_z_inflate_state is the decompressor of an inflate stream, and what it's done so far.
+type lzham_z_inflate_state struct {
+	pState      *LZHAM_decompress_state
+	last_status lzham_decompress_status_t
+	has_flushed bool
+}
+
+// LZHAM_z_version returns LZHAM_Z_VERSION.
+func LZHAM_z_version() string {
+	return LZHAM_Z_VERSION
+}
+
+// LZHAM_z_adler32 adds ptr to adler, the adler32 of the data before it. A nil ptr returns LZHAM_Z_ADLER32_INIT.
+func LZHAM_z_adler32(adler uint64, ptr []byte) uint64 {
+	if ptr == nil {
+		return LZHAM_Z_ADLER32_INIT
+	}
+	return uint64(lzham_adler32_update(uint32(adler), ptr))
+}
+
+// LZHAM_z_crc32 adds ptr to crc, the crc32 of the data before it. A nil ptr returns 0.
+func LZHAM_z_crc32(crc uint64, ptr []byte) uint64 {
+	if ptr == nil {
+		return 0
+	}
+	return uint64(crc32.Update(uint32(crc), crc32.IEEETable, ptr))
+}
+
+// LZHAM_z_deflateInit starts compressing with level and LZHAM_Z_DEFAULT_WINDOW_BITS.
+func LZHAM_z_deflateInit(pStream *LZHAM_z_stream, level int) int {
+	return LZHAM_z_deflateInit2(pStream, level, LZHAM_Z_LZHAM, LZHAM_Z_DEFAULT_WINDOW_BITS, 9, LZHAM_Z_DEFAULT_STRATEGY)
+}
+
+// LZHAM_z_deflateInit2 starts compressing with level, from LZHAM_Z_NO_COMPRESSION to LZHAM_Z_UBER_COMPRESSION or
+// LZHAM_Z_DEFAULT_COMPRESSION. window_bits is the dictionary size log2, negated for a stream without the zlib
+// header. LZHAM_Z_DEFLATED is taken as LZHAM_Z_LZHAM with LZHAM_Z_DEFAULT_WINDOW_BITS, so zlib code using it
+// works unchanged.
+func LZHAM_z_deflateInit2(pStream *LZHAM_z_stream, level, method, window_bits, mem_level, strategy int) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	if mem_level < 1 || mem_level > 9 {
+		return LZHAM_Z_PARAM_ERROR
+	}
+	if method != LZHAM_Z_DEFLATED && method != LZHAM_Z_LZHAM {
+		return LZHAM_Z_PARAM_ERROR
+	}
+	if level < LZHAM_Z_DEFAULT_COMPRESSION || level > LZHAM_Z_UBER_COMPRESSION {
+		return LZHAM_Z_PARAM_ERROR
+	}
+
+	if level == LZHAM_Z_DEFAULT_COMPRESSION {
+		level = LZHAM_Z_BEST_COMPRESSION
+	}
+	if method == LZHAM_Z_DEFLATED {
+		window_bits = LZHAM_Z_DEFAULT_WINDOW_BITS
+	}
+
+	dict_size_log2 := get_z_dict_size_log2(window_bits)
+	if dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
+		return LZHAM_Z_PARAM_ERROR
+	}
+
+	comp_params := LZHAM_compress_params{
+		dict_size_log2:     dict_size_log2,
+		level:              get_z_comp_level(level),
+		max_helper_threads: -1,
+	}
+	if level == LZHAM_Z_UBER_COMPRESSION {
+		comp_params.compress_flags |= uint32(LZHAM_COMP_FLAG_EXTREME_PARSING)
+	}
+	if window_bits > 0 {
+		comp_params.compress_flags |= uint32(LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM)
+	}
+
+	pStream.Data_type = 0
+	pStream.Adler = LZHAM_Z_ADLER32_INIT
+	pStream.Msg = ""
+	pStream.Reserved = 0
+	pStream.Total_in = 0
+	pStream.Total_out = 0
+
+	pComp, err := LZHAM_lib_compress_init(&comp_params)
+	if err != nil {
+		return LZHAM_Z_PARAM_ERROR
+	}
+	pStream.state = pComp
+
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_deflateReset starts a new stream with the same settings.
+func LZHAM_z_deflateReset(pStream *LZHAM_z_stream) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pComp, ok := pStream.state.(*LZHAM_compress_state)
+	if !ok {
+		return LZHAM_Z_STREAM_ERROR
+	}
+
+	if _, err := LZHAM_lib_compress_reinit(&pComp.params, pComp); err != nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+
+	pStream.Adler = LZHAM_Z_ADLER32_INIT
+	pStream.Total_in = 0
+	pStream.Total_out = 0
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_deflate compresses from Next_in to Next_out until one runs out, applying flush once all the input has
+// been taken. It returns LZHAM_Z_STREAM_END once LZHAM_Z_FINISH has written out the whole stream, and
+// LZHAM_Z_BUF_ERROR when it can't make progress.
+func LZHAM_z_deflate(pStream *LZHAM_z_stream, flush int) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pComp, ok := pStream.state.(*LZHAM_compress_state)
+	if !ok || !is_z_deflate_flush(flush) {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	if uint64(len(pStream.Next_in)) < uint64(pStream.Avail_in) || uint64(len(pStream.Next_out)) < uint64(pStream.Avail_out) {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	if pStream.Avail_out == 0 {
+		return LZHAM_Z_BUF_ERROR
+	}
+
+	if flush == LZHAM_Z_PARTIAL_FLUSH {
+		flush = LZHAM_Z_SYNC_FLUSH
+	}
+
+	lzham_status := LZHAM_Z_OK
+	orig_total_in, orig_total_out := pStream.Total_in, pStream.Total_out
+	for {
+		in_bytes, out_bytes, status := LZHAM_lib_compress(pComp, pStream.Next_in[:pStream.Avail_in], pStream.Next_out[:pStream.Avail_out], lzham_flush_t(flush))
+		pStream.advance(in_bytes, out_bytes)
+		pStream.Adler = uint64(pComp.compressor.src_adler32)
+
+		if status >= LZHAM_COMP_STATUS_FIRST_FAILURE_CODE {
+			lzham_status = LZHAM_Z_STREAM_ERROR
+			break
+		} else if status == LZHAM_COMP_STATUS_SUCCESS {
+			lzham_status = LZHAM_Z_STREAM_END
+			break
+		} else if pStream.Avail_out == 0 {
+			break
+		} else if pStream.Avail_in == 0 && flush != LZHAM_Z_FINISH {
+			if flush != LZHAM_Z_NO_FLUSH || pStream.Total_in != orig_total_in || pStream.Total_out != orig_total_out {
+				break
+			}
+			// Can't make forward progress without some input.
+			return LZHAM_Z_BUF_ERROR
+		}
+	}
+
+	return lzham_status
+}
+
+// LZHAM_z_deflateEnd ends compression, dropping whatever hasn't been finished.
+func LZHAM_z_deflateEnd(pStream *LZHAM_z_stream) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pStream.state = nil
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_deflateBound returns the most a stream of source_len bytes can compress to with the settings of
+// pStream, or those of LZHAM_z_compress2 if it isn't compressing.
+func LZHAM_z_deflateBound(pStream *LZHAM_z_stream, source_len uint64) uint64 {
+	comp_params := LZHAM_compress_params{
+		dict_size_log2: LZHAM_Z_DEFAULT_WINDOW_BITS,
+		compress_flags: uint32(LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM),
+	}
+	if pStream != nil {
+		if pComp, ok := pStream.state.(*LZHAM_compress_state); ok {
+			comp_params = pComp.params
+		}
+	}
+	return LZHAM_lib_compress_bound(source_len, &comp_params)
+}
+
+// LZHAM_z_compress compresses pSource[:source_len] into pDest at LZHAM_Z_DEFAULT_COMPRESSION, see LZHAM_z_compress2.
+func LZHAM_z_compress(pDest []byte, pDest_len *uint64, pSource []byte, source_len uint64) int {
+	return LZHAM_z_compress2(pDest, pDest_len, pSource, source_len, LZHAM_Z_DEFAULT_COMPRESSION)
+}
+
+// LZHAM_z_compress2 compresses pSource[:source_len] into a zlib stream in pDest, which has room for *pDest_len
+// bytes, setting *pDest_len to its size. LZHAM_Z_BUF_ERROR means it didn't fit, which it always does in
+// LZHAM_z_compressBound bytes.
+func LZHAM_z_compress2(pDest []byte, pDest_len *uint64, pSource []byte, source_len uint64, level int) int {
+	if pDest_len == nil || (source_len|*pDest_len) > 0xFFFFFFFF || source_len > uint64(len(pSource)) || *pDest_len > uint64(len(pDest)) {
+		return LZHAM_Z_PARAM_ERROR
+	}
+
+	var stream LZHAM_z_stream
+	stream.Next_in = pSource
+	stream.Avail_in = uint32(source_len)
+	stream.Next_out = pDest
+	stream.Avail_out = uint32(*pDest_len)
+
+	status := LZHAM_z_deflateInit(&stream, level)
+	if status != LZHAM_Z_OK {
+		return status
+	}
+
+	status = LZHAM_z_deflate(&stream, LZHAM_Z_FINISH)
+	if status != LZHAM_Z_STREAM_END {
+		LZHAM_z_deflateEnd(&stream)
+		if status == LZHAM_Z_OK {
+			return LZHAM_Z_BUF_ERROR
+		}
+		return status
+	}
+
+	*pDest_len = stream.Total_out
+	return LZHAM_z_deflateEnd(&stream)
+}
+
+// LZHAM_z_compressBound returns the most LZHAM_z_compress2 can compress source_len bytes to.
+func LZHAM_z_compressBound(source_len uint64) uint64 {
+	return LZHAM_z_deflateBound(nil, source_len)
+}
+
+// LZHAM_z_inflateInit starts decompressing a zlib stream with LZHAM_Z_DEFAULT_WINDOW_BITS.
+func LZHAM_z_inflateInit(pStream *LZHAM_z_stream) int {
+	return LZHAM_z_inflateInit2(pStream, LZHAM_Z_DEFAULT_WINDOW_BITS)
+}
+
+// LZHAM_z_inflateInit2 starts decompressing with window_bits, the dictionary size log2 the stream was compressed
+// with, negated for a stream without the zlib header.
+func LZHAM_z_inflateInit2(pStream *LZHAM_z_stream, window_bits int) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+
+	dict_size_log2 := get_z_dict_size_log2(window_bits)
+	if dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
+		return LZHAM_Z_PARAM_ERROR
+	}
+
+	params := LZHAM_decompress_params{
+		dict_size_log2:   dict_size_log2,
+		decompress_flags: uint32(LZHAM_DECOMP_FLAG_COMPUTE_ADLER32),
+	}
+	if window_bits > 0 {
+		params.decompress_flags |= uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM)
+	}
+
+	pStream.Data_type = 0
+	pStream.Adler = LZHAM_Z_ADLER32_INIT
+	pStream.Msg = ""
+	pStream.Total_in = 0
+	pStream.Total_out = 0
+	pStream.Reserved = 0
+
+	pState, err := LZHAM_lib_decompress_init(&params)
+	if err != nil {
+		return LZHAM_Z_MEM_ERROR
+	}
+	pStream.state = &lzham_z_inflate_state{pState: pState, last_status: LZHAM_DECOMP_STATUS_NOT_FINISHED}
+
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_inflateReset starts a new stream with the same settings.
+func LZHAM_z_inflateReset(pStream *LZHAM_z_stream) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pInflate, ok := pStream.state.(*lzham_z_inflate_state)
+	if !ok {
+		return LZHAM_Z_STREAM_ERROR
+	}
+
+	params := pInflate.pState.s.params
+	if _, err := LZHAM_lib_decompress_reinit(&params, pInflate.pState); err != nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pInflate.last_status = LZHAM_DECOMP_STATUS_NOT_FINISHED
+	pInflate.has_flushed = false
+
+	pStream.Adler = LZHAM_Z_ADLER32_INIT
+	pStream.Total_in = 0
+	pStream.Total_out = 0
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_inflate decompresses from Next_in to Next_out until one runs out. With LZHAM_Z_FINISH all the rest of
+// the stream must be in Next_in, and all its data fit in Next_out. It returns LZHAM_Z_STREAM_END once all the
+// data has been returned, and LZHAM_Z_BUF_ERROR when it can't make progress.
+func LZHAM_z_inflate(pStream *LZHAM_z_stream, flush int) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pInflate, ok := pStream.state.(*lzham_z_inflate_state)
+	if !ok {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	if uint64(len(pStream.Next_in)) < uint64(pStream.Avail_in) || uint64(len(pStream.Next_out)) < uint64(pStream.Avail_out) {
+		return LZHAM_Z_STREAM_ERROR
+	}
+
+	if flush == LZHAM_Z_PARTIAL_FLUSH {
+		flush = LZHAM_Z_SYNC_FLUSH
+	}
+	if flush != LZHAM_Z_NO_FLUSH && flush != LZHAM_Z_SYNC_FLUSH && flush != LZHAM_Z_FINISH {
+		return LZHAM_Z_STREAM_ERROR
+	}
+
+	orig_avail_in := pStream.Avail_in
+
+	if pInflate.last_status >= LZHAM_DECOMP_STATUS_FIRST_SUCCESS_OR_FAILURE_CODE {
+		return LZHAM_Z_DATA_ERROR
+	}
+	if pInflate.has_flushed && flush != LZHAM_Z_FINISH {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	pInflate.has_flushed = pInflate.has_flushed || flush == LZHAM_Z_FINISH
+
+	var status lzham_decompress_status_t
+	for {
+		no_more_input_bytes_flag := flush == LZHAM_Z_FINISH
+		var in_bytes, out_bytes uint64
+		in_bytes, out_bytes, status = LZHAM_lib_decompress(pInflate.pState, pStream.Next_in[:pStream.Avail_in], pStream.Next_out[:pStream.Avail_out], no_more_input_bytes_flag)
+		pInflate.last_status = status
+		pStream.advance(in_bytes, out_bytes)
+		pStream.Adler = uint64(pInflate.pState.s.d.adler32)
+
+		if status >= LZHAM_DECOMP_STATUS_FIRST_FAILURE_CODE {
+			if status == LZHAM_DECOMP_STATUS_FAILED_NEED_SEED_BYTES {
+				return LZHAM_Z_NEED_DICT
+			}
+			// Stream is corrupted, though the data before the bad block has been returned.
+			return LZHAM_Z_DATA_ERROR
+		}
+
+		if status == LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT && orig_avail_in == 0 {
+			// Signal caller that we can't make forward progress without supplying more input, or by setting flush
+			// to LZHAM_Z_FINISH.
+			return LZHAM_Z_BUF_ERROR
+		}
+
+		if flush == LZHAM_Z_FINISH {
+			if status == LZHAM_DECOMP_STATUS_SUCCESS {
+				return LZHAM_Z_STREAM_END
+			} else if status == LZHAM_DECOMP_STATUS_HAS_MORE_OUTPUT {
+				// There's at least one more byte on the way, but no room left in Next_out.
+				return LZHAM_Z_BUF_ERROR
+			}
+		} else if status == LZHAM_DECOMP_STATUS_SUCCESS || pStream.Avail_in == 0 || pStream.Avail_out == 0 {
+			break
+		}
+	}
+
+	if status == LZHAM_DECOMP_STATUS_SUCCESS {
+		return LZHAM_Z_STREAM_END
+	}
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_inflateEnd ends decompression, dropping whatever hasn't been finished.
+func LZHAM_z_inflateEnd(pStream *LZHAM_z_stream) int {
+	if pStream == nil {
+		return LZHAM_Z_STREAM_ERROR
+	}
+	if pInflate, ok := pStream.state.(*lzham_z_inflate_state); ok {
+		LZHAM_lib_decompress_deinit(pInflate.pState)
+	}
+	pStream.state = nil
+	return LZHAM_Z_OK
+}
+
+// LZHAM_z_uncompress decompresses the zlib stream in pSource[:source_len] into pDest, which has room for
+// *pDest_len bytes, setting *pDest_len to the size of the data. LZHAM_Z_BUF_ERROR means it didn't fit.
+func LZHAM_z_uncompress(pDest []byte, pDest_len *uint64, pSource []byte, source_len uint64) int {
+	if pDest_len == nil || (source_len|*pDest_len) > 0xFFFFFFFF || source_len > uint64(len(pSource)) || *pDest_len > uint64(len(pDest)) {
+		return LZHAM_Z_PARAM_ERROR
+	}
+
+	var stream LZHAM_z_stream
+	stream.Next_in = pSource
+	stream.Avail_in = uint32(source_len)
+	stream.Next_out = pDest
+	stream.Avail_out = uint32(*pDest_len)
+
+	status := LZHAM_z_inflateInit(&stream)
+	if status != LZHAM_Z_OK {
+		return status
+	}
+
+	status = LZHAM_z_inflate(&stream, LZHAM_Z_FINISH)
+	if status != LZHAM_Z_STREAM_END {
+		LZHAM_z_inflateEnd(&stream)
+		if status == LZHAM_Z_BUF_ERROR && stream.Avail_in == 0 {
+			return LZHAM_Z_DATA_ERROR
+		}
+		return status
+	}
+
+	*pDest_len = stream.Total_out
+	return LZHAM_z_inflateEnd(&stream)
+}
+
+// LZHAM_z_error returns the message of a status code, or "" for an unknown one.
+func LZHAM_z_error(err int) string {
+	switch err {
+	case LZHAM_Z_OK:
+		return ""
+	case LZHAM_Z_STREAM_END:
+		return "stream end"
+	case LZHAM_Z_NEED_DICT:
+		return "need dictionary"
+	case LZHAM_Z_ERRNO:
+		return "file error"
+	case LZHAM_Z_STREAM_ERROR:
+		return "stream error"
+	case LZHAM_Z_DATA_ERROR:
+		return "data error"
+	case LZHAM_Z_MEM_ERROR:
+		return "out of memory"
+	case LZHAM_Z_BUF_ERROR:
+		return "buf error"
+	case LZHAM_Z_VERSION_ERROR:
+		return "version error"
+	case LZHAM_Z_PARAM_ERROR:
+		return "parameter error"
+	}
+	return ""
+}
+
+// advance moves Next_in and Next_out past the bytes a call used.
+func (pStream *LZHAM_z_stream) advance(in_bytes, out_bytes uint64) {
+	pStream.Next_in = pStream.Next_in[in_bytes:]
+	pStream.Avail_in -= uint32(in_bytes)
+	pStream.Total_in += in_bytes
+
+	pStream.Next_out = pStream.Next_out[out_bytes:]
+	pStream.Avail_out -= uint32(out_bytes)
+	pStream.Total_out += out_bytes
+}
+
+// is_z_deflate_flush reports whether flush is one LZHAM_z_deflate takes.
+func is_z_deflate_flush(flush int) bool {
+	switch flush {
+	case LZHAM_Z_NO_FLUSH, LZHAM_Z_PARTIAL_FLUSH, LZHAM_Z_SYNC_FLUSH, LZHAM_Z_FULL_FLUSH, LZHAM_Z_FINISH, LZHAM_Z_TABLE_FLUSH:
+		return true
+	}
+	return false
+}
+
+// get_z_dict_size_log2 returns the dictionary size log2 of window_bits, which is negative without the zlib header.
+func get_z_dict_size_log2(window_bits int) uint32 {
+	if window_bits < 0 {
+		window_bits = -window_bits
+	}
+	return uint32(window_bits)
+}
+
+// get_z_comp_level maps a zlib level onto the compressor's levels.
+func get_z_comp_level(level int) lzham_compress_level {
+	switch {
+	case level <= 1:
+		return LZHAM_COMP_LEVEL_FASTEST
+	case level <= 3:
+		return LZHAM_COMP_LEVEL_FASTER
+	case level <= 5:
+		return LZHAM_COMP_LEVEL_DEFAULT
+	case level <= 7:
+		return LZHAM_COMP_LEVEL_BETTER
+	}
+	return LZHAM_COMP_LEVEL_UBER
+}
@@ -0,0 +1,287 @@
+package lzham
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_LZHAM_z_compress2(t *testing.T) {
+	data := append(gen_test_log(90, 40<<10), gen_test_records(91, 40<<10)...)
+
+	tests := []struct {
+		name  string
+		level int
+		want  int
+	}{
+		{name: "default", level: LZHAM_Z_DEFAULT_COMPRESSION, want: LZHAM_Z_OK},
+		{name: "no compression", level: LZHAM_Z_NO_COMPRESSION, want: LZHAM_Z_OK},
+		{name: "best speed", level: LZHAM_Z_BEST_SPEED, want: LZHAM_Z_OK},
+		{name: "5", level: 5, want: LZHAM_Z_OK},
+		{name: "best compression", level: LZHAM_Z_BEST_COMPRESSION, want: LZHAM_Z_OK},
+		{name: "bad level", level: LZHAM_Z_UBER_COMPRESSION + 1, want: LZHAM_Z_PARAM_ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp_len := LZHAM_z_compressBound(uint64(len(data)))
+			comp := make([]byte, comp_len)
+			if status := LZHAM_z_compress2(comp, &comp_len, data, uint64(len(data)), tt.level); status != tt.want {
+				t.Fatalf("LZHAM_z_compress2() = %d, want %d", status, tt.want)
+			}
+			if tt.want != LZHAM_Z_OK {
+				return
+			}
+
+			out_len := uint64(len(data))
+			out := make([]byte, out_len)
+			if status := LZHAM_z_uncompress(out, &out_len, comp, comp_len); status != LZHAM_Z_OK {
+				t.Fatalf("LZHAM_z_uncompress() = %d", status)
+			}
+			if !bytes.Equal(out[:out_len], data) {
+				t.Fatalf("uncompressed %d bytes, which don't match", out_len)
+			}
+
+			short_len := comp_len / 2
+			if status := LZHAM_z_compress2(comp, &short_len, data, uint64(len(data)), tt.level); status != LZHAM_Z_BUF_ERROR {
+				t.Errorf("LZHAM_z_compress2() into half the room = %d, want LZHAM_Z_BUF_ERROR", status)
+			}
+		})
+	}
+}
+
+func Test_LZHAM_z_uncompress(t *testing.T) {
+	data := gen_test_log(92, 50<<10)
+	comp_len := LZHAM_z_compressBound(uint64(len(data)))
+	comp := make([]byte, comp_len)
+	if status := LZHAM_z_compress(comp, &comp_len, data, uint64(len(data))); status != LZHAM_Z_OK {
+		t.Fatalf("LZHAM_z_compress() = %d", status)
+	}
+	comp = comp[:comp_len]
+
+	corrupt := append([]byte(nil), comp...)
+	corrupt[len(corrupt)/2] ^= 0x10
+	bad_header := append([]byte(nil), comp...)
+	bad_header[0]++
+
+	tests := []struct {
+		name    string
+		src     []byte
+		out_len uint64
+		want    int
+	}{
+		{name: "ok", src: comp, out_len: uint64(len(data)), want: LZHAM_Z_OK},
+		{name: "too small", src: comp, out_len: uint64(len(data)) - 1, want: LZHAM_Z_BUF_ERROR},
+		{name: "truncated", src: comp[:len(comp)-1], out_len: uint64(len(data)), want: LZHAM_Z_DATA_ERROR},
+		{name: "corrupt", src: corrupt, out_len: uint64(len(data)), want: LZHAM_Z_DATA_ERROR},
+		{name: "bad header", src: bad_header, out_len: uint64(len(data)), want: LZHAM_Z_DATA_ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out_len := tt.out_len
+			out := make([]byte, out_len)
+			if status := LZHAM_z_uncompress(out, &out_len, tt.src, uint64(len(tt.src))); status != tt.want {
+				t.Fatalf("LZHAM_z_uncompress() = %d (%s), want %d", status, LZHAM_z_error(status), tt.want)
+			}
+			if tt.want == LZHAM_Z_OK && !bytes.Equal(out[:out_len], data) {
+				t.Fatalf("uncompressed %d bytes, which don't match", out_len)
+			}
+		})
+	}
+}
+
+// deflate_test_stream compresses data the way zlib's example does, chunk bytes at a time in and out, flushing
+// with flush after every chunk.
+func deflate_test_stream(tb testing.TB, pStream *LZHAM_z_stream, data []byte, chunk, flush int) []byte {
+	var comp []byte
+	out := make([]byte, chunk)
+	for ofs := 0; ; ofs += chunk {
+		end := ofs + chunk
+		mode := flush
+		if end >= len(data) {
+			end = len(data)
+			mode = LZHAM_Z_FINISH
+		}
+		pStream.Next_in = data[ofs:end]
+		pStream.Avail_in = uint32(end - ofs)
+
+		for {
+			pStream.Next_out = out
+			pStream.Avail_out = uint32(len(out))
+			status := LZHAM_z_deflate(pStream, mode)
+			if status != LZHAM_Z_OK && status != LZHAM_Z_STREAM_END && status != LZHAM_Z_BUF_ERROR {
+				tb.Fatalf("LZHAM_z_deflate() = %d", status)
+			}
+			comp = append(comp, out[:len(out)-int(pStream.Avail_out)]...)
+			if pStream.Avail_out != 0 {
+				break
+			}
+		}
+		if pStream.Avail_in != 0 {
+			tb.Fatalf("LZHAM_z_deflate() left %d bytes of input", pStream.Avail_in)
+		}
+		if mode == LZHAM_Z_FINISH {
+			return comp
+		}
+	}
+}
+
+// inflate_test_stream decompresses comp chunk bytes at a time in and out, until the end of the stream.
+func inflate_test_stream(tb testing.TB, pStream *LZHAM_z_stream, comp []byte, chunk int) []byte {
+	var data []byte
+	out := make([]byte, chunk)
+	for ofs := 0; ; ofs += chunk {
+		end := ofs + chunk
+		if end > len(comp) {
+			end = len(comp)
+		}
+		pStream.Next_in = comp[ofs:end]
+		pStream.Avail_in = uint32(end - ofs)
+
+		for {
+			pStream.Next_out = out
+			pStream.Avail_out = uint32(len(out))
+			status := LZHAM_z_inflate(pStream, LZHAM_Z_NO_FLUSH)
+			data = append(data, out[:len(out)-int(pStream.Avail_out)]...)
+			if status == LZHAM_Z_STREAM_END {
+				return data
+			}
+			if status != LZHAM_Z_OK && status != LZHAM_Z_BUF_ERROR {
+				tb.Fatalf("LZHAM_z_inflate() = %d", status)
+			}
+			if pStream.Avail_out != 0 {
+				break
+			}
+		}
+		if end == len(comp) {
+			tb.Fatal("ran out of input before the end of the stream")
+		}
+	}
+}
+
+func Test_LZHAM_z_deflate(t *testing.T) {
+	data := append(gen_test_log(93, 100<<10), gen_test_records(94, 100<<10)...)
+
+	tests := []struct {
+		name        string
+		level       int
+		window_bits int
+		chunk       int
+		flush       int
+	}{
+		{name: "zlib example", level: LZHAM_Z_DEFAULT_COMPRESSION, window_bits: LZHAM_Z_DEFAULT_WINDOW_BITS, chunk: 16384, flush: LZHAM_Z_NO_FLUSH},
+		{name: "raw", level: LZHAM_Z_BEST_SPEED, window_bits: -18, chunk: 16384, flush: LZHAM_Z_NO_FLUSH},
+		{name: "small chunks", level: 4, window_bits: 20, chunk: 100, flush: LZHAM_Z_NO_FLUSH},
+		{name: "sync flushes", level: 6, window_bits: 16, chunk: 5000, flush: LZHAM_Z_SYNC_FLUSH},
+		{name: "full flushes", level: 6, window_bits: 16, chunk: 5000, flush: LZHAM_Z_FULL_FLUSH},
+		{name: "table flushes", level: 6, window_bits: 16, chunk: 5000, flush: LZHAM_Z_TABLE_FLUSH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream LZHAM_z_stream
+			if status := LZHAM_z_deflateInit2(&stream, tt.level, LZHAM_Z_LZHAM, tt.window_bits, 9, LZHAM_Z_DEFAULT_STRATEGY); status != LZHAM_Z_OK {
+				t.Fatalf("LZHAM_z_deflateInit2() = %d", status)
+			}
+			comp := deflate_test_stream(t, &stream, data, tt.chunk, tt.flush)
+			if stream.Total_in != uint64(len(data)) || stream.Total_out != uint64(len(comp)) || stream.Adler != LZHAM_z_adler32(LZHAM_Z_ADLER32_INIT, data) {
+				t.Errorf("Total_in %d, Total_out %d, Adler %#x after deflate", stream.Total_in, stream.Total_out, stream.Adler)
+			}
+			if bound := LZHAM_z_deflateBound(&stream, uint64(len(data))); uint64(len(comp)) > bound {
+				t.Errorf("compressed to %d bytes, over the bound of %d", len(comp), bound)
+			}
+			if status := LZHAM_z_deflateEnd(&stream); status != LZHAM_Z_OK {
+				t.Fatalf("LZHAM_z_deflateEnd() = %d", status)
+			}
+
+			if status := LZHAM_z_inflateInit2(&stream, tt.window_bits); status != LZHAM_Z_OK {
+				t.Fatalf("LZHAM_z_inflateInit2() = %d", status)
+			}
+			defer LZHAM_z_inflateEnd(&stream)
+
+			for i := 0; i < 2; i++ {
+				out := inflate_test_stream(t, &stream, append(comp, "trailer"...), tt.chunk)
+				if !bytes.Equal(out, data) {
+					t.Fatalf("inflated %d bytes, which don't match", len(out))
+				}
+				if stream.Total_in != uint64(len(comp)) || stream.Total_out != uint64(len(data)) || stream.Adler != LZHAM_z_adler32(LZHAM_Z_ADLER32_INIT, data) {
+					t.Errorf("Total_in %d, Total_out %d, Adler %#x after inflate", stream.Total_in, stream.Total_out, stream.Adler)
+				}
+				if status := LZHAM_z_inflate(&stream, LZHAM_Z_NO_FLUSH); status != LZHAM_Z_DATA_ERROR {
+					t.Errorf("LZHAM_z_inflate() after the end = %d, want LZHAM_Z_DATA_ERROR", status)
+				}
+				if status := LZHAM_z_inflateReset(&stream); status != LZHAM_Z_OK {
+					t.Fatalf("LZHAM_z_inflateReset() = %d", status)
+				}
+			}
+		})
+	}
+}
+
+func Test_LZHAM_z_deflate_sync_flush(t *testing.T) {
+	data := gen_test_log(95, 30<<10)
+
+	var deflate_stream, inflate_stream LZHAM_z_stream
+	if status := LZHAM_z_deflateInit(&deflate_stream, LZHAM_Z_BEST_SPEED); status != LZHAM_Z_OK {
+		t.Fatalf("LZHAM_z_deflateInit() = %d", status)
+	}
+	defer LZHAM_z_deflateEnd(&deflate_stream)
+	if status := LZHAM_z_inflateInit(&inflate_stream); status != LZHAM_Z_OK {
+		t.Fatalf("LZHAM_z_inflateInit() = %d", status)
+	}
+	defer LZHAM_z_inflateEnd(&inflate_stream)
+
+	// Everything deflated before each sync flush inflates without any more of the stream.
+	comp := make([]byte, 64<<10)
+	out := make([]byte, len(data))
+	for ofs := 0; ofs < len(data); ofs += 3000 {
+		end := ofs + 3000
+		if end > len(data) {
+			end = len(data)
+		}
+		deflate_stream.Next_in, deflate_stream.Avail_in = data[ofs:end], uint32(end-ofs)
+		deflate_stream.Next_out, deflate_stream.Avail_out = comp, uint32(len(comp))
+		if status := LZHAM_z_deflate(&deflate_stream, LZHAM_Z_SYNC_FLUSH); status != LZHAM_Z_OK {
+			t.Fatalf("LZHAM_z_deflate() = %d", status)
+		}
+		comp_len := len(comp) - int(deflate_stream.Avail_out)
+
+		inflate_stream.Next_in, inflate_stream.Avail_in = comp, uint32(comp_len)
+		inflate_stream.Next_out, inflate_stream.Avail_out = out[ofs:], uint32(len(out)-ofs)
+		if status := LZHAM_z_inflate(&inflate_stream, LZHAM_Z_SYNC_FLUSH); status != LZHAM_Z_OK {
+			t.Fatalf("LZHAM_z_inflate() = %d", status)
+		}
+		if inflate_stream.Total_out != uint64(end) || !bytes.Equal(out[:end], data[:end]) {
+			t.Fatalf("inflated %d bytes after a flush at %d", inflate_stream.Total_out, end)
+		}
+	}
+
+	if status := LZHAM_z_deflate(&deflate_stream, LZHAM_Z_NO_FLUSH); status != LZHAM_Z_BUF_ERROR {
+		t.Errorf("LZHAM_z_deflate() without input = %d, want LZHAM_Z_BUF_ERROR", status)
+	}
+}
+
+func Test_LZHAM_z_init_errors(t *testing.T) {
+	var stream LZHAM_z_stream
+
+	tests := []struct {
+		name string
+		got  int
+		want int
+	}{
+		{name: "deflateInit2 nil", got: LZHAM_z_deflateInit2(nil, 5, LZHAM_Z_LZHAM, 15, 9, 0), want: LZHAM_Z_STREAM_ERROR},
+		{name: "deflateInit2 bad method", got: LZHAM_z_deflateInit2(&stream, 5, 7, 15, 9, 0), want: LZHAM_Z_PARAM_ERROR},
+		{name: "deflateInit2 bad mem_level", got: LZHAM_z_deflateInit2(&stream, 5, LZHAM_Z_LZHAM, 15, 0, 0), want: LZHAM_Z_PARAM_ERROR},
+		{name: "deflateInit2 window too small", got: LZHAM_z_deflateInit2(&stream, 5, LZHAM_Z_LZHAM, 14, 9, 0), want: LZHAM_Z_PARAM_ERROR},
+		{name: "deflateInit2 window too large", got: LZHAM_z_deflateInit2(&stream, 5, LZHAM_Z_LZHAM, -(LZHAM_MAX_DICT_SIZE_LOG2 + 1), 9, 0), want: LZHAM_Z_PARAM_ERROR},
+		{name: "deflateInit2 deflated", got: LZHAM_z_deflateInit2(&stream, 5, LZHAM_Z_DEFLATED, 9, 9, 0), want: LZHAM_Z_OK},
+		{name: "inflateInit2 nil", got: LZHAM_z_inflateInit2(nil, 15), want: LZHAM_Z_STREAM_ERROR},
+		{name: "inflateInit2 window too small", got: LZHAM_z_inflateInit2(&stream, -14), want: LZHAM_Z_PARAM_ERROR},
+		{name: "deflate without init", got: LZHAM_z_deflate(&LZHAM_z_stream{}, LZHAM_Z_FINISH), want: LZHAM_Z_STREAM_ERROR},
+		{name: "inflate without init", got: LZHAM_z_inflate(&LZHAM_z_stream{}, LZHAM_Z_FINISH), want: LZHAM_Z_STREAM_ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %d, want %d", tt.got, tt.want)
+			}
+		})
+	}
+}
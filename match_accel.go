@@ -1,5 +1,7 @@
 package lzham
 
+import "unsafe"
+
 const (
 	cHashSize24 = 0x1000000
 	cHashSize16 = 0x10000
@@ -10,119 +12,76 @@ const (
 	cMatchAccelMaxSupportedThreads uint32 = 32
 )
 
-// If all_matches is true, the match finder returns all found matches with no filtering.
-// Otherwise, the finder will tend to return lists of matches with mostly unique lengths.
-// For each length, it will discard matches with worse distances (in the coding sense).
-const (
-	cFlagDeterministic = 1 << 0
-	cFlagLen2Matches   = 1 << 1
-	cFlagHash24        = 1 << 2
-)
-
 type node struct {
 	left  uint32
 	right uint32
 }
 
-type dict_match struct {
-	dist uint32
-	len  uint16
-}
-
-func (d dict_match) get_dist() uint32 {
-	return d.dist & 0x7FFFFFFF
-}
-
-func (d dict_match) get_len() uint16 {
-	return d.len + 2
-}
-
-func (d dict_match) is_last() bool {
-	return d.dist < 0
-}
-
+// search_accelerator is the binary tree match finder.
 type search_accelerator struct {
+	match_window
+
 	// CLZBase            *m_pLZBase figure out later
+	pTask_pool         *task_pool
 	max_helper_threads uint32
 
-	max_dict_size      uint32
-	max_dict_size_mask uint32
-
-	lookahead_pos  uint32
-	lookahead_size uint32
-
-	cur_dict_size uint32
-
-	dict []byte
-
 	hash  []uint32
 	nodes []node
 
-	matches    []dict_match
-	match_refs []int32
-
-	digram_hash []uint32
-	digram_next []uint32
-
 	thread_dict_offsets [cMatchAccelMaxSupportedThreads]uint32
 
-	fill_lookahead_pos  uint32
-	fill_lookahead_size uint32
-	fill_dict_size      uint32
-
 	max_probes  uint32
 	max_matches uint32
 
 	all_matches bool
 
 	deterministic bool
-	len2_matches  bool
 	hash24        bool
 
-	next_match_ref int32
-
 	num_completed_helper_threads int32
-}
 
-const (
-	cDigramHashSize = 4096
-)
+	// With helper threads, the match lists each thread found, and which thread found each lookahead position's list.
+	// They're merged into the window's match lists in position order once every thread is done.
+	thread_matches [cMatchAccelMaxSupportedThreads][]dict_match
+	match_owners   []uint8
+}
 
-func (sa *search_accelerator) init(max_helper_threads, max_dict_size, max_matches uint32, all_matches bool, max_probes, flags uint32) bool {
-	if !is_power_of_2(uint64(max_dict_size)) {
-		return false
-	}
+func (sa *search_accelerator) init(pPool *task_pool, max_helper_threads, max_dict_size, max_matches uint32, all_matches bool, max_probes, flags uint32, max_bytes uint64) bool {
 	if max_probes == 0 {
 		max_probes = 1
 	}
 
 	sa.max_probes = LZHAM_MIN(cMatchAccelMaxSupportedProbes, max_probes)
 	sa.deterministic = (flags & cFlagDeterministic) != 0
-	sa.len2_matches = (flags & cFlagLen2Matches) != 0
 	sa.hash24 = (flags & cFlagHash24) != 0
+	sa.pTask_pool = pPool
+
+	// A memory budget caps the match lists as they're found, so it's kept to one thread rather than having every
+	// thread's lists held at once.
 	sa.max_helper_threads = 0
+	if pPool != nil && max_bytes == 0 {
+		sa.max_helper_threads = LZHAM_MIN(LZHAM_MIN(max_helper_threads, pPool.get_num_threads()), cMatchAccelMaxSupportedThreads-1)
+	}
 	sa.max_matches = LZHAM_MIN(sa.max_probes, max_matches)
 	sa.all_matches = all_matches
-
-	sa.max_dict_size = max_dict_size
-	sa.max_dict_size_mask = max_dict_size - 1
-	sa.cur_dict_size = 0
-	sa.lookahead_size = 0
-	sa.lookahead_pos = 0
-	sa.fill_lookahead_pos = 0
-	sa.fill_lookahead_size = 0
-	sa.fill_dict_size = 0
 	sa.num_completed_helper_threads = 0
 
-	sa.dict = make([]byte, max_dict_size+LZHAM_MIN(sa.max_dict_size, cMaxHugeMatchLen))
+	// The 24-bit hash alone is 64MB, don't let it eat more than half the budget.
+	if sa.hash24 && max_bytes > 0 && cHashSize24*4 > max_bytes/2 {
+		sa.hash24 = false
+	}
 
+	hash_size := uint32(cHashSize16)
 	if sa.hash24 {
-		sa.hash = make([]uint32, cHashSize24)
-	} else {
-		sa.hash = make([]uint32, cHashSize16)
+		hash_size = cHashSize24
 	}
 
-	sa.nodes = make([]node, max_dict_size)
+	if !sa.init_window(max_dict_size, uint64(unsafe.Sizeof(node{})), hash_size, flags, max_bytes) {
+		return false
+	}
+
+	sa.hash = make([]uint32, hash_size)
+	sa.nodes = make([]node, sa.alloc_size)
 
 	var i uint32
 	for i = 0; i < max_helper_threads; i++ {
@@ -132,13 +91,13 @@ func (sa *search_accelerator) init(max_helper_threads, max_dict_size, max_matche
 	return true
 }
 
+// get_memory_usage returns the bytes currently allocated by the match finder.
+func (sa *search_accelerator) get_memory_usage() uint64 {
+	return sa.get_window_memory_usage() + uint64(len(sa.nodes))*uint64(unsafe.Sizeof(node{})) + uint64(len(sa.hash))*4
+}
+
 func (sa *search_accelerator) reset() {
-	sa.cur_dict_size = 0
-	sa.lookahead_size = 0
-	sa.lookahead_pos = 0
-	sa.fill_lookahead_pos = 0
-	sa.fill_lookahead_size = 0
-	sa.fill_dict_size = 0
+	sa.reset_window()
 	sa.num_completed_helper_threads = 0
 
 	if len(sa.hash) > 0 {
@@ -147,34 +106,260 @@ func (sa *search_accelerator) reset() {
 			copy(sa.hash[bp:], sa.hash[:bp])
 		}
 	}
+}
 
-	if len(sa.digram_hash) > 0 {
-		sa.digram_hash[0] = 0
-		for bp := 1; bp < len(sa.digram_hash); bp *= 2 {
-			copy(sa.digram_hash[bp:], sa.digram_hash[:bp])
+func (sa *search_accelerator) add_bytes_begin(num_bytes uint32, pBytes []byte) bool {
+	if !sa.begin_add(num_bytes, pBytes) {
+		return false
+	}
+
+	if uint32(len(sa.nodes)) < sa.alloc_size {
+		nodes := make([]node, sa.alloc_size)
+		copy(nodes, sa.nodes)
+		sa.nodes = nodes
+	}
+
+	return sa.find_all_matches()
+}
+
+// find_all_matches finds the matches at every lookahead position. Each position is inserted into the binary tree of
+// its hash bucket, and only ever searches that tree, so with helper threads every thread takes the buckets h with
+// h%num_threads equal to its index and the lists come out just as they do on one thread.
+func (sa *search_accelerator) find_all_matches() bool {
+	num_threads := sa.max_helper_threads + 1
+	if num_threads == 1 {
+		return sa.find_all_matches_callback(0, 1)
+	}
+
+	if uint32(cap(sa.match_owners)) < sa.fill_lookahead_size {
+		sa.match_owners = make([]uint8, sa.fill_lookahead_size)
+	}
+	sa.match_owners = sa.match_owners[:sa.fill_lookahead_size]
+
+	var ok [cMatchAccelMaxSupportedThreads]bool
+	for t := uint32(1); t < num_threads; t++ {
+		thread_index := t
+		sa.pTask_pool.queue_task(func() { ok[thread_index] = sa.find_all_matches_callback(thread_index, num_threads) })
+	}
+	ok[0] = sa.find_all_matches_callback(0, num_threads)
+	sa.pTask_pool.join()
+
+	for t := uint32(0); t < num_threads; t++ {
+		if !ok[t] {
+			return false
+		}
+	}
+
+	for fill_ofs, match_ref := range sa.match_refs {
+		if match_ref < 0 {
+			continue
+		}
+		matches := sa.thread_matches[sa.match_owners[fill_ofs]][match_ref:]
+		num_matches := 1
+		for !matches[num_matches-1].is_last() {
+			num_matches++
 		}
+		sa.set_match_list(uint32(fill_ofs), matches[:num_matches], uint32(num_matches))
+	}
+	return true
+}
+
+// set_thread_match_list records the match list thread_index found for the lookahead position fill_ofs, like
+// set_match_list does for the window.
+func (sa *search_accelerator) set_thread_match_list(thread_index, fill_ofs uint32, matches []dict_match) {
+	num_matches := LZHAM_MIN(uint32(len(matches)), sa.max_matches)
+	if num_matches == 0 {
+		sa.match_refs[fill_ofs] = -2
+		return
 	}
+
+	matches = matches[uint32(len(matches))-num_matches:]
+	matches[num_matches-1].dist |= 0x80000000
+
+	sa.match_refs[fill_ofs] = int32(len(sa.thread_matches[thread_index]))
+	sa.match_owners[fill_ofs] = uint8(thread_index)
+	sa.thread_matches[thread_index] = append(sa.thread_matches[thread_index], matches...)
 }
 
-func (sa *search_accelerator) add_bytes_begin(num_bytes uint32, pBytes []byte) {
-	var add_pos uint32 = sa.lookahead_pos & sa.max_dict_size_mask
+func (sa *search_accelerator) add_bytes_end() {
+	sa.num_completed_helper_threads = 0
+}
 
-	n := copy(sa.dict[add_pos:], pBytes[:num_bytes])
-	if uint32(n) != num_bytes {
-		panic("copy failed")
+// get_hash returns the hash of the three bytes at dictionary offset pos.
+func (sa *search_accelerator) get_hash(pos uint32) uint32 {
+	c0, c1, c2 := uint32(sa.dict[pos]), uint32(sa.dict[pos+1]), uint32(sa.dict[pos+2])
+	if sa.hash24 {
+		return c0 | (c1 << 8) | (c2 << 16)
 	}
+	return hash3_to_16(c0, c1, c2)
+}
 
-	var dict_bytes_to_mirror uint32 = LZHAM_MIN(cMaxHugeMatchLen, sa.max_dict_size)
-	if add_pos < dict_bytes_to_mirror {
-		copy(sa.dict[sa.max_dict_size:], sa.dict[0:dict_bytes_to_mirror])
+// find_all_matches_callback inserts the lookahead positions whose hash thread_index takes into the binary tree and
+// records their match lists. Positions that have slid out of the window are never freed explicitly: a search stops
+// as soon as it reaches one, and the node slot is reused when the window wraps around onto it. It returns false if
+// canceled part way.
+func (sa *search_accelerator) find_all_matches_callback(thread_index, num_threads uint32) bool {
+	var temp_matches [cMatchAccelMaxSupportedProbes * 2]dict_match
+	sa.thread_matches[thread_index] = sa.thread_matches[thread_index][:0]
+
+	fill_lookahead_pos := sa.fill_lookahead_pos
+	fill_dict_size := sa.fill_dict_size
+	fill_lookahead_size := sa.fill_lookahead_size
+
+	var c0, c1 uint32
+	if fill_lookahead_size >= 2 {
+		c0 = uint32(sa.dict[fill_lookahead_pos&sa.max_dict_size_mask])
+		c1 = uint32(sa.dict[(fill_lookahead_pos&sa.max_dict_size_mask)+1])
 	}
 
-	sa.lookahead_size = num_bytes
+	dict := sa.dict
 
-	var max_possible_dict_size uint32 = sa.max_dict_size - num_bytes
-	sa.cur_dict_size = LZHAM_MIN(sa.cur_dict_size, max_possible_dict_size)
+	for fill_lookahead_size >= 3 {
+		if fill_lookahead_pos%cCancelCheckInterval == 0 && sa.canceled() {
+			return false
+		}
+		insert_pos := fill_lookahead_pos & sa.max_dict_size_mask
+
+		c2 := uint32(dict[insert_pos+2])
+		var h uint32
+		if sa.hash24 {
+			h = c0 | (c1 << 8) | (c2 << 16)
+		} else {
+			h = hash3_to_16(c0, c1, c2)
+		}
+		c0 = c1
+		c1 = c2
+
+		if num_threads > 1 && h%num_threads != thread_index {
+			fill_lookahead_pos++
+			fill_lookahead_size--
+			fill_dict_size++
+			continue
+		}
 
-	sa.next_match_ref = 0
+		num_matches := 0
+
+		cur_pos := sa.hash[h]
+		sa.hash[h] = fill_lookahead_pos
+
+		pLeft := &sa.nodes[insert_pos].left
+		pRight := &sa.nodes[insert_pos].right
+
+		max_match_len := LZHAM_MIN(cMaxMatchLen, fill_lookahead_size)
+		var best_match_len uint32 = 2
+
+		ins := dict[insert_pos:]
+
+		n := sa.max_probes
+		for {
+			delta_pos := fill_lookahead_pos - cur_pos
+			if n == 0 || delta_pos == 0 || delta_pos >= fill_dict_size {
+				*pLeft = 0
+				*pRight = 0
+				break
+			}
+			n--
+
+			pos := cur_pos & sa.max_dict_size_mask
+			pNode := &sa.nodes[pos]
+
+			// The initial compare match_len must be 0 because of the way we hash and truncate matches at the end of each block.
+			comp := dict[pos:]
+			match_len := match_prefix_len(comp, ins, max_match_len)
+
+			// 0 stands for an empty hash entry and a missing child, but is a real position too once the stream gets
+			// there. Only positions in h's own tree are searched, which also keeps threads from sharing nodes.
+			if match_len < 3 && sa.get_hash(pos) != h {
+				*pLeft = 0
+				*pRight = 0
+				break
+			}
+
+			if match_len > best_match_len {
+				temp_matches[num_matches] = dict_match{dist: delta_pos, len: uint16(match_len - cMinMatchLen)}
+				num_matches++
+
+				best_match_len = match_len
+
+				if match_len == max_match_len {
+					*pLeft = pNode.left
+					*pRight = pNode.right
+					break
+				}
+			} else if sa.all_matches {
+				temp_matches[num_matches] = dict_match{dist: delta_pos, len: uint16(match_len - cMinMatchLen)}
+				num_matches++
+			} else if best_match_len > 2 && best_match_len == match_len {
+				best_match_dist := temp_matches[num_matches-1].dist
+				comp_match_dist := delta_pos
+
+				best_match_slot, best_match_slot_ofs := compute_lzx_position_slot(best_match_dist)
+				comp_match_slot, comp_match_ofs := compute_lzx_position_slot(comp_match_dist)
+
+				// If both matches uses the same match slot, choose the one with the offset containing the lowest nibble as these bits separately entropy coded.
+				// This could choose a match which is further away in the absolute sense, but closer in a coding sense.
+				if comp_match_slot < best_match_slot ||
+					(comp_match_slot >= 8 && comp_match_slot == best_match_slot && (comp_match_ofs&15) < (best_match_slot_ofs&15)) {
+					temp_matches[num_matches-1].dist = delta_pos
+				} else if match_len < max_match_len && comp_match_slot <= best_match_slot {
+					// Choose the match which has lowest hamming distance in the mismatch byte for a tiny win on binary files.
+					desired_mismatch_byte := ins[match_len]
+
+					cur_mismatch_byte := dict[(insert_pos-best_match_dist+match_len)&sa.max_dict_size_mask]
+					cur_mismatch_dist := g_hamming_dist[cur_mismatch_byte^desired_mismatch_byte]
+
+					new_mismatch_byte := comp[match_len]
+					new_mismatch_dist := g_hamming_dist[new_mismatch_byte^desired_mismatch_byte]
+					if new_mismatch_dist < cur_mismatch_dist {
+						temp_matches[num_matches-1].dist = delta_pos
+					}
+				}
+			}
+
+			if match_len == max_match_len {
+				*pLeft = pNode.left
+				*pRight = pNode.right
+				break
+			}
+
+			var new_pos uint32
+			if comp[match_len] < ins[match_len] {
+				*pLeft = cur_pos
+				pLeft = &pNode.right
+				new_pos = pNode.right
+			} else {
+				*pRight = cur_pos
+				pRight = &pNode.left
+				new_pos = pNode.left
+			}
+			if new_pos == cur_pos {
+				break
+			}
+			cur_pos = new_pos
+		}
 
-	//return sa.find_all_matches(num_bytes)
+		if num_threads > 1 {
+			sa.set_thread_match_list(thread_index, fill_lookahead_pos-sa.fill_lookahead_pos, temp_matches[:num_matches])
+		} else {
+			sa.set_match_list(fill_lookahead_pos-sa.fill_lookahead_pos, temp_matches[:num_matches], sa.max_matches)
+		}
+
+		fill_lookahead_pos++
+		fill_lookahead_size--
+		fill_dict_size++
+	}
+
+	for fill_lookahead_size > 0 && thread_index == 0 {
+		insert_pos := fill_lookahead_pos & sa.max_dict_size_mask
+		sa.nodes[insert_pos].left = 0
+		sa.nodes[insert_pos].right = 0
+
+		sa.match_refs[fill_lookahead_pos-sa.fill_lookahead_pos] = -2
+
+		fill_lookahead_pos++
+		fill_lookahead_size--
+		fill_dict_size++
+	}
+
+	return true
 }
@@ -0,0 +1,505 @@
+package lzham
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"unsafe"
+)
+
+// match_finder is the interface the compressor uses to find matches. Bytes are added to the lookahead with
+// add_bytes_begin/add_bytes_end, which also finds the matches at every lookahead position, and are moved into
+// the dictionary with advance_bytes. Lookahead offsets are relative to the current lookahead position.
+type match_finder interface {
+	reset()
+
+	get_max_add_bytes() uint32
+	add_bytes_begin(num_bytes uint32, pBytes []byte) bool
+	add_bytes_end()
+	advance_bytes(num_bytes uint32)
+
+	get_lookahead_size() uint32
+	get_lookahead_pos() uint32
+	get_cur_dict_size() uint32
+	get_max_dict_size() uint32
+	get_char(lookahead_ofs int32) byte
+	get_hist_char(lookahead_ofs int32, dist uint32) byte
+
+	find_matches(lookahead_ofs uint32) []dict_match
+	match(lookahead_ofs uint32, dist uint32) uint32
+	match_len(lookahead_ofs uint32, dist uint32, max_len uint32) uint32
+	get_len2_match(lookahead_ofs uint32) uint32
+
+	is_window_reduced() bool
+	get_memory_usage() uint64
+
+	set_done(done <-chan struct{})
+}
+
+// If all_matches is true, the match finder returns all found matches with no filtering.
+// Otherwise, the finder will tend to return lists of matches with mostly unique lengths.
+// For each length, it will discard matches with worse distances (in the coding sense).
+const (
+	cFlagDeterministic = 1 << 0
+	cFlagLen2Matches   = 1 << 1
+	cFlagHash24        = 1 << 2
+	cFlagLowMemory     = 1 << 3
+)
+
+// In low memory mode the dictionary and tree start out this small and double as data arrives.
+const cLowMemoryInitialAllocSize = 1 << 16
+
+// Each window byte is expected to need about this many bytes of match list, which is what the budget reserves.
+const cMatchListBytesPerWindowByte = 2
+
+const (
+	cDigramHashSize = 4096
+)
+
+type dict_match struct {
+	dist uint32
+	len  uint16
+}
+
+func (d dict_match) get_dist() uint32 {
+	return d.dist & 0x7FFFFFFF
+}
+
+func (d dict_match) get_len() uint16 {
+	return d.len + 2
+}
+
+// The last match of each list has the high bit of its distance set.
+func (d dict_match) is_last() bool {
+	return d.dist&0x80000000 != 0
+}
+
+var g_hamming_dist [256]uint8
+
+func init() {
+	for i := range g_hamming_dist {
+		g_hamming_dist[i] = uint8(bits.OnesCount8(uint8(i)))
+	}
+}
+
+// match_prefix_len returns the length of the common prefix of a and b, up to max bytes.
+func match_prefix_len(a, b []byte, max uint32) uint32 {
+	var n uint32
+	for n+8 <= max {
+		if x := binary.LittleEndian.Uint64(a[n:]) ^ binary.LittleEndian.Uint64(b[n:]); x != 0 {
+			return n + uint32(bits.TrailingZeros64(x)>>3)
+		}
+		n += 8
+	}
+	for n < max && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// is_cheaper_match_dist reports whether a match at dist is cheaper to code than one of the same length at best_dist:
+// it's in a lower position slot, or in the same slot with a lower bottom nibble, which is entropy coded separately.
+func is_cheaper_match_dist(dist, best_dist uint32) bool {
+	slot, slot_ofs := compute_lzx_position_slot(dist)
+	best_slot, best_slot_ofs := compute_lzx_position_slot(best_dist)
+	return slot < best_slot || (slot >= 8 && slot == best_slot && (slot_ofs&15) < (best_slot_ofs&15))
+}
+
+func hash2_to_12(c0, c1 uint32) uint32 {
+	return c0 ^ (c1 << 4)
+}
+
+func hash3_to_16(c0, c1, c2 uint32) uint32 {
+	return (c0 | (c1 << 8)) ^ (c2 << 4)
+}
+
+// match_window is the sliding dictionary shared by the match finder backends: the dictionary ring buffer and its
+// mirror, the lookahead, the per-position match lists and the len2 digram chains.
+type match_window struct {
+	max_dict_size      uint32
+	max_dict_size_mask uint32
+
+	lookahead_pos  uint32
+	lookahead_size uint32
+
+	cur_dict_size uint32
+
+	dict []byte
+
+	// The bytes the lookahead overwrote in dict, oldest first. Matches can't reach them any more, but rep0 still can.
+	evicted []byte
+
+	// Number of positions currently allocated. Only less than max_dict_size in low memory mode,
+	// before the window has filled for the first time.
+	alloc_size uint32
+
+	// Requested window size, before any reduction to fit max_bytes.
+	requested_dict_size uint32
+
+	// Memory budget in bytes, 0=unlimited. Once the match lists reach max_match_entries, positions only keep their longest match.
+	max_bytes         uint64
+	max_match_entries uint32
+
+	matches    []dict_match
+	match_refs []int32
+
+	digram_hash []uint32
+	digram_next []uint32
+
+	fill_lookahead_pos  uint32
+	fill_lookahead_size uint32
+	fill_dict_size      uint32
+
+	len2_matches bool
+	low_memory   bool
+
+	next_match_ref int32
+
+	// Closed to give up finding the lookahead's matches, which fails add_bytes_begin. nil never is.
+	done <-chan struct{}
+}
+
+// The match finders look at done once every this many positions.
+const cCancelCheckInterval = 4096
+
+func (w *match_window) set_done(done <-chan struct{}) {
+	w.done = done
+}
+
+// canceled reports whether done has been closed.
+func (w *match_window) canceled() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// match_window_fixed_memory returns the bytes used by the dictionary, per-position links and hash table for a given window size.
+func match_window_fixed_memory(dict_size uint32, pos_bytes uint64, hash_size uint32) uint64 {
+	dict_bytes := uint64(dict_size) + uint64(LZHAM_MIN(dict_size, cMaxHugeMatchLen))
+	hash_bytes := uint64(hash_size) * 4
+	return dict_bytes + uint64(dict_size)*pos_bytes + hash_bytes
+}
+
+// init_window sets up the window, shrinking it if needed so that the dictionary, pos_bytes of links per position,
+// a hash table of hash_size entries and the match lists fit in max_bytes.
+func (w *match_window) init_window(max_dict_size uint32, pos_bytes uint64, hash_size uint32, flags uint32, max_bytes uint64) bool {
+	if !is_power_of_2(uint64(max_dict_size)) {
+		return false
+	}
+
+	w.len2_matches = (flags & cFlagLen2Matches) != 0
+	w.low_memory = (flags & cFlagLowMemory) != 0
+
+	w.requested_dict_size = max_dict_size
+	w.max_bytes = max_bytes
+	w.max_match_entries = ^uint32(0)
+
+	if max_bytes > 0 {
+		budget_for := func(dict_size uint32) uint64 {
+			return match_window_fixed_memory(dict_size, pos_bytes, hash_size) + uint64(dict_size)*cMatchListBytesPerWindowByte
+		}
+		for max_dict_size > 1<<cMinDictSizeLog2 && budget_for(max_dict_size) > max_bytes {
+			max_dict_size >>= 1
+		}
+		if budget_for(max_dict_size) > max_bytes {
+			return false
+		}
+
+		entry_size := uint64(unsafe.Sizeof(dict_match{}))
+		w.max_match_entries = uint32(LZHAM_MIN64((max_bytes-match_window_fixed_memory(max_dict_size, pos_bytes, hash_size))/entry_size, uint64(^uint32(0))))
+	}
+
+	w.max_dict_size = max_dict_size
+	w.max_dict_size_mask = max_dict_size - 1
+	w.cur_dict_size = 0
+	w.lookahead_size = 0
+	w.lookahead_pos = 0
+	w.evicted = w.evicted[:0]
+	w.fill_lookahead_pos = 0
+	w.fill_lookahead_size = 0
+	w.fill_dict_size = 0
+
+	w.dict = nil
+	if w.low_memory {
+		w.alloc_dict(LZHAM_MIN(max_dict_size, cLowMemoryInitialAllocSize))
+	} else {
+		w.alloc_dict(max_dict_size)
+	}
+
+	return true
+}
+
+// alloc_dict (re)allocates the dictionary to hold alloc_size positions, keeping its current contents.
+// The mirror of the start of the dictionary past its end is only needed once the window can wrap.
+func (w *match_window) alloc_dict(alloc_size uint32) {
+	dict_size := alloc_size
+	if alloc_size == w.max_dict_size {
+		dict_size += LZHAM_MIN(w.max_dict_size, cMaxHugeMatchLen)
+	}
+
+	dict := make([]byte, dict_size)
+	copy(dict, w.dict)
+	w.dict = dict
+
+	w.alloc_size = alloc_size
+}
+
+func (w *match_window) reset_window() {
+	w.cur_dict_size = 0
+	w.lookahead_size = 0
+	w.lookahead_pos = 0
+	w.fill_lookahead_pos = 0
+	w.fill_lookahead_size = 0
+	w.fill_dict_size = 0
+
+	if len(w.digram_hash) > 0 {
+		w.digram_hash[0] = 0
+		for bp := 1; bp < len(w.digram_hash); bp *= 2 {
+			copy(w.digram_hash[bp:], w.digram_hash[:bp])
+		}
+	}
+}
+
+// is_window_reduced reports whether the memory budget forced a window smaller than the one requested.
+func (w *match_window) is_window_reduced() bool {
+	return w.max_dict_size < w.requested_dict_size
+}
+
+func (w *match_window) get_window_memory_usage() uint64 {
+	return uint64(len(w.dict)+cap(w.evicted)) +
+		uint64(len(w.digram_hash)+cap(w.digram_next))*4 +
+		uint64(cap(w.matches))*uint64(unsafe.Sizeof(dict_match{})) +
+		uint64(cap(w.match_refs))*4
+}
+
+// get_max_add_bytes returns the largest number of bytes add_bytes_begin will accept without wrapping the dictionary.
+func (w *match_window) get_max_add_bytes() uint32 {
+	add_pos := w.lookahead_pos & w.max_dict_size_mask
+	return w.max_dict_size - add_pos
+}
+
+// begin_add copies num_bytes into the lookahead and prepares the match lists for them.
+func (w *match_window) begin_add(num_bytes uint32, pBytes []byte) bool {
+	if num_bytes > w.get_max_add_bytes() || w.lookahead_size != 0 {
+		return false
+	}
+
+	var add_pos uint32 = w.lookahead_pos & w.max_dict_size_mask
+
+	// Until the window fills for the first time every byte seen so far is below add_pos, so growing is just a copy.
+	if add_pos+num_bytes > w.alloc_size {
+		new_size := w.alloc_size
+		for new_size < add_pos+num_bytes {
+			new_size <<= 1
+		}
+		w.alloc_dict(LZHAM_MIN(new_size, w.max_dict_size))
+	}
+
+	w.evicted = w.evicted[:0]
+	if w.cur_dict_size+num_bytes > w.max_dict_size {
+		w.evicted = append(w.evicted, w.dict[add_pos:add_pos+num_bytes]...)
+	}
+
+	n := copy(w.dict[add_pos:], pBytes[:num_bytes])
+	if uint32(n) != num_bytes {
+		panic("copy failed")
+	}
+
+	var dict_bytes_to_mirror uint32 = LZHAM_MIN(cMaxHugeMatchLen, w.max_dict_size)
+	if add_pos < dict_bytes_to_mirror && w.alloc_size == w.max_dict_size {
+		copy(w.dict[w.max_dict_size:], w.dict[0:dict_bytes_to_mirror])
+	}
+
+	w.lookahead_size = num_bytes
+
+	// Bytes older than this are overwritten by the new lookahead, so they're no longer part of the window.
+	var max_possible_dict_size uint32 = w.max_dict_size - num_bytes
+	w.cur_dict_size = LZHAM_MIN(w.cur_dict_size, max_possible_dict_size)
+
+	// Match lists grow with the matches actually found rather than reserving max_probes entries per byte.
+	w.matches = w.matches[:0]
+
+	if uint32(cap(w.match_refs)) < num_bytes {
+		w.match_refs = make([]int32, num_bytes)
+	}
+	w.match_refs = w.match_refs[:num_bytes]
+	for i := range w.match_refs {
+		w.match_refs[i] = -1
+	}
+
+	w.fill_lookahead_pos = w.lookahead_pos
+	w.fill_lookahead_size = num_bytes
+	w.fill_dict_size = w.cur_dict_size
+
+	w.next_match_ref = 0
+
+	if w.len2_matches {
+		w.find_len2_matches()
+	}
+
+	return true
+}
+
+// advance_bytes slides the window forward, moving num_bytes from the lookahead into the dictionary.
+func (w *match_window) advance_bytes(num_bytes uint32) {
+	if num_bytes > w.lookahead_size {
+		panic("advance_bytes past the end of the lookahead")
+	}
+
+	w.lookahead_pos += num_bytes
+	w.lookahead_size -= num_bytes
+
+	w.cur_dict_size += num_bytes
+	if w.cur_dict_size > w.max_dict_size {
+		w.cur_dict_size = w.max_dict_size
+	}
+}
+
+func (w *match_window) get_lookahead_size() uint32 {
+	return w.lookahead_size
+}
+
+func (w *match_window) get_lookahead_pos() uint32 {
+	return w.lookahead_pos
+}
+
+func (w *match_window) get_cur_dict_size() uint32 {
+	return w.cur_dict_size
+}
+
+func (w *match_window) get_max_dict_size() uint32 {
+	return w.max_dict_size
+}
+
+func (w *match_window) get_char(lookahead_ofs int32) byte {
+	return w.dict[(w.lookahead_pos+uint32(lookahead_ofs))&w.max_dict_size_mask]
+}
+
+// get_hist_char returns the byte dist bytes before lookahead offset lookahead_ofs, as the decompressor sees it. That
+// may be one the lookahead has overwritten, which get_char would return the new byte for.
+func (w *match_window) get_hist_char(lookahead_ofs int32, dist uint32) byte {
+	// evicted[i] was at lookahead position i-max_dict_size.
+	if i := uint32(lookahead_ofs) + w.max_dict_size - dist; i < uint32(len(w.evicted)) {
+		return w.evicted[i]
+	}
+	return w.get_char(lookahead_ofs - int32(dist))
+}
+
+// find_matches returns the match list recorded for the given lookahead offset, or nil if there were no matches.
+// The list is ordered by increasing length and terminated by an entry whose is_last() is true.
+func (w *match_window) find_matches(lookahead_ofs uint32) []dict_match {
+	fill_ofs := w.lookahead_pos + lookahead_ofs - w.fill_lookahead_pos
+	if fill_ofs >= uint32(len(w.match_refs)) {
+		return nil
+	}
+	match_ref := w.match_refs[fill_ofs]
+	if match_ref < 0 {
+		return nil
+	}
+	return w.matches[match_ref:]
+}
+
+// match returns the length of the match at the given lookahead offset and distance, capped at cMaxMatchLen.
+func (w *match_window) match(lookahead_ofs uint32, dist uint32) uint32 {
+	return w.match_len(lookahead_ofs, dist, cMaxMatchLen)
+}
+
+func (w *match_window) match_len(lookahead_ofs uint32, dist uint32, max_len uint32) uint32 {
+	if dist == 0 || dist > w.cur_dict_size+lookahead_ofs {
+		return 0
+	}
+
+	comp_pos := (w.lookahead_pos + lookahead_ofs - dist) & w.max_dict_size_mask
+	lookahead_pos := (w.lookahead_pos + lookahead_ofs) & w.max_dict_size_mask
+
+	max_match_len := LZHAM_MIN(max_len, w.lookahead_size-lookahead_ofs)
+
+	return match_prefix_len(w.dict[comp_pos:], w.dict[lookahead_pos:], max_match_len)
+}
+
+// get_len2_match returns the distance of a nearby two byte match at the given lookahead offset, or 0 if there isn't one.
+func (w *match_window) get_len2_match(lookahead_ofs uint32) uint32 {
+	cur_pos := w.lookahead_pos + lookahead_ofs
+	fill_ofs := cur_pos - w.fill_lookahead_pos
+	if !w.len2_matches || fill_ofs+1 >= w.fill_lookahead_size {
+		return 0
+	}
+
+	next_match_pos := w.digram_next[fill_ofs]
+
+	match_dist := cur_pos - next_match_pos
+	if match_dist == 0 || match_dist > cMaxLen2MatchDist || match_dist > w.cur_dict_size+lookahead_ofs {
+		return 0
+	}
+
+	cur := cur_pos & w.max_dict_size_mask
+	prev := next_match_pos & w.max_dict_size_mask
+	if w.dict[cur] == w.dict[prev] && w.dict[cur+1] == w.dict[prev+1] {
+		return match_dist
+	}
+	return 0
+}
+
+func (w *match_window) find_len2_matches() {
+	if len(w.digram_hash) == 0 {
+		w.digram_hash = make([]uint32, cDigramHashSize)
+	}
+	if uint32(len(w.digram_next)) < w.lookahead_size {
+		w.digram_next = make([]uint32, w.lookahead_size)
+	}
+
+	lookahead_dict_pos := w.lookahead_pos & w.max_dict_size_mask
+	for lookahead_ofs := uint32(0); lookahead_ofs+1 < w.lookahead_size; lookahead_ofs++ {
+		c0 := uint32(w.dict[lookahead_dict_pos])
+		c1 := uint32(w.dict[lookahead_dict_pos+1])
+		lookahead_dict_pos++
+
+		h := hash2_to_12(c0, c1) & (cDigramHashSize - 1)
+		w.digram_next[lookahead_ofs] = w.digram_hash[h]
+		w.digram_hash[h] = w.lookahead_pos + lookahead_ofs
+	}
+
+	w.digram_next[w.lookahead_size-1] = 0
+}
+
+// set_match_list records the match list for the lookahead position fill_ofs. matches must be in increasing length order,
+// only the last max_matches of them are kept.
+func (w *match_window) set_match_list(fill_ofs uint32, matches []dict_match, max_matches uint32) {
+	num_matches_to_write := LZHAM_MIN(uint32(len(matches)), max_matches)
+	if uint32(len(w.matches))+num_matches_to_write > w.max_match_entries {
+		num_matches_to_write = LZHAM_MIN(1, w.max_match_entries-uint32(len(w.matches)))
+	}
+
+	if num_matches_to_write == 0 {
+		w.match_refs[fill_ofs] = -2
+		return
+	}
+
+	matches[len(matches)-1].dist |= 0x80000000
+
+	match_ref_ofs := w.next_match_ref
+	w.next_match_ref += int32(num_matches_to_write)
+
+	w.grow_matches(num_matches_to_write)
+	w.matches = append(w.matches, matches[uint32(len(matches))-num_matches_to_write:]...)
+
+	w.match_refs[fill_ofs] = match_ref_ofs
+}
+
+// grow_matches makes room for n more match list entries without growing past max_match_entries.
+func (w *match_window) grow_matches(n uint32) {
+	need := uint32(len(w.matches)) + n
+	if need <= uint32(cap(w.matches)) {
+		return
+	}
+
+	new_cap := LZHAM_MAX(need, uint32(cap(w.matches))*2)
+	new_cap = LZHAM_MAX(new_cap, 1024)
+	new_cap = LZHAM_MIN(new_cap, w.max_match_entries)
+
+	matches := make([]dict_match, len(w.matches), new_cap)
+	copy(matches, w.matches)
+	w.matches = matches
+}
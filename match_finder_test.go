@@ -0,0 +1,445 @@
+package lzham
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// gen_test_data returns compressible data: words from a small vocabulary mixed with runs of noise,
+// so that matches exist at every distance up to (and beyond) the dictionary size.
+func gen_test_data(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+
+	vocab := make([][]byte, 64)
+	for i := range vocab {
+		w := make([]byte, 3+rng.Intn(12))
+		for j := range w {
+			w[j] = byte('a' + rng.Intn(26))
+		}
+		vocab[i] = w
+	}
+
+	buf := make([]byte, 0, size+64)
+	for len(buf) < size {
+		if rng.Intn(16) == 0 {
+			for n := rng.Intn(32); n > 0; n-- {
+				buf = append(buf, byte(rng.Intn(256)))
+			}
+			continue
+		}
+		buf = append(buf, vocab[rng.Intn(len(vocab))]...)
+		buf = append(buf, ' ')
+	}
+	return buf[:size]
+}
+
+// new_test_match_finder creates a binary tree ("bt"), hash chain ("hc") or suffix array ("sa") match finder
+// whose lookahead starts at start_pos.
+func new_test_match_finder(backend string, dict_size_log2, flags uint32, max_bytes uint64, start_pos uint32) (match_finder, bool) {
+	switch backend {
+	case "sa":
+		var sx suffix_array_accelerator
+		if !sx.init(1<<dict_size_log2, ^uint32(0), 32, flags, max_bytes) {
+			return nil, false
+		}
+		sx.lookahead_pos = start_pos
+		return &sx, true
+	case "hc":
+		var hc hash_chain_accelerator
+		if !hc.init(1<<dict_size_log2, ^uint32(0), 16, flags, max_bytes) {
+			return nil, false
+		}
+		hc.lookahead_pos = start_pos
+		return &hc, true
+	default:
+		var sa search_accelerator
+		if !sa.init(nil, 0, 1<<dict_size_log2, ^uint32(0), false, 32, flags, max_bytes) {
+			return nil, false
+		}
+		sa.lookahead_pos = start_pos
+		return &sa, true
+	}
+}
+
+func Test_match_finder_sliding_window(t *testing.T) {
+	type args struct {
+		backend        string
+		dict_size_log2 uint32
+		flags          uint32
+		num_dicts      int
+		start_pos      uint32
+		max_bytes      uint64
+	}
+	tests := []struct {
+		name string
+		args args
+	}{
+		{name: "hash16", args: args{backend: "bt", dict_size_log2: 15, flags: cFlagLen2Matches, num_dicts: 40}},
+		{name: "hash24", args: args{backend: "bt", dict_size_log2: 16, flags: cFlagLen2Matches | cFlagHash24, num_dicts: 24}},
+		{name: "position wraparound", args: args{backend: "bt", dict_size_log2: 15, flags: cFlagLen2Matches, num_dicts: 40, start_pos: 0xFFFF8000}},
+		{name: "low memory", args: args{backend: "bt", dict_size_log2: 18, flags: cFlagLen2Matches | cFlagLowMemory, num_dicts: 6}},
+		{name: "memory budget", args: args{backend: "bt", dict_size_log2: 20, flags: cFlagLen2Matches | cFlagHash24 | cFlagLowMemory, num_dicts: 2, max_bytes: 1 << 20}},
+		{name: "hash chain", args: args{backend: "hc", dict_size_log2: 15, num_dicts: 40}},
+		{name: "hash chain wraparound", args: args{backend: "hc", dict_size_log2: 15, flags: cFlagLen2Matches, num_dicts: 40, start_pos: 0xFFFF8000}},
+		{name: "hash chain low memory", args: args{backend: "hc", dict_size_log2: 18, flags: cFlagLowMemory, num_dicts: 6}},
+		{name: "suffix array", args: args{backend: "sa", dict_size_log2: 15, flags: cFlagLen2Matches, num_dicts: 12}},
+		{name: "suffix array wraparound", args: args{backend: "sa", dict_size_log2: 15, num_dicts: 12, start_pos: 0xFFFF8000}},
+		{name: "suffix array low memory", args: args{backend: "sa", dict_size_log2: 17, flags: cFlagLowMemory, num_dicts: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			num_dicts := tt.args.num_dicts
+			if (testing.Short() || race_enabled) && num_dicts > 4 {
+				num_dicts = 4
+			}
+			data := gen_test_data(1, (1<<tt.args.dict_size_log2)*num_dicts)
+
+			sa, ok := new_test_match_finder(tt.args.backend, tt.args.dict_size_log2, tt.args.flags, tt.args.max_bytes, tt.args.start_pos)
+			if !ok {
+				t.Fatal("init failed")
+			}
+
+			dict_size := sa.get_max_dict_size()
+			block_size := dict_size / 8
+
+			total_matches := 0
+			late_far_matches := 0
+
+			for ofs := 0; ofs < len(data); {
+				num_bytes := minimum(uint32(len(data)-ofs), block_size)
+				num_bytes = minimum(num_bytes, sa.get_max_add_bytes())
+
+				if !sa.add_bytes_begin(num_bytes, data[ofs:]) {
+					t.Fatalf("add_bytes_begin failed at %d", ofs)
+				}
+				sa.add_bytes_end()
+
+				want_dict_size := minimum(uint32(ofs), dict_size-num_bytes)
+				if sa.get_cur_dict_size() != want_dict_size {
+					t.Fatalf("cur_dict_size = %d at %d, want %d", sa.get_cur_dict_size(), ofs, want_dict_size)
+				}
+
+				for i := uint32(0); i < num_bytes; i++ {
+					pos := ofs + int(i)
+
+					var prev_len uint16
+					for _, m := range sa.find_matches(i) {
+						dist := m.get_dist()
+						if dist == 0 || dist > sa.get_cur_dict_size()+i {
+							t.Fatalf("pos %d: dist %d outside the window of %d", pos, dist, sa.get_cur_dict_size()+i)
+						}
+						if m.get_len() <= prev_len {
+							t.Fatalf("pos %d: match lengths not increasing", pos)
+						}
+						prev_len = m.get_len()
+
+						for k := 0; k < int(m.get_len()); k++ {
+							if data[pos+k] != data[pos-int(dist)+k] {
+								t.Fatalf("pos %d: bad match len %d dist %d", pos, m.get_len(), dist)
+							}
+						}
+						if got := sa.match(i, dist); got < uint32(m.get_len()) {
+							t.Fatalf("pos %d: match() = %d, want at least %d", pos, got, m.get_len())
+						}
+
+						total_matches++
+						if ofs >= len(data)/2 && dist > dict_size/2 {
+							late_far_matches++
+						}
+						if m.is_last() {
+							break
+						}
+					}
+
+					if dist := sa.get_len2_match(i); dist != 0 {
+						if data[pos] != data[pos-int(dist)] || data[pos+1] != data[pos-int(dist)+1] {
+							t.Fatalf("pos %d: bad len2 match dist %d", pos, dist)
+						}
+					}
+				}
+
+				sa.advance_bytes(num_bytes)
+				ofs += int(num_bytes)
+			}
+
+			if total_matches == 0 || late_far_matches == 0 {
+				t.Errorf("found %d matches, %d far matches late in the stream", total_matches, late_far_matches)
+			}
+			if sa.get_lookahead_pos() != tt.args.start_pos+uint32(len(data)) {
+				t.Errorf("lookahead_pos = %d, want %d", sa.get_lookahead_pos(), tt.args.start_pos+uint32(len(data)))
+			}
+		})
+	}
+}
+
+func Test_match_finder_helper_threads(t *testing.T) {
+	tests := []struct {
+		name               string
+		dict_size_log2     uint32
+		flags              uint32
+		max_helper_threads uint32
+		start_pos          uint32
+	}{
+		{name: "hash16 1 helper", dict_size_log2: 15, flags: cFlagLen2Matches, max_helper_threads: 1},
+		{name: "hash16 3 helpers", dict_size_log2: 15, flags: cFlagLen2Matches, max_helper_threads: 3},
+		{name: "hash24 7 helpers", dict_size_log2: 16, flags: cFlagLen2Matches | cFlagHash24, max_helper_threads: 7},
+		{name: "position wraparound", dict_size_log2: 15, max_helper_threads: 3, start_pos: 0xFFFF8000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := gen_test_data(2, 6<<tt.dict_size_log2)
+
+			var single, threaded search_accelerator
+			var pool task_pool
+			pool.init(tt.max_helper_threads)
+			defer pool.deinit()
+			if !single.init(nil, 0, 1<<tt.dict_size_log2, ^uint32(0), false, 32, tt.flags, 0) ||
+				!threaded.init(&pool, tt.max_helper_threads, 1<<tt.dict_size_log2, ^uint32(0), false, 32, tt.flags, 0) {
+				t.Fatal("init failed")
+			}
+			if threaded.max_helper_threads != tt.max_helper_threads {
+				t.Fatalf("%d helper threads, want %d", threaded.max_helper_threads, tt.max_helper_threads)
+			}
+			single.lookahead_pos = tt.start_pos
+			threaded.lookahead_pos = tt.start_pos
+
+			// Every position must get the same match list whichever thread searched its tree.
+			block_size := uint32(1) << tt.dict_size_log2 / 8
+			for ofs := 0; ofs < len(data); {
+				num_bytes := minimum(uint32(len(data)-ofs), block_size)
+				if !single.add_bytes_begin(num_bytes, data[ofs:]) || !threaded.add_bytes_begin(num_bytes, data[ofs:]) {
+					t.Fatalf("add_bytes_begin failed at %d", ofs)
+				}
+				single.add_bytes_end()
+				threaded.add_bytes_end()
+
+				for i := uint32(0); i < num_bytes; i++ {
+					want, got := single.find_matches(i), threaded.find_matches(i)
+					for k := 0; ; k++ {
+						if k == len(want) || k == len(got) {
+							if len(want) != len(got) {
+								t.Fatalf("pos %d: %d matches, want %d", ofs+int(i), len(got), len(want))
+							}
+							break
+						}
+						if got[k] != want[k] {
+							t.Fatalf("pos %d: match %d is %+v, want %+v", ofs+int(i), k, got[k], want[k])
+						}
+						if want[k].is_last() {
+							break
+						}
+					}
+				}
+
+				single.advance_bytes(num_bytes)
+				threaded.advance_bytes(num_bytes)
+				ofs += int(num_bytes)
+			}
+		})
+	}
+}
+
+func Test_match_finder_memory_budget(t *testing.T) {
+	type args struct {
+		backend        string
+		dict_size_log2 uint32
+		flags          uint32
+		max_bytes      uint64
+	}
+	tests := []struct {
+		name       string
+		args       args
+		want_log2  uint32
+		want_error bool
+	}{
+		{name: "unlimited", args: args{backend: "bt", dict_size_log2: 18, flags: cFlagLowMemory}, want_log2: 18},
+		{name: "fits", args: args{backend: "bt", dict_size_log2: 18, flags: cFlagLowMemory, max_bytes: 4 << 20}, want_log2: 18},
+		{name: "reduced", args: args{backend: "bt", dict_size_log2: 24, flags: cFlagLowMemory | cFlagHash24, max_bytes: 8 << 20}, want_log2: 19},
+		{name: "too small", args: args{backend: "bt", dict_size_log2: 20, flags: cFlagLowMemory, max_bytes: 64 << 10}, want_error: true},
+		{name: "hash chain reduced", args: args{backend: "hc", dict_size_log2: 24, flags: cFlagLowMemory, max_bytes: 8 << 20}, want_log2: 20},
+		{name: "suffix array reduced", args: args{backend: "sa", dict_size_log2: 24, flags: cFlagLowMemory, max_bytes: 8 << 20}, want_log2: 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa, ok := new_test_match_finder(tt.args.backend, tt.args.dict_size_log2, tt.args.flags, tt.args.max_bytes, 0)
+			if ok == tt.want_error {
+				t.Fatalf("init() = %v, want %v", ok, !tt.want_error)
+			}
+			if !ok {
+				return
+			}
+			if got := sa.get_max_dict_size(); got != 1<<tt.want_log2 {
+				t.Errorf("window = %d, want %d", got, 1<<tt.want_log2)
+			}
+			if got, want := sa.is_window_reduced(), tt.want_log2 != tt.args.dict_size_log2; got != want {
+				t.Errorf("is_window_reduced() = %v, want %v", got, want)
+			}
+
+			// Memory follows the data seen: a small input shouldn't allocate a full window's worth of tree.
+			small := gen_test_data(2, 4096)
+			sa.add_bytes_begin(uint32(len(small)), small)
+			sa.add_bytes_end()
+			sa.advance_bytes(uint32(len(small)))
+			if used, full := sa.get_memory_usage(), uint64(sa.get_max_dict_size())*5; used >= full {
+				t.Errorf("memory usage %d after %d bytes, want less than %d", used, len(small), full)
+			}
+
+			data := gen_test_data(3, int(sa.get_max_dict_size())*3)
+			for ofs := 0; ofs < len(data); {
+				num_bytes := minimum(uint32(len(data)-ofs), sa.get_max_dict_size()/8)
+				num_bytes = minimum(num_bytes, sa.get_max_add_bytes())
+				sa.add_bytes_begin(num_bytes, data[ofs:])
+				sa.add_bytes_end()
+				sa.advance_bytes(num_bytes)
+				ofs += int(num_bytes)
+			}
+			if tt.args.max_bytes > 0 && sa.get_memory_usage() > tt.args.max_bytes {
+				t.Errorf("memory usage %d exceeds the budget of %d", sa.get_memory_usage(), tt.args.max_bytes)
+			}
+		})
+	}
+}
+
+// Test_match_finder_ratio compares the suffix array finder with the binary tree on the same data: its longest match
+// must never be shorter, and a greedy parse using its matches must not need more tokens.
+func Test_match_finder_ratio(t *testing.T) {
+	const dict_size_log2 = 16
+	data := gen_test_data(3, 6<<dict_size_log2)
+
+	bt, _ := new_test_match_finder("bt", dict_size_log2, 0, 0, 0)
+	sa, _ := new_test_match_finder("sa", dict_size_log2, 0, 0, 0)
+
+	longest := func(mf match_finder, i uint32) (uint32, uint32) {
+		var len, dist uint32
+		for _, m := range mf.find_matches(i) {
+			len, dist = uint32(m.get_len()), m.get_dist()
+			if m.is_last() {
+				break
+			}
+		}
+		return len, dist
+	}
+
+	var bt_total, sa_total, bt_tokens, sa_tokens uint64
+	var bt_skip, sa_skip uint32
+	block_size := uint32(1<<dict_size_log2) / 8
+
+	for ofs := 0; ofs < len(data); {
+		num_bytes := minimum(uint32(len(data)-ofs), block_size)
+		num_bytes = minimum(num_bytes, bt.get_max_add_bytes())
+
+		for _, mf := range []match_finder{bt, sa} {
+			if !mf.add_bytes_begin(num_bytes, data[ofs:]) {
+				t.Fatalf("add_bytes_begin failed at %d", ofs)
+			}
+			mf.add_bytes_end()
+		}
+
+		for i := uint32(0); i < num_bytes; i++ {
+			bt_len, _ := longest(bt, i)
+			sa_len, sa_dist := longest(sa, i)
+			if sa_len < bt_len {
+				t.Fatalf("pos %d: suffix array longest match %d, binary tree found %d", ofs+int(i), sa_len, bt_len)
+			}
+			if sa_len > 0 && sa.match(i, sa_dist) < sa_len {
+				t.Fatalf("pos %d: bad suffix array match len %d dist %d", ofs+int(i), sa_len, sa_dist)
+			}
+			bt_total += uint64(bt_len)
+			sa_total += uint64(sa_len)
+
+			// Greedy parse: take the longest match if there is one, else a literal.
+			if bt_skip == 0 {
+				bt_tokens++
+				bt_skip = LZHAM_MAX(bt_len, 1)
+			}
+			bt_skip--
+			if sa_skip == 0 {
+				sa_tokens++
+				sa_skip = LZHAM_MAX(sa_len, 1)
+			}
+			sa_skip--
+		}
+
+		bt.advance_bytes(num_bytes)
+		sa.advance_bytes(num_bytes)
+		ofs += int(num_bytes)
+	}
+
+	t.Logf("total longest match bytes: binary tree %d, suffix array %d", bt_total, sa_total)
+	t.Logf("greedy parse tokens: binary tree %d, suffix array %d (%.2f%%)", bt_tokens, sa_tokens, 100*float64(sa_tokens)/float64(bt_tokens))
+	if sa_tokens > bt_tokens {
+		t.Errorf("suffix array parse needs %d tokens, binary tree %d", sa_tokens, bt_tokens)
+	}
+}
+
+func Test_suffix_array_optimal_parse(t *testing.T) {
+	// Both parses run on a single goroutine and only their sizes are compared, which the race detector just slows down.
+	if race_enabled {
+		t.Skip("single threaded ratio comparison")
+	}
+	size := 1 << 20
+	if testing.Short() {
+		size = 512 << 10
+	}
+
+	tests := []struct {
+		name   string
+		params LZHAM_compress_params
+		data   []byte
+	}{
+		{name: "default text", params: LZHAM_compress_params{dict_size_log2: 18, level: LZHAM_COMP_LEVEL_DEFAULT}, data: gen_test_data(4, size)},
+		{name: "default records", params: LZHAM_compress_params{dict_size_log2: 18, level: LZHAM_COMP_LEVEL_DEFAULT}, data: gen_test_records(5, size)},
+		{name: "uber records", params: LZHAM_compress_params{dict_size_log2: 18, level: LZHAM_COMP_LEVEL_UBER}, data: gen_test_records(6, size)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compress := func(params LZHAM_compress_params) []byte {
+				comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(tt.data)), &params))
+				comp_len, _, status := LZHAM_lib_compress_memory(&params, comp, tt.data)
+				if status != LZHAM_COMP_STATUS_SUCCESS {
+					t.Fatalf("status %d", status)
+				}
+				comp = comp[:comp_len]
+
+				decomp_params := LZHAM_decompress_params{dict_size_log2: params.dict_size_log2}
+				got := make([]byte, len(tt.data))
+				n, _, dstatus := LZHAM_lib_decompress_memory(&decomp_params, got, comp)
+				if dstatus != LZHAM_DECOMP_STATUS_SUCCESS || !bytes.Equal(got[:n], tt.data) {
+					t.Fatalf("stream didn't round trip, status %d", dstatus)
+				}
+				return comp
+			}
+
+			bt := compress(tt.params)
+			sa_params := tt.params
+			sa_params.compress_flags |= uint32(LZHAM_COMP_FLAG_USE_SUFFIX_ARRAY_MATCH_FINDER)
+			sa := compress(sa_params)
+
+			t.Logf("binary tree %d bytes, suffix array %d bytes", len(bt), len(sa))
+			if len(sa) > len(bt) {
+				t.Errorf("suffix array stream %d bytes, larger than the binary tree's %d", len(sa), len(bt))
+			}
+		})
+	}
+}
+
+func Benchmark_match_finder(b *testing.B) {
+	data := gen_test_data(4, 4<<20)
+	for _, backend := range []string{"hc", "bt", "sa"} {
+		b.Run(backend, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				mf, _ := new_test_match_finder(backend, 22, cFlagLen2Matches, 0, 0)
+				for ofs := 0; ofs < len(data); {
+					num_bytes := minimum(uint32(len(data)-ofs), mf.get_max_dict_size()/8)
+					num_bytes = minimum(num_bytes, mf.get_max_add_bytes())
+					mf.add_bytes_begin(num_bytes, data[ofs:])
+					mf.add_bytes_end()
+					mf.advance_bytes(num_bytes)
+					ofs += int(num_bytes)
+				}
+			}
+		})
+	}
+}
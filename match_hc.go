@@ -0,0 +1,187 @@
+package lzham
+
+const (
+	cHashChainHashBits = 16
+
+	// Positions more than this far behind the parser (inside a long match it skipped) aren't linked, apart from
+	// the last cHashChainGapInserts of them. Like deflate's max_insert_length, this loses little ratio.
+	cHashChainMaxInsertGap = 32
+	cHashChainGapInserts   = 8
+)
+
+// hash_chain_accelerator is the hash chain match finder used by the fastest levels. It keeps a single link per
+// dictionary position instead of a tree node and only follows a few links per position, trading ratio for speed.
+//
+// Unlike the other backends it searches on demand: adding bytes only copies them, positions are linked into the
+// chains as the parser moves past them, and find_matches only searches the positions the parser asks about.
+// A greedy parse skips most positions inside matches, so most of the lookahead is never searched at all.
+type hash_chain_accelerator struct {
+	match_window
+
+	hash []uint32
+	prev []uint32
+
+	max_probes  uint32
+	max_matches uint32
+
+	// Next position to link into the chains.
+	insert_pos uint32
+
+	found [cMatchAccelMaxSupportedProbes]dict_match
+}
+
+func hash3_to_chain(c uint32) uint32 {
+	return ((c & 0xFFFFFF) * 2654435761) >> (32 - cHashChainHashBits)
+}
+
+func (hc *hash_chain_accelerator) init(max_dict_size, max_matches, max_probes, flags uint32, max_bytes uint64) bool {
+	if max_probes == 0 {
+		max_probes = 1
+	}
+
+	hc.max_probes = LZHAM_MIN(cMatchAccelMaxSupportedProbes, max_probes)
+	hc.max_matches = LZHAM_MIN(hc.max_probes, max_matches)
+
+	if !hc.init_window(max_dict_size, 4, 1<<cHashChainHashBits, flags, max_bytes) {
+		return false
+	}
+
+	hc.hash = make([]uint32, 1<<cHashChainHashBits)
+	hc.prev = make([]uint32, hc.alloc_size)
+	hc.insert_pos = 0
+
+	return true
+}
+
+func (hc *hash_chain_accelerator) get_memory_usage() uint64 {
+	return hc.get_window_memory_usage() + uint64(len(hc.prev)+len(hc.hash))*4
+}
+
+func (hc *hash_chain_accelerator) reset() {
+	hc.reset_window()
+	hc.insert_pos = 0
+
+	hc.hash[0] = 0
+	for bp := 1; bp < len(hc.hash); bp *= 2 {
+		copy(hc.hash[bp:], hc.hash[:bp])
+	}
+}
+
+func (hc *hash_chain_accelerator) add_bytes_begin(num_bytes uint32, pBytes []byte) bool {
+	if !hc.begin_add(num_bytes, pBytes) {
+		return false
+	}
+
+	if uint32(len(hc.prev)) < hc.alloc_size {
+		prev := make([]uint32, hc.alloc_size)
+		copy(prev, hc.prev)
+		hc.prev = prev
+	}
+
+	hc.insert_pos = hc.lookahead_pos
+
+	return true
+}
+
+func (hc *hash_chain_accelerator) add_bytes_end() {
+}
+
+// advance_bytes links any positions the parser skipped before moving them into the dictionary.
+func (hc *hash_chain_accelerator) advance_bytes(num_bytes uint32) {
+	hc.insert_up_to(hc.lookahead_pos + num_bytes)
+	hc.match_window.advance_bytes(num_bytes)
+}
+
+// insert_up_to links the lookahead positions before end_pos into the hash chains. The last two positions of the
+// lookahead can't be hashed and link to themselves, which ends any chain that reaches them.
+func (hc *hash_chain_accelerator) insert_up_to(end_pos uint32) {
+	lookahead_end := hc.lookahead_pos + hc.lookahead_size
+	dict := hc.dict
+
+	if int32(end_pos-hc.insert_pos) > cHashChainMaxInsertGap {
+		hc.insert_pos = end_pos - cHashChainGapInserts
+	}
+
+	for ; int32(end_pos-hc.insert_pos) > 0; hc.insert_pos++ {
+		insert_pos := hc.insert_pos & hc.max_dict_size_mask
+		if lookahead_end-hc.insert_pos < 3 {
+			hc.prev[insert_pos] = hc.insert_pos
+			continue
+		}
+
+		h := hash3_to_chain(uint32(dict[insert_pos]) | uint32(dict[insert_pos+1])<<8 | uint32(dict[insert_pos+2])<<16)
+		hc.prev[insert_pos] = hc.hash[h]
+		hc.hash[h] = hc.insert_pos
+	}
+}
+
+func (hc *hash_chain_accelerator) find_matches(lookahead_ofs uint32) []dict_match {
+	if lookahead_ofs+3 > hc.lookahead_size {
+		return nil
+	}
+
+	cur := hc.lookahead_pos + lookahead_ofs
+	hc.insert_up_to(cur)
+
+	dict := hc.dict
+	insert_pos := cur & hc.max_dict_size_mask
+	ins := dict[insert_pos:]
+
+	h := hash3_to_chain(uint32(ins[0]) | uint32(ins[1])<<8 | uint32(ins[2])<<16)
+	cur_pos := hc.hash[h]
+
+	// Positions already linked past cur (when the parser looks back) are newer than it, skip them. Skipping them
+	// uses up the same probe budget as the search, so no position walks more than max_probes links.
+	probes := hc.max_probes
+	for ; cur_pos-cur < hc.insert_pos-cur && probes > 0; probes-- {
+		cur_pos = hc.prev[cur_pos&hc.max_dict_size_mask]
+	}
+
+	dict_size := hc.cur_dict_size + lookahead_ofs
+	max_match_len := LZHAM_MIN(cMaxMatchLen, hc.lookahead_size-lookahead_ofs)
+	var best_match_len uint32 = 2
+	num_matches := 0
+
+	for ; probes > 0; probes-- {
+		delta_pos := cur - cur_pos
+		if delta_pos == 0 || delta_pos >= dict_size {
+			break
+		}
+
+		pos := cur_pos & hc.max_dict_size_mask
+		comp := dict[pos:]
+
+		// Only a candidate that agrees at best_match_len can be longer than the best match so far.
+		if comp[best_match_len] == ins[best_match_len] {
+			match_len := match_prefix_len(comp, ins, max_match_len)
+			if match_len > best_match_len {
+				hc.found[num_matches] = dict_match{dist: delta_pos, len: uint16(match_len - cMinMatchLen)}
+				num_matches++
+
+				best_match_len = match_len
+				if match_len == max_match_len {
+					break
+				}
+			}
+		}
+
+		// Links must lead strictly backwards, anything else is a slot that has since been reused.
+		next_pos := hc.prev[pos]
+		if cur-next_pos <= delta_pos {
+			break
+		}
+		cur_pos = next_pos
+	}
+
+	if cur == hc.insert_pos {
+		hc.insert_up_to(cur + 1)
+	}
+
+	if num_matches == 0 {
+		return nil
+	}
+
+	matches := hc.found[num_matches-int(LZHAM_MIN(uint32(num_matches), hc.max_matches)) : num_matches]
+	matches[len(matches)-1].dist |= 0x80000000
+	return matches
+}
@@ -0,0 +1,373 @@
+package lzham
+
+// Bytes of scratch per window position: a copy of the window plus eight int32 arrays indexed by suffix rank.
+const cSuffixArrayBytesPerPos = 1 + 8*4
+
+// suffix_array_accelerator is the offline match finder. For every block it builds a suffix array and LCP array
+// over the whole window, so the longest previous match at each lookahead position is always found, at the cost
+// of rebuilding both structures per block. Hash chains over the same text find the short close matches. It
+// produces the same match lists as the other backends.
+type suffix_array_accelerator struct {
+	match_window
+
+	text     []byte
+	suffixes []int32
+	ranks    []int32
+	lcp      []int32
+	psv      []int32 // Nearest rank above with an earlier position, -1 if none.
+	nsv      []int32 // Nearest rank below with an earlier position, -1 if none.
+	psv_lcp  []int32
+	nsv_lcp  []int32
+	scratch  []int32 // Once the arrays are built, the previous text position with the same hash, -1 if none.
+
+	chain_heads []int32
+
+	max_probes  uint32
+	max_matches uint32
+}
+
+func (sx *suffix_array_accelerator) init(max_dict_size, max_matches, max_probes, flags uint32, max_bytes uint64) bool {
+	if max_probes == 0 {
+		max_probes = 1
+	}
+
+	sx.max_probes = LZHAM_MIN(cMatchAccelMaxSupportedProbes, max_probes)
+	sx.max_matches = LZHAM_MIN(sx.max_probes, max_matches)
+
+	return sx.init_window(max_dict_size, cSuffixArrayBytesPerPos, cHashSize16, flags, max_bytes)
+}
+
+func (sx *suffix_array_accelerator) get_memory_usage() uint64 {
+	return sx.get_window_memory_usage() + uint64(cap(sx.text)) +
+		uint64(cap(sx.suffixes)+cap(sx.ranks)+cap(sx.lcp)+cap(sx.psv)+cap(sx.nsv)+cap(sx.psv_lcp)+cap(sx.nsv_lcp)+cap(sx.scratch)+cap(sx.chain_heads))*4
+}
+
+func (sx *suffix_array_accelerator) reset() {
+	sx.reset_window()
+}
+
+func (sx *suffix_array_accelerator) add_bytes_begin(num_bytes uint32, pBytes []byte) bool {
+	if !sx.begin_add(num_bytes, pBytes) {
+		return false
+	}
+
+	return sx.find_all_matches()
+}
+
+func (sx *suffix_array_accelerator) add_bytes_end() {
+}
+
+// alloc_scratch sizes the scratch arrays for a window of n bytes.
+func (sx *suffix_array_accelerator) alloc_scratch(n uint32) {
+	if uint32(cap(sx.text)) >= n {
+		sx.text = sx.text[:n]
+		return
+	}
+
+	size := LZHAM_MIN(LZHAM_MAX(n, uint32(cap(sx.text))*2), sx.max_dict_size)
+	sx.text = make([]byte, n, size)
+	for _, p := range []*[]int32{&sx.suffixes, &sx.ranks, &sx.lcp, &sx.psv, &sx.nsv, &sx.psv_lcp, &sx.nsv_lcp, &sx.scratch} {
+		*p = make([]int32, LZHAM_MAX(size, 256))
+	}
+}
+
+// build_suffix_array sorts the suffixes of text by prefix doubling with radix sorting, leaving the suffix array
+// in suffixes and its inverse in ranks. tmp and cnt must hold at least max(len(text), 256) entries.
+func build_suffix_array(text []byte, suffixes, ranks, tmp, cnt []int32) {
+	n := int32(len(text))
+	if n == 0 {
+		return
+	}
+
+	for i := range cnt[:256] {
+		cnt[i] = 0
+	}
+	for _, c := range text {
+		cnt[c]++
+	}
+	var sum int32
+	for i := range cnt[:256] {
+		sum, cnt[i] = sum+cnt[i], sum
+	}
+	for i, c := range text {
+		suffixes[cnt[c]] = int32(i)
+		cnt[c]++
+		ranks[i] = int32(c)
+	}
+
+	num_classes := int32(256)
+	for k := int32(1); ; k <<= 1 {
+		// Order by the second half first: suffixes without one come first, then the rest in the current order.
+		p := 0
+		for i := LZHAM_MAX_INT32(n-k, 0); i < n; i++ {
+			tmp[p] = i
+			p++
+		}
+		for _, s := range suffixes[:n] {
+			if s >= k {
+				tmp[p] = s - k
+				p++
+			}
+		}
+
+		// Then stable sort by the first half.
+		for i := range cnt[:num_classes] {
+			cnt[i] = 0
+		}
+		for _, r := range ranks[:n] {
+			cnt[r]++
+		}
+		sum = 0
+		for i := range cnt[:num_classes] {
+			sum += cnt[i]
+			cnt[i] = sum
+		}
+		for i := n - 1; i >= 0; i-- {
+			s := tmp[i]
+			cnt[ranks[s]]--
+			suffixes[cnt[ranks[s]]] = s
+		}
+
+		second := func(s int32) int32 {
+			if s+k < n {
+				return ranks[s+k]
+			}
+			return -1
+		}
+
+		tmp[suffixes[0]] = 0
+		num_classes = 1
+		for i := int32(1); i < n; i++ {
+			a, b := suffixes[i-1], suffixes[i]
+			if ranks[a] != ranks[b] || second(a) != second(b) {
+				num_classes++
+			}
+			tmp[b] = num_classes - 1
+		}
+		copy(ranks[:n], tmp[:n])
+
+		if num_classes == n {
+			break
+		}
+	}
+}
+
+// build_lcp fills lcp[r] with the length of the common prefix of the suffixes ranked r-1 and r (Kasai et al.).
+func build_lcp(text []byte, suffixes, ranks, lcp []int32) {
+	n := int32(len(text))
+	var h int32
+	for i := int32(0); i < n; i++ {
+		r := ranks[i]
+		if r == 0 {
+			lcp[0] = 0
+			h = 0
+			continue
+		}
+		j := suffixes[r-1]
+		for i+h < n && j+h < n && text[i+h] == text[j+h] {
+			h++
+		}
+		lcp[r] = h
+		if h > 0 {
+			h--
+		}
+	}
+}
+
+// build_nearest_earlier finds, for every rank, the nearest ranks above and below whose suffixes start earlier in
+// the text, along with the common prefix length with each. One of the two is the longest previous match.
+func (sx *suffix_array_accelerator) build_nearest_earlier(n int32) {
+	suffixes, lcp, stack := sx.suffixes, sx.lcp, sx.scratch
+
+	top := 0
+	for r := int32(0); r < n; r++ {
+		cur := lcp[r]
+		s := suffixes[r]
+		sx.nsv[r] = -1
+
+		for top > 0 && suffixes[stack[top-1]] > s {
+			t := stack[top-1]
+			sx.nsv[t] = r
+			sx.nsv_lcp[t] = cur
+			if sx.psv_lcp[t] < cur {
+				cur = sx.psv_lcp[t]
+			}
+			top--
+		}
+
+		if top > 0 {
+			sx.psv[r] = stack[top-1]
+			sx.psv_lcp[r] = cur
+		} else {
+			sx.psv[r] = -1
+			sx.psv_lcp[r] = 0
+		}
+
+		stack[top] = r
+		top++
+	}
+}
+
+// scan_earlier collects matches for the suffix at text position p, walking the suffix array from rank q
+// (whose common prefix with p is l) in direction step. Only suffixes that start before p and are closer than
+// every match found so far are kept, so the result is ordered by decreasing length and decreasing distance.
+func (sx *suffix_array_accelerator) scan_earlier(p, q, l, step int32, max_match_len uint32, out []dict_match) int {
+	n := int32(len(sx.text))
+	num := 0
+	last_dist := ^uint32(0)
+
+	for probes := sx.max_probes; probes > 0 && q >= 0 && q < n; probes-- {
+		if uint32(l) <= cMinMatchLen {
+			break
+		}
+
+		if j := sx.suffixes[q]; j < p {
+			if dist := uint32(p - j); dist < last_dist {
+				out[num] = dict_match{dist: dist, len: uint16(LZHAM_MIN(uint32(l), max_match_len) - cMinMatchLen)}
+				num++
+				last_dist = dist
+			}
+		}
+
+		if step < 0 {
+			l = LZHAM_MIN_INT32(l, sx.lcp[q])
+			q--
+		} else {
+			q++
+			if q < n {
+				l = LZHAM_MIN_INT32(l, sx.lcp[q])
+			}
+		}
+	}
+
+	return num
+}
+
+// build_hash_chains links every text position to the previous one with the same hash, in scratch.
+func (sx *suffix_array_accelerator) build_hash_chains(n int32) {
+	if len(sx.chain_heads) == 0 {
+		sx.chain_heads = make([]int32, cHashSize16)
+	}
+	for i := range sx.chain_heads {
+		sx.chain_heads[i] = -1
+	}
+
+	for i := int32(0); i+2 < n; i++ {
+		h := hash3_to_16(uint32(sx.text[i]), uint32(sx.text[i+1]), uint32(sx.text[i+2]))
+		sx.scratch[i] = sx.chain_heads[h]
+		sx.chain_heads[h] = i
+	}
+}
+
+// scan_chain collects matches for the suffix at text position p from the nearest max_probes earlier positions with
+// its hash, keeping only those longer than every closer one. Like scan_earlier, the result is ordered by decreasing
+// length and decreasing distance.
+func (sx *suffix_array_accelerator) scan_chain(p int32, max_match_len uint32, out []dict_match) int {
+	num := 0
+	best_len := uint32(cMinMatchLen)
+
+	j := sx.scratch[p]
+	for probes := sx.max_probes; probes > 0 && j >= 0 && best_len < max_match_len; probes-- {
+		if l := match_prefix_len(sx.text[j:], sx.text[p:], max_match_len); l > best_len {
+			out[num] = dict_match{dist: uint32(p - j), len: uint16(l - cMinMatchLen)}
+			num++
+			best_len = l
+		}
+		j = sx.scratch[j]
+	}
+
+	for i := 0; i < num/2; i++ {
+		out[i], out[num-1-i] = out[num-1-i], out[i]
+	}
+	return num
+}
+
+// merge_matches merges two match lists ordered by decreasing length into out, keeping only matches closer than
+// every longer one, and the closest of each length.
+func merge_matches(a, b, out []dict_match) int {
+	num := 0
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		var c dict_match
+		if j >= len(b) || (i < len(a) && a[i].len >= b[j].len) {
+			c = a[i]
+			i++
+		} else {
+			c = b[j]
+			j++
+		}
+
+		if num > 0 {
+			if c.len == out[num-1].len {
+				if is_cheaper_match_dist(c.dist, out[num-1].dist) {
+					out[num-1] = c
+				}
+				continue
+			}
+			if c.dist >= out[num-1].dist {
+				continue
+			}
+		}
+		out[num] = c
+		num++
+	}
+	return num
+}
+
+// find_all_matches records the match list of every lookahead position. It returns false if canceled part way.
+func (sx *suffix_array_accelerator) find_all_matches() bool {
+	var cands [cMatchAccelMaxSupportedProbes * 3]dict_match
+	var desc, temp_matches [cMatchAccelMaxSupportedProbes * 3]dict_match
+
+	// The text is the whole window followed by the lookahead, laid out linearly.
+	n := sx.fill_dict_size + sx.fill_lookahead_size
+	sx.alloc_scratch(n)
+
+	start := (sx.fill_lookahead_pos - sx.fill_dict_size) & sx.max_dict_size_mask
+	copied := copy(sx.text, sx.dict[start:LZHAM_MIN(start+n, sx.max_dict_size)])
+	copy(sx.text[copied:], sx.dict)
+
+	build_suffix_array(sx.text, sx.suffixes, sx.ranks, sx.lcp, sx.scratch)
+	build_lcp(sx.text, sx.suffixes, sx.ranks, sx.lcp)
+	sx.build_nearest_earlier(int32(n))
+	sx.build_hash_chains(int32(n))
+
+	for fill_ofs := uint32(0); fill_ofs < sx.fill_lookahead_size; fill_ofs++ {
+		if fill_ofs%cCancelCheckInterval == 0 && sx.canceled() {
+			return false
+		}
+		remaining := sx.fill_lookahead_size - fill_ofs
+		if remaining < 3 {
+			sx.match_refs[fill_ofs] = -2
+			continue
+		}
+		max_match_len := LZHAM_MIN(cMaxMatchLen, remaining)
+
+		p := int32(sx.fill_dict_size + fill_ofs)
+		r := sx.ranks[p]
+
+		num_up := 0
+		if q := sx.psv[r]; q >= 0 {
+			num_up = sx.scan_earlier(p, q, sx.psv_lcp[r], -1, max_match_len, cands[:])
+		}
+		num_cands := num_up
+		if q := sx.nsv[r]; q >= 0 {
+			num_cands += sx.scan_earlier(p, q, sx.nsv_lcp[r], 1, max_match_len, cands[num_up:])
+		}
+
+		// Suffixes next to p's in rank are in no particular order of distance, so the nearest few earlier positions with
+		// its hash are searched too, for the short close matches the suffix array can take too many probes to reach.
+		num_chain := sx.scan_chain(p, max_match_len, cands[num_cands:])
+
+		// Merge all three by decreasing length, keeping only matches closer than every longer one.
+		num_desc := merge_matches(cands[:num_up], cands[num_up:num_cands], temp_matches[:])
+		num_desc = merge_matches(temp_matches[:num_desc], cands[num_cands:num_cands+num_chain], desc[:])
+
+		for i := 0; i < num_desc; i++ {
+			temp_matches[i] = desc[num_desc-1-i]
+		}
+
+		sx.set_match_list(fill_ofs, temp_matches[:num_desc], sx.max_matches)
+	}
+
+	return true
+}
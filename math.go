@@ -30,6 +30,27 @@ func LZHAM_MAX(a, b uint32) uint32 {
 	return b
 }
 
+func LZHAM_MIN64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func is_power_of_2(x uint64) bool {
 	return x != 0 && (x&(x-1)) == 0
 }
+
+func LZHAM_MIN_INT32(a, b int32) int32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func LZHAM_MAX_INT32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package lzham
+
+const race_enabled = false
@@ -0,0 +1,226 @@
+package lzham
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// DefaultDictSizeLog2 is the dictionary DefaultCompressOptions and DefaultDecompressOptions use, 1MB.
+const DefaultDictSizeLog2 = 20
+
+// Exported names for the types of the options' fields, whose values are the LZHAM_COMP_LEVEL_*,
+// LZHAM_COMP_FLAG_*, LZHAM_DECOMP_FLAG_* and LZHAM_*_TABLE_UPDATE_RATE constants.
+type (
+	CompressLevel   = lzham_compress_level
+	CompressFlags   = lzham_compress_flags
+	DecompressFlags = lzham_decompress_flags
+	TableUpdateRate = lzham_table_update_rate
+)
+
+// The table update overrides are limited to the range the preset table update rates span.
+const (
+	cMinTableMaxUpdateInterval      = 4
+	cMaxTableMaxUpdateInterval      = 2048
+	cMinTableUpdateIntervalSlowRate = 32
+	cMaxTableUpdateIntervalSlowRate = 384
+)
+
+var (
+	ErrInvalidLevel                         = errors.New("invalid level")
+	ErrInvalidFastBytes                     = errors.New("invalid fast_bytes")
+	ErrInvalidExtremeParsingMaxBestArrivals = errors.New("invalid extreme_parsing_max_best_arrivals")
+	ErrInvalidTableUpdateRate               = errors.New("invalid table_update_rate")
+	ErrInvalidTableMaxUpdateInterval        = errors.New("invalid table_max_update_interval")
+	ErrInvalidTableUpdateIntervalSlowRate   = errors.New("invalid table_update_interval_slow_rate")
+	ErrInvalidMaxHelperThreads              = errors.New("invalid max_helper_threads")
+	ErrInvalidSeedBytes                     = errors.New("invalid seed bytes")
+)
+
+// CompressOptions are the settings of a compressor. Fields left 0 pick the library's own setting, except
+// DictSizeLog2 and Level, so start from DefaultCompressOptions.
+type CompressOptions struct {
+	// DictSizeLog2 is log2 of the dictionary size, in [LZHAM_MIN_DICT_SIZE_LOG2, LZHAM_MAX_DICT_SIZE_LOG2]. The
+	// decompressor must use the same.
+	DictSizeLog2 uint32
+	Level        CompressLevel
+	Flags        CompressFlags
+
+	// TableUpdateRate trades ratio for decompression speed, in [1, LZHAM_FASTEST_TABLE_UPDATE_RATE] with higher
+	// decoding faster. The decompressor must use the same.
+	TableUpdateRate TableUpdateRate
+	// TableMaxUpdateInterval and TableUpdateIntervalSlowRate override TableUpdateRate if either is set, see
+	// LZHAM_compress_params. The interval is in [4, 2048] symbols and the slow rate in [32, 384]. The decompressor
+	// must use the same.
+	TableMaxUpdateInterval      uint32
+	TableUpdateIntervalSlowRate uint32
+
+	// MaxHelperThreads is how many goroutines may help the caller's, up to LZHAM_MAX_HELPER_THREADS, or -1 for
+	// one per extra CPU. It only changes the speed, the output is the same for any number.
+	MaxHelperThreads int32
+
+	// SeedBytes primes the dictionary for delta compression, at most its size. The decompressor needs the same bytes.
+	SeedBytes []byte
+
+	// ExtremeParsingMaxBestArrivals is in [LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MIN, LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MAX].
+	ExtremeParsingMaxBestArrivals uint32
+	// FastBytes is in [LZHAM_MIN_FAST_BYTES, LZHAM_MAX_FAST_BYTES].
+	FastBytes uint32
+
+	// MatchFinderMaxBytes, if set, is the most memory in bytes the match finder may use. Its window shrinks to fit,
+	// which can cost ratio.
+	MatchFinderMaxBytes uint64
+	// DecompressionComplexityWeight is how much compressed size, in 1/256ths of a bit, the parser may give up per unit
+	// of decoding work it saves, to make the stream decode faster. The fastest two levels and
+	// LZHAM_COMP_FLAG_TRADEOFF_DECOMPRESSION_RATE_FOR_COMP_RATIO ignore it.
+	DecompressionComplexityWeight uint32
+
+	// Logger is where the compressor logs to. It's silent if nil.
+	Logger *slog.Logger
+	// Progress, if set, is called after each block is compressed and once the stream is finished, on the goroutine
+	// compressing, which waits for it.
+	Progress func(Progress)
+}
+
+// DefaultCompressOptions returns the settings a compressor should start from: a 1MB dictionary at
+// LZHAM_COMP_LEVEL_DEFAULT, parsing on every CPU.
+func DefaultCompressOptions() CompressOptions {
+	return CompressOptions{
+		DictSizeLog2:     DefaultDictSizeLog2,
+		Level:            LZHAM_COMP_LEVEL_DEFAULT,
+		MaxHelperThreads: -1,
+	}
+}
+
+// Validate returns an error naming the first field out of range.
+func (o *CompressOptions) Validate() error {
+	if err := validate_dict_size_log2(o.DictSizeLog2); err != nil {
+		return err
+	}
+	if o.Level >= LZHAM_TOTAL_COMP_LEVELS {
+		return fmt.Errorf("%w: %d, must be below %d", ErrInvalidLevel, o.Level, LZHAM_TOTAL_COMP_LEVELS)
+	}
+	if err := validate_table_update_settings(o.TableUpdateRate, o.TableMaxUpdateInterval, o.TableUpdateIntervalSlowRate); err != nil {
+		return err
+	}
+	if o.MaxHelperThreads < -1 || o.MaxHelperThreads > LZHAM_MAX_HELPER_THREADS {
+		return fmt.Errorf("%w: %d, must be -1 or in [0, %d]", ErrInvalidMaxHelperThreads, o.MaxHelperThreads, LZHAM_MAX_HELPER_THREADS)
+	}
+	if err := validate_seed_bytes(o.SeedBytes, o.DictSizeLog2); err != nil {
+		return err
+	}
+	if o.ExtremeParsingMaxBestArrivals != 0 && (o.ExtremeParsingMaxBestArrivals < LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MIN || o.ExtremeParsingMaxBestArrivals > LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MAX) {
+		return fmt.Errorf("%w: %d, must be 0 or in [%d, %d]", ErrInvalidExtremeParsingMaxBestArrivals, o.ExtremeParsingMaxBestArrivals,
+			LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MIN, LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MAX)
+	}
+	if o.FastBytes != 0 && (o.FastBytes < LZHAM_MIN_FAST_BYTES || o.FastBytes > LZHAM_MAX_FAST_BYTES) {
+		return fmt.Errorf("%w: %d, must be 0 or in [%d, %d]", ErrInvalidFastBytes, o.FastBytes, LZHAM_MIN_FAST_BYTES, LZHAM_MAX_FAST_BYTES)
+	}
+	return nil
+}
+
+// params returns the LZHAM_compress_params of validated options.
+func (o *CompressOptions) params() LZHAM_compress_params {
+	// An empty seed still marks the zlib header as needing seed bytes, so leave it out.
+	var seed_bytes []byte
+	if len(o.SeedBytes) > 0 {
+		seed_bytes = o.SeedBytes
+	}
+
+	return LZHAM_compress_params{
+		dict_size_log2:                    o.DictSizeLog2,
+		level:                             o.Level,
+		table_update_rate:                 uint32(o.TableUpdateRate),
+		max_helper_threads:                o.MaxHelperThreads,
+		compress_flags:                    uint32(o.Flags),
+		num_seed_bytes:                    uint32(len(o.SeedBytes)),
+		pSeed_bytes:                       seed_bytes,
+		table_max_update_interval:         o.TableMaxUpdateInterval,
+		table_update_interval_slow_rate:   o.TableUpdateIntervalSlowRate,
+		extreme_parsing_max_best_arrivals: o.ExtremeParsingMaxBestArrivals,
+		fast_bytes:                        o.FastBytes,
+		match_finder_max_bytes:            o.MatchFinderMaxBytes,
+		decompression_complexity_weight:   o.DecompressionComplexityWeight,
+		logger:                            o.Logger,
+		progress:                          o.Progress,
+	}
+}
+
+// DecompressOptions are the settings of a decompressor, which must match the compressor's. Start from
+// DefaultDecompressOptions.
+type DecompressOptions struct {
+	DictSizeLog2 uint32
+	Flags        DecompressFlags
+
+	TableUpdateRate             TableUpdateRate
+	TableMaxUpdateInterval      uint32
+	TableUpdateIntervalSlowRate uint32
+
+	SeedBytes []byte
+
+	// Logger is where the decompressor logs to. It's silent if nil.
+	Logger *slog.Logger
+	// Progress, if set, is called after each block of data is decompressed and once the end of the stream has been
+	// checked, on the goroutine decompressing, which waits for it.
+	Progress func(Progress)
+}
+
+// DefaultDecompressOptions returns the settings that decompress streams made with DefaultCompressOptions.
+func DefaultDecompressOptions() DecompressOptions {
+	return DecompressOptions{
+		DictSizeLog2: DefaultDictSizeLog2,
+	}
+}
+
+// Validate returns an error naming the first field out of range.
+func (o *DecompressOptions) Validate() error {
+	if err := validate_dict_size_log2(o.DictSizeLog2); err != nil {
+		return err
+	}
+	if err := validate_table_update_settings(o.TableUpdateRate, o.TableMaxUpdateInterval, o.TableUpdateIntervalSlowRate); err != nil {
+		return err
+	}
+	return validate_seed_bytes(o.SeedBytes, o.DictSizeLog2)
+}
+
+// params returns the LZHAM_decompress_params of validated options.
+func (o *DecompressOptions) params() LZHAM_decompress_params {
+	return LZHAM_decompress_params{
+		dict_size_log2:                  o.DictSizeLog2,
+		table_update_rate:               uint32(o.TableUpdateRate),
+		decompress_flags:                uint32(o.Flags),
+		num_seed_bytes:                  uint32(len(o.SeedBytes)),
+		pSeed_bytes:                     o.SeedBytes,
+		table_max_update_interval:       o.TableMaxUpdateInterval,
+		table_update_interval_slow_rate: o.TableUpdateIntervalSlowRate,
+		logger:                          o.Logger,
+		progress:                        o.Progress,
+	}
+}
+
+func validate_dict_size_log2(dict_size_log2 uint32) error {
+	if dict_size_log2 < LZHAM_MIN_DICT_SIZE_LOG2 || dict_size_log2 > LZHAM_MAX_DICT_SIZE_LOG2 {
+		return fmt.Errorf("%w: %d, must be in [%d, %d]", ErrInvalidDictSizeLog2, dict_size_log2, LZHAM_MIN_DICT_SIZE_LOG2, LZHAM_MAX_DICT_SIZE_LOG2)
+	}
+	return nil
+}
+
+func validate_table_update_settings(rate TableUpdateRate, max_update_interval, slow_rate uint32) error {
+	if rate > LZHAM_FASTEST_TABLE_UPDATE_RATE {
+		return fmt.Errorf("%w: %d, must be 0 or in [%d, %d]", ErrInvalidTableUpdateRate, rate, LZHAM_INSANELY_SLOW_TABLE_UPDATE_RATE, LZHAM_FASTEST_TABLE_UPDATE_RATE)
+	}
+	if max_update_interval != 0 && (max_update_interval < cMinTableMaxUpdateInterval || max_update_interval > cMaxTableMaxUpdateInterval) {
+		return fmt.Errorf("%w: %d, must be 0 or in [%d, %d]", ErrInvalidTableMaxUpdateInterval, max_update_interval, cMinTableMaxUpdateInterval, cMaxTableMaxUpdateInterval)
+	}
+	if slow_rate != 0 && (slow_rate < cMinTableUpdateIntervalSlowRate || slow_rate > cMaxTableUpdateIntervalSlowRate) {
+		return fmt.Errorf("%w: %d, must be 0 or in [%d, %d]", ErrInvalidTableUpdateIntervalSlowRate, slow_rate, cMinTableUpdateIntervalSlowRate, cMaxTableUpdateIntervalSlowRate)
+	}
+	return nil
+}
+
+func validate_seed_bytes(seed_bytes []byte, dict_size_log2 uint32) error {
+	if uint64(len(seed_bytes)) > uint64(1)<<dict_size_log2 {
+		return fmt.Errorf("%w: %d bytes, more than the %d byte dictionary", ErrInvalidSeedBytes, len(seed_bytes), uint64(1)<<dict_size_log2)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package lzham
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestCompressOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *CompressOptions)
+		want   error
+	}{
+		{name: "default", modify: func(o *CompressOptions) {}},
+		{name: "smallest dict", modify: func(o *CompressOptions) { o.DictSizeLog2 = LZHAM_MIN_DICT_SIZE_LOG2 }},
+		{name: "largest dict", modify: func(o *CompressOptions) { o.DictSizeLog2 = LZHAM_MAX_DICT_SIZE_LOG2 }},
+		{name: "dict too small", modify: func(o *CompressOptions) { o.DictSizeLog2 = LZHAM_MIN_DICT_SIZE_LOG2 - 1 }, want: ErrInvalidDictSizeLog2},
+		{name: "dict too large", modify: func(o *CompressOptions) { o.DictSizeLog2 = LZHAM_MAX_DICT_SIZE_LOG2 + 1 }, want: ErrInvalidDictSizeLog2},
+		{name: "uber", modify: func(o *CompressOptions) { o.Level = LZHAM_COMP_LEVEL_UBER }},
+		{name: "bad level", modify: func(o *CompressOptions) { o.Level = LZHAM_TOTAL_COMP_LEVELS }, want: ErrInvalidLevel},
+		{name: "fastest table updates", modify: func(o *CompressOptions) { o.TableUpdateRate = LZHAM_FASTEST_TABLE_UPDATE_RATE }},
+		{name: "bad table update rate", modify: func(o *CompressOptions) { o.TableUpdateRate = LZHAM_FASTEST_TABLE_UPDATE_RATE + 1 }, want: ErrInvalidTableUpdateRate},
+		{name: "table update overrides", modify: func(o *CompressOptions) { o.TableMaxUpdateInterval = 2048; o.TableUpdateIntervalSlowRate = 32 }},
+		{name: "table update interval too short", modify: func(o *CompressOptions) { o.TableMaxUpdateInterval = 3 }, want: ErrInvalidTableMaxUpdateInterval},
+		{name: "table update interval too long", modify: func(o *CompressOptions) { o.TableMaxUpdateInterval = 2049 }, want: ErrInvalidTableMaxUpdateInterval},
+		{name: "table update slow rate too low", modify: func(o *CompressOptions) { o.TableUpdateIntervalSlowRate = 31 }, want: ErrInvalidTableUpdateIntervalSlowRate},
+		{name: "table update slow rate too high", modify: func(o *CompressOptions) { o.TableUpdateIntervalSlowRate = 385 }, want: ErrInvalidTableUpdateIntervalSlowRate},
+		{name: "most helper threads", modify: func(o *CompressOptions) { o.MaxHelperThreads = LZHAM_MAX_HELPER_THREADS }},
+		{name: "bad helper threads", modify: func(o *CompressOptions) { o.MaxHelperThreads = -2 }, want: ErrInvalidMaxHelperThreads},
+		{name: "dict of seed bytes", modify: func(o *CompressOptions) { o.DictSizeLog2 = 15; o.SeedBytes = make([]byte, 1<<15) }},
+		{name: "too many seed bytes", modify: func(o *CompressOptions) { o.DictSizeLog2 = 15; o.SeedBytes = make([]byte, 1<<15+1) }, want: ErrInvalidSeedBytes},
+		{name: "arrivals", modify: func(o *CompressOptions) {
+			o.ExtremeParsingMaxBestArrivals = LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MAX
+		}},
+		{name: "too few arrivals", modify: func(o *CompressOptions) { o.ExtremeParsingMaxBestArrivals = 1 }, want: ErrInvalidExtremeParsingMaxBestArrivals},
+		{name: "too many arrivals", modify: func(o *CompressOptions) {
+			o.ExtremeParsingMaxBestArrivals = LZHAM_EXTREME_PARSING_MAX_BEST_ARRIVALS_MAX + 1
+		}, want: ErrInvalidExtremeParsingMaxBestArrivals},
+		{name: "fast bytes", modify: func(o *CompressOptions) { o.FastBytes = LZHAM_MIN_FAST_BYTES }},
+		{name: "too few fast bytes", modify: func(o *CompressOptions) { o.FastBytes = LZHAM_MIN_FAST_BYTES - 1 }, want: ErrInvalidFastBytes},
+		{name: "too many fast bytes", modify: func(o *CompressOptions) { o.FastBytes = LZHAM_MAX_FAST_BYTES + 1 }, want: ErrInvalidFastBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultCompressOptions()
+			tt.modify(&o)
+			if err := o.Validate(); !errors.Is(err, tt.want) {
+				t.Errorf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(o *DecompressOptions)
+		want   error
+	}{
+		{name: "default", modify: func(o *DecompressOptions) {}},
+		{name: "dict too large", modify: func(o *DecompressOptions) { o.DictSizeLog2 = LZHAM_MAX_DICT_SIZE_LOG2 + 1 }, want: ErrInvalidDictSizeLog2},
+		{name: "bad table update rate", modify: func(o *DecompressOptions) { o.TableUpdateRate = 255 }, want: ErrInvalidTableUpdateRate},
+		{name: "table update interval too long", modify: func(o *DecompressOptions) { o.TableMaxUpdateInterval = 4096 }, want: ErrInvalidTableMaxUpdateInterval},
+		{name: "table update slow rate too low", modify: func(o *DecompressOptions) { o.TableUpdateIntervalSlowRate = 16 }, want: ErrInvalidTableUpdateIntervalSlowRate},
+		{name: "too many seed bytes", modify: func(o *DecompressOptions) { o.DictSizeLog2 = 15; o.SeedBytes = make([]byte, 1<<15+1) }, want: ErrInvalidSeedBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := DefaultDecompressOptions()
+			tt.modify(&o)
+			if err := o.Validate(); !errors.Is(err, tt.want) {
+				t.Errorf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_options_params(t *testing.T) {
+	data := gen_test_data(50, 100<<10)
+	seed := gen_test_data(51, 10<<10)
+
+	comp_opts := DefaultCompressOptions()
+	comp_opts.Flags = LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM
+	comp_opts.TableUpdateRate = LZHAM_SLOW_TABLE_UPDATE_RATE
+	comp_opts.SeedBytes = seed
+	comp_params := comp_opts.params()
+
+	comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &comp_params))
+	comp_len, _, status := LZHAM_lib_compress_memory(&comp_params, comp, data)
+	if status != LZHAM_COMP_STATUS_SUCCESS {
+		t.Fatalf("compress status %d", status)
+	}
+
+	decomp_opts := DefaultDecompressOptions()
+	decomp_opts.Flags = LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM
+	decomp_opts.TableUpdateRate = LZHAM_SLOW_TABLE_UPDATE_RATE
+	decomp_opts.SeedBytes = seed
+	decomp_params := decomp_opts.params()
+
+	got := make([]byte, len(data))
+	n, _, dstatus := LZHAM_lib_decompress_memory(&decomp_params, got, comp[:comp_len])
+	if dstatus != LZHAM_DECOMP_STATUS_SUCCESS || !bytes.Equal(got[:n], data) {
+		t.Fatalf("decompress status %d, %d of %d bytes", dstatus, n, len(data))
+	}
+}
+
+func Test_options_Logger(t *testing.T) {
+	data := gen_test_log(110, 100<<10)
+
+	var log_buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log_buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	tests := []struct {
+		name      string
+		logger    *slog.Logger
+		want_logs []string
+	}{
+		{name: "silent", logger: nil},
+		{name: "logged", logger: logger, want_logs: []string{"level=WARN msg=\"match finder memory budget limits the window\"", "level=DEBUG msg=\"bad block\""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log_buf.Reset()
+
+			// A memory budget too small for the whole window is warned about.
+			opts := CompressOptions{DictSizeLog2: 20, MatchFinderMaxBytes: 1 << 20, Logger: tt.logger}
+			comp := compress_test_writer(t, &opts, data)
+
+			comp[len(comp)/2] ^= 0x10
+			if _, err := DecompressContext(context.Background(), comp, &DecompressOptions{DictSizeLog2: 20, Logger: tt.logger}); err == nil {
+				t.Fatal("decompressed a corrupt stream")
+			}
+
+			for _, want := range tt.want_logs {
+				if !strings.Contains(log_buf.String(), want) {
+					t.Errorf("log %q doesn't have %q", log_buf.String(), want)
+				}
+			}
+			if tt.want_logs == nil && log_buf.Len() > 0 {
+				t.Errorf("logged %q without a logger", log_buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package lzham
+
+// prefix_coding.cpp
+
+const (
+	cMaxExpectedHuffCodeSize = 16
+	cMaxEverHuffCodeSize     = 34
+)
+
+// limit_max_code_size lengthens the shortest codes and shortens the longest until no code is longer than
+// max_code_size, using the technique from LHArc. Symbols keep their order by code size.
+func limit_max_code_size(num_syms uint32, code_sizes []uint8, max_code_size uint32) bool {
+	if num_syms == 0 || num_syms > cHuffmanMaxSupportedSyms || max_code_size < 1 || max_code_size > cMaxEverHuffCodeSize {
+		return false
+	}
+
+	var num_codes [cMaxEverHuffCodeSize + 1]uint32
+	should_limit := false
+
+	for i := uint32(0); i < num_syms; i++ {
+		c := uint32(code_sizes[i])
+		num_codes[c]++
+		if c > max_code_size {
+			should_limit = true
+		}
+	}
+
+	if !should_limit {
+		return true
+	}
+
+	var next_sorted_ofs [cMaxEverHuffCodeSize + 1]uint32
+	var ofs uint32
+	for i := 1; i <= cMaxEverHuffCodeSize; i++ {
+		next_sorted_ofs[i] = ofs
+		ofs += num_codes[i]
+	}
+
+	if ofs < 2 || ofs > cHuffmanMaxSupportedSyms {
+		return true
+	}
+	if ofs > (1 << max_code_size) {
+		return false
+	}
+
+	for i := max_code_size + 1; i <= cMaxEverHuffCodeSize; i++ {
+		num_codes[max_code_size] += num_codes[i]
+	}
+
+	var total uint32
+	for i := max_code_size; i > 0; i-- {
+		total += num_codes[i] << (max_code_size - i)
+	}
+
+	for total != (1 << max_code_size) {
+		num_codes[max_code_size]--
+
+		i := max_code_size - 1
+		for ; i > 0; i-- {
+			if num_codes[i] == 0 {
+				continue
+			}
+			num_codes[i]--
+			num_codes[i+1] += 2
+			break
+		}
+		if i == 0 {
+			return false
+		}
+
+		total--
+	}
+
+	var new_code_sizes [cHuffmanMaxSupportedSyms]uint8
+	p := 0
+	for i := uint32(1); i <= max_code_size; i++ {
+		for n := num_codes[i]; n > 0; n-- {
+			new_code_sizes[p] = uint8(i)
+			p++
+		}
+	}
+
+	for i := uint32(0); i < num_syms; i++ {
+		if c := code_sizes[i]; c != 0 {
+			ofs := next_sorted_ofs[c]
+			next_sorted_ofs[c] = ofs + 1
+			code_sizes[i] = new_code_sizes[ofs]
+		}
+	}
+
+	return true
+}
+
+// generate_codes assigns canonical codes from code sizes.
+func generate_codes(num_syms uint32, code_sizes []uint8, codes []uint16) bool {
+	var num_codes [cMaxExpectedHuffCodeSize + 1]uint32
+	for i := uint32(0); i < num_syms; i++ {
+		c := code_sizes[i]
+		if c > cMaxExpectedHuffCodeSize {
+			return false
+		}
+		num_codes[c]++
+	}
+
+	var next_code [cMaxExpectedHuffCodeSize + 1]uint32
+	var code uint32
+	for i := 1; i <= cMaxExpectedHuffCodeSize; i++ {
+		next_code[i] = code
+		code = (code + num_codes[i]) << 1
+	}
+
+	if code != (1 << (cMaxExpectedHuffCodeSize + 1)) {
+		var t uint32
+		for i := 1; i <= cMaxExpectedHuffCodeSize; i++ {
+			t += num_codes[i]
+			if t > 1 {
+				return false
+			}
+		}
+	}
+
+	for i := uint32(0); i < num_syms; i++ {
+		c := code_sizes[i]
+		codes[i] = uint16(next_code[c])
+		next_code[c]++
+	}
+
+	return true
+}
+
+// Codes up to this long are decoded with a single table lookup, longer ones by searching the code ranges per size.
+const cMaxTableBits = 11
+
+// decoder_tables decode canonical codes MSB first from 16 bits of lookahead.
+type decoder_tables struct {
+	num_syms   uint32
+	table_bits uint32
+
+	// (sym << 16) | code size, indexed by the next table_bits bits.
+	lookup []uint32
+
+	// For each code size, the code after the last code of that size left-justified to 16 bits, and the index into
+	// sorted_syms of its first code minus the first code itself.
+	max_codes [cMaxExpectedHuffCodeSize + 2]uint32
+	val_ptrs  [cMaxExpectedHuffCodeSize + 1]int32
+
+	sorted_syms []uint16
+}
+
+// generate_decoder_tables builds the decoding tables for canonical codes of the given sizes.
+func generate_decoder_tables(num_syms uint32, code_sizes []uint8, t *decoder_tables) bool {
+	var num_codes [cMaxExpectedHuffCodeSize + 1]uint32
+	max_code_size := uint32(0)
+	for _, c := range code_sizes[:num_syms] {
+		if c > cMaxExpectedHuffCodeSize {
+			return false
+		}
+		num_codes[c]++
+		max_code_size = LZHAM_MAX(max_code_size, uint32(c))
+	}
+
+	t.num_syms = num_syms
+	t.table_bits = LZHAM_MIN(LZHAM_MAX(max_code_size, 1), cMaxTableBits)
+	if uint32(len(t.lookup)) != 1<<t.table_bits {
+		t.lookup = make([]uint32, 1<<t.table_bits)
+	}
+	if uint32(cap(t.sorted_syms)) < num_syms {
+		t.sorted_syms = make([]uint16, num_syms)
+	}
+	t.sorted_syms = t.sorted_syms[:num_syms]
+
+	var next_code, sorted_ofs [cMaxExpectedHuffCodeSize + 1]uint32
+	var code, ofs uint32
+	for i := uint32(1); i <= cMaxExpectedHuffCodeSize; i++ {
+		next_code[i] = code
+		sorted_ofs[i] = ofs
+		t.val_ptrs[i] = int32(ofs) - int32(code)
+
+		code += num_codes[i]
+		ofs += num_codes[i]
+		if num_codes[i] == 0 {
+			t.max_codes[i] = 0
+		} else {
+			t.max_codes[i] = code << (16 - i)
+		}
+		code <<= 1
+	}
+	t.max_codes[cMaxExpectedHuffCodeSize+1] = ^uint32(0)
+
+	for i := range t.lookup {
+		t.lookup[i] = 0
+	}
+
+	for sym := uint32(0); sym < num_syms; sym++ {
+		c := uint32(code_sizes[sym])
+		if c == 0 {
+			continue
+		}
+
+		t.sorted_syms[sorted_ofs[c]] = uint16(sym)
+		sorted_ofs[c]++
+
+		code := next_code[c]
+		next_code[c]++
+		if c <= t.table_bits {
+			first := code << (t.table_bits - c)
+			for j := uint32(0); j < 1<<(t.table_bits-c); j++ {
+				t.lookup[first+j] = sym<<16 | c
+			}
+		}
+	}
+
+	return true
+}
+
+// decode returns the symbol the 16 bits of lookahead start with and its code size, or a code size of 0 if they
+// don't start with a valid code.
+func (t *decoder_tables) decode(bits uint32) (uint32, uint32) {
+	if e := t.lookup[bits>>(16-t.table_bits)]; e != 0 {
+		return e >> 16, e & 0xFFFF
+	}
+
+	for c := t.table_bits + 1; c <= cMaxExpectedHuffCodeSize; c++ {
+		if bits < t.max_codes[c] {
+			i := int32(bits>>(16-c)) + t.val_ptrs[c]
+			if i < 0 || uint32(i) >= t.num_syms {
+				return 0, 0
+			}
+			return uint32(t.sorted_syms[i]), c
+		}
+	}
+
+	return 0, 0
+}
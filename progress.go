@@ -0,0 +1,25 @@
+package lzham
+
+import "time"
+
+// Progress is what a compressor or decompressor reports to its progress callback after each block of data, and
+// once more after the end of the stream.
+type Progress struct {
+	// Blocks is how many blocks of data have been done, which is the index of the next one.
+	Blocks uint32
+	// In is how many bytes have been consumed and Out how many produced, counting the zlib header if there's one.
+	In  int64
+	Out int64
+	// Ratio is the compressed size over the uncompressed size so far, whichever way the data is going.
+	Ratio float64
+	// Elapsed is the time since the stream was started or last reset.
+	Elapsed time.Duration
+}
+
+// get_ratio returns comp_size over size, or 0 before there's any data.
+func get_ratio(comp_size, size int64) float64 {
+	if size == 0 {
+		return 0
+	}
+	return float64(comp_size) / float64(size)
+}
@@ -0,0 +1,161 @@
+package lzham
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// progress_test_recorder collects what a progress callback is given, checking that it only ever goes forwards.
+type progress_test_recorder struct {
+	t      *testing.T
+	events []Progress
+}
+
+func (r *progress_test_recorder) progress(p Progress) {
+	if n := len(r.events); n > 0 {
+		last := r.events[n-1]
+		if p.Blocks < last.Blocks || p.In < last.In || p.Out < last.Out || p.Elapsed < last.Elapsed {
+			r.t.Errorf("progress went from %+v back to %+v", last, p)
+		}
+	}
+	r.events = append(r.events, p)
+}
+
+// last returns the final progress reported, which should be the whole stream's.
+func (r *progress_test_recorder) last() Progress {
+	if len(r.events) == 0 {
+		r.t.Fatal("no progress reported")
+	}
+	return r.events[len(r.events)-1]
+}
+
+func TestProgress(t *testing.T) {
+	data := append(gen_test_log(120, 100<<10), gen_test_records(121, 60<<10)...)
+
+	tests := []struct {
+		name       string
+		flags      lzham_compress_flags
+		compress   func(t *testing.T, opts *CompressOptions) []byte
+		decompress func(t *testing.T, opts *DecompressOptions, comp []byte) []byte
+	}{
+		{
+			name: "streaming",
+			compress: func(t *testing.T, opts *CompressOptions) []byte {
+				var comp bytes.Buffer
+				z, err := NewWriter(&comp, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for ofs := 0; ofs < len(data); ofs += 7000 {
+					if _, err := z.Write(data[ofs:min(ofs+7000, len(data))]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := z.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return comp.Bytes()
+			},
+			decompress: func(t *testing.T, opts *DecompressOptions, comp []byte) []byte {
+				z, err := NewReader(iotest.OneByteReader(bytes.NewReader(comp)), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(z)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return got
+			},
+		},
+		{
+			name:  "one shot zlib",
+			flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM,
+			compress: func(t *testing.T, opts *CompressOptions) []byte {
+				comp, err := CompressContext(context.Background(), data, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return comp
+			},
+			decompress: func(t *testing.T, opts *DecompressOptions, comp []byte) []byte {
+				got, err := DecompressContext(context.Background(), comp, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return got
+			},
+		},
+		{
+			name: "memory",
+			compress: func(t *testing.T, opts *CompressOptions) []byte {
+				params := opts.params()
+				comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &params))
+				n, _, status := LZHAM_lib_compress_memory(&params, comp, data)
+				if status != LZHAM_COMP_STATUS_SUCCESS {
+					t.Fatalf("LZHAM_lib_compress_memory() = %v", status)
+				}
+				return comp[:n]
+			},
+			decompress: func(t *testing.T, opts *DecompressOptions, comp []byte) []byte {
+				params := opts.params()
+				got := make([]byte, len(data))
+				n, _, status := LZHAM_lib_decompress_memory(&params, got, comp)
+				if status != LZHAM_DECOMP_STATUS_SUCCESS {
+					t.Fatalf("LZHAM_lib_decompress_memory() = %v", status)
+				}
+				return got[:n]
+			},
+		},
+		{
+			name: "pushed",
+			compress: func(t *testing.T, opts *CompressOptions) []byte {
+				return compress_test_writer(t, opts, data)
+			},
+			decompress: func(t *testing.T, opts *DecompressOptions, comp []byte) []byte {
+				params := opts.params()
+				state, err := LZHAM_lib_decompress_init(&params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer LZHAM_lib_decompress_deinit(state)
+				got, _, status := decompress_test_chunks(t, state, comp, 1000, 3000)
+				if status != LZHAM_DECOMP_STATUS_SUCCESS {
+					t.Fatalf("LZHAM_lib_decompress() = %v", status)
+				}
+				return got
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp_progress := &progress_test_recorder{t: t}
+			comp := tt.compress(t, &CompressOptions{DictSizeLog2: 16, Flags: tt.flags, Progress: comp_progress.progress})
+
+			decomp_flags := lzham_decompress_flags(0)
+			if tt.flags&LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM != 0 {
+				decomp_flags = LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM
+			}
+			decomp_progress := &progress_test_recorder{t: t}
+			got := tt.decompress(t, &DecompressOptions{DictSizeLog2: 16, Flags: decomp_flags, Progress: decomp_progress.progress}, comp)
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(data))
+			}
+
+			// Each side's last report covers the whole stream, in the same number of blocks.
+			want_ratio := float64(len(comp)) / float64(len(data))
+			if p := comp_progress.last(); p.In != int64(len(data)) || p.Out != int64(len(comp)) || p.Ratio != want_ratio || p.Blocks < 2 {
+				t.Errorf("last compressor progress = %+v, want %d -> %d bytes", p, len(data), len(comp))
+			}
+			if p := decomp_progress.last(); p.In != int64(len(comp)) || p.Out != int64(len(data)) || p.Ratio != want_ratio || p.Blocks != comp_progress.last().Blocks {
+				t.Errorf("last decompressor progress = %+v, want %d -> %d bytes in %d blocks", p, len(comp), len(data), comp_progress.last().Blocks)
+			}
+			if n := len(decomp_progress.events); n != int(decomp_progress.last().Blocks)+1 {
+				t.Errorf("decompressor reported progress %d times, want once a block and once at the end", n)
+			}
+		})
+	}
+}
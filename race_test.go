@@ -0,0 +1,6 @@
+//go:build race
+
+package lzham
+
+// The race detector slows everything down several times over, which timed and long running tests have to allow for.
+const race_enabled = true
@@ -0,0 +1,207 @@
+package lzham
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+)
+
+// Reader is an io.ReadCloser that decompresses an LZHAM stream read from an underlying reader, a block at a time
+// as its data is asked for. Blocks are decoded as their bytes arrive, so everything written before a flush can be
+// read without waiting for the rest of the stream.
+//
+// If the underlying reader is an io.ByteReader, nothing past the end of the stream is read from it, so that
+// whatever follows the stream can be read from it next. Other readers are buffered, and may be read past the end.
+type Reader struct {
+	ctx    context.Context
+	r      io.ByteReader
+	buf    *bufio.Reader
+	params LZHAM_decompress_params
+	d      lzdecompressor
+
+	// The last block decoded, of which out[out_ofs:] is still to be read.
+	out     []byte
+	out_ofs int
+
+	header_checked bool
+	err            error
+}
+
+// NewReader returns a Reader decompressing the stream in r with opts, or with DefaultDecompressOptions if opts is nil.
+func NewReader(r io.Reader, opts *DecompressOptions) (*Reader, error) {
+	return NewReaderContext(context.Background(), r, opts)
+}
+
+// NewReaderContext is NewReader for a Reader that gives up at the next block once ctx is done, returning ctx.Err()
+// from then on.
+func NewReaderContext(ctx context.Context, r io.Reader, opts *DecompressOptions) (*Reader, error) {
+	if opts == nil {
+		default_opts := DefaultDecompressOptions()
+		opts = &default_opts
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	z := &Reader{ctx: ctx, params: opts.params()}
+	if status := create_decompressor(&z.d, &z.params); status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+		return nil, decompress_status_error(status)
+	}
+	z.set_reader(r)
+
+	return z, nil
+}
+
+// Read decompresses into p. It returns io.EOF after the end of the stream, whose adler32 has been checked by then.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.out_ofs == len(z.out) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.decode_block()
+	}
+
+	n := copy(p, z.out[z.out_ofs:])
+	z.out_ofs += n
+	return n, nil
+}
+
+// WriteTo decompresses the rest of the stream to w a block at a time, saving io.Copy a copy through its own buffer.
+func (z *Reader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if z.out_ofs < len(z.out) {
+			n, err := w.Write(z.out[z.out_ofs:])
+			z.out_ofs += n
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+
+		if z.err != nil {
+			if z.err == io.EOF {
+				return total, nil
+			}
+			return total, z.err
+		}
+		z.err = z.decode_block()
+	}
+}
+
+// Close returns the error that stopped decompression, if it didn't stop at the end of the stream. It doesn't close
+// the underlying reader.
+func (z *Reader) Close() error {
+	if z.err == io.EOF {
+		return nil
+	}
+	return z.err
+}
+
+// Reset discards the Reader's state and makes it decompress a new stream from r with the same options and context,
+// keeping its buffers.
+func (z *Reader) Reset(r io.Reader) error {
+	z.d.reset()
+	z.set_reader(r)
+	z.out = z.out[:0]
+	z.out_ofs = 0
+	z.header_checked = false
+	z.err = nil
+	return nil
+}
+
+// set_reader makes the decompressor read from r, through a buffer unless it can read from it a byte at a time.
+func (z *Reader) set_reader(r io.Reader) {
+	if br, ok := r.(io.ByteReader); ok {
+		z.r = br
+	} else if z.buf != nil {
+		z.buf.Reset(r)
+		z.r = z.buf
+	} else {
+		z.buf = bufio.NewReader(r)
+		z.r = z.buf
+	}
+	z.d.set_input(z.r)
+}
+
+// decode_block decodes the next block into out, reading it from r as the decompressor needs it. It returns io.EOF
+// after the EOF block.
+func (z *Reader) decode_block() error {
+	if err := z.ctx.Err(); err != nil {
+		return err
+	}
+	if err := z.check_header(); err != nil {
+		return err
+	}
+
+	z.out_ofs = 0
+	_, out, status := z.d.decompress_block(nil, z.out[:0])
+	z.out = out
+
+	switch status {
+	case LZHAM_DECOMP_STATUS_NOT_FINISHED:
+		return nil
+	case LZHAM_DECOMP_STATUS_SUCCESS:
+		return io.EOF
+	case LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT:
+		if err := z.d.get_input_err(); err != nil && err != io.EOF {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	default:
+		return decompress_status_error(status)
+	}
+}
+
+// check_header checks the zlib header ahead of the first block, if the stream has one.
+func (z *Reader) check_header() error {
+	if z.header_checked || z.params.decompress_flags&uint32(LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM) == 0 {
+		return nil
+	}
+
+	var header [2]byte
+	for i := range header {
+		c, err := z.r.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		header[i] = c
+	}
+	if status := check_zlib_header(header[0], header[1], &z.params); status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+		return decompress_status_error(status)
+	}
+
+	z.d.comp_size += 2
+	z.header_checked = true
+	return nil
+}
+
+// decompress_status_error returns the Error of a failed decompressor status, except that a stream that ends early
+// gives io.ErrUnexpectedEOF.
+func decompress_status_error(status lzham_decompress_status_t) error {
+	switch status {
+	case LZHAM_DECOMP_STATUS_NEEDS_MORE_INPUT, LZHAM_DECOMP_STATUS_FAILED_EXPECTED_MORE_RAW_BYTES:
+		return io.ErrUnexpectedEOF
+	default:
+		return Error{DecompStatus: status}
+	}
+}
+
+// DecompressContext decompresses the whole stream in src with opts, or with DefaultDecompressOptions if opts is
+// nil. It returns ctx.Err() if ctx is done first.
+func DecompressContext(ctx context.Context, src []byte, opts *DecompressOptions) ([]byte, error) {
+	z, err := NewReaderContext(ctx, bytes.NewReader(src), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if _, err := z.WriteTo(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,274 @@
+package lzham
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// compress_test_writer compresses data with a Writer using opts.
+func compress_test_writer(tb testing.TB, opts *CompressOptions, data []byte) []byte {
+	var comp bytes.Buffer
+	z, err := NewWriter(&comp, opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := z.Write(data); err != nil {
+		tb.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return comp.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	data := append(gen_test_log(70, 200<<10), gen_test_records(71, 200<<10)...)
+	seed := gen_test_data(72, 5000)
+
+	tests := []struct {
+		name      string
+		comp_opts *CompressOptions
+		opts      *DecompressOptions
+		reader    func(io.Reader) io.Reader
+	}{
+		{name: "defaults", reader: func(r io.Reader) io.Reader { return r }},
+		{name: "one byte reads", comp_opts: &CompressOptions{DictSizeLog2: 15}, opts: &DecompressOptions{DictSizeLog2: 15}, reader: iotest.OneByteReader},
+		{name: "half reads", comp_opts: &CompressOptions{DictSizeLog2: 16, Level: LZHAM_COMP_LEVEL_UBER}, opts: &DecompressOptions{DictSizeLog2: 16}, reader: iotest.HalfReader},
+		{name: "eof with data", reader: iotest.DataErrReader},
+		{
+			name:      "zlib with seed",
+			comp_opts: &CompressOptions{DictSizeLog2: 17, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM, TableUpdateRate: LZHAM_FASTEST_TABLE_UPDATE_RATE, SeedBytes: seed},
+			opts:      &DecompressOptions{DictSizeLog2: 17, Flags: LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM, TableUpdateRate: LZHAM_FASTEST_TABLE_UPDATE_RATE, SeedBytes: seed},
+			reader:    iotest.OneByteReader,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp := compress_test_writer(t, tt.comp_opts, data)
+
+			z, err := NewReader(tt.reader(bytes.NewReader(comp)), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := iotest.TestReader(z, data); err != nil {
+				t.Fatal(err)
+			}
+			if err := z.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+
+			// WriteTo takes over part way through a stream.
+			if err := z.Reset(tt.reader(bytes.NewReader(comp))); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, 1000)
+			if _, err := io.ReadFull(z, got); err != nil {
+				t.Fatal(err)
+			}
+			var rest bytes.Buffer
+			if n, err := z.WriteTo(&rest); err != nil || n != int64(rest.Len()) {
+				t.Fatalf("WriteTo() = %d, %v", n, err)
+			}
+			if got = append(got, rest.Bytes()...); !bytes.Equal(got, data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestReader_flush(t *testing.T) {
+	data := gen_test_log(73, 100<<10)
+
+	pr, pw := io.Pipe()
+	w, err := NewWriter(pw, &CompressOptions{DictSizeLog2: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(pr, &DecompressOptions{DictSizeLog2: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything before a flush can be read while the writer waits for it to be, before the stream goes on.
+	chunks := [][]byte{data[:10], data[10:20000], data[20000:]}
+	go func() {
+		for _, chunk := range chunks {
+			w.Write(chunk)
+			w.Flush()
+		}
+		w.Close()
+		pw.Close()
+	}()
+
+	for _, chunk := range chunks {
+		got := make([]byte, len(chunk))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, chunk) {
+			t.Fatalf("read %d bytes that don't match", len(chunk))
+		}
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read() at the end = %d, %v", n, err)
+	}
+}
+
+func TestReader_errors(t *testing.T) {
+	data := gen_test_records(74, 100<<10)
+	comp := compress_test_writer(t, &CompressOptions{DictSizeLog2: 16}, data)
+
+	corrupt := append([]byte(nil), comp...)
+	corrupt[len(corrupt)/2] ^= 0x40
+
+	tests := []struct {
+		name string
+		comp []byte
+		opts *DecompressOptions
+		want error
+	}{
+		{name: "truncated", comp: comp[:len(comp)/2], want: io.ErrUnexpectedEOF},
+		{name: "no adler32", comp: comp[:len(comp)-2], want: io.ErrUnexpectedEOF},
+		{name: "empty", want: io.ErrUnexpectedEOF},
+		{name: "corrupt", comp: corrupt},
+		{name: "missing zlib header", comp: comp, opts: &DecompressOptions{DictSizeLog2: 16, Flags: LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM}, want: ErrBadZlibHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			if opts == nil {
+				opts = &DecompressOptions{DictSizeLog2: 16}
+			}
+			z, err := NewReader(bytes.NewReader(tt.comp), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.Copy(io.Discard, z)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("io.Copy() = %v, want %v", err, tt.want)
+			}
+			if z.Close() != err {
+				t.Errorf("Close() = %v, want %v", z.Close(), err)
+			}
+		})
+	}
+
+	if _, err := NewReader(bytes.NewReader(comp), &DecompressOptions{DictSizeLog2: 14}); !errors.Is(err, ErrInvalidDictSizeLog2) {
+		t.Errorf("NewReader() with a bad dictionary = %v", err)
+	}
+}
+
+func TestReader_stream_end(t *testing.T) {
+	random := make([]byte, 50<<10)
+	rand.New(rand.NewSource(75)).Read(random)
+
+	tests := []struct {
+		name  string
+		opts  *CompressOptions
+		data  []byte
+		flush func(w *Writer) error
+	}{
+		{name: "text", opts: &CompressOptions{DictSizeLog2: 16, Level: LZHAM_COMP_LEVEL_BETTER}, data: gen_test_log(76, 100<<10)},
+		{name: "raw blocks", opts: &CompressOptions{DictSizeLog2: 16}, data: random},
+		{name: "empty", opts: &CompressOptions{DictSizeLog2: 15}},
+		{name: "zlib", opts: &CompressOptions{DictSizeLog2: 15, Level: LZHAM_COMP_LEVEL_FASTEST, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM}, data: gen_test_records(77, 50<<10)},
+		{name: "flushed", opts: &CompressOptions{DictSizeLog2: 16}, data: gen_test_data(78, 50<<10), flush: (*Writer).Flush},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decomp_opts := &DecompressOptions{DictSizeLog2: tt.opts.DictSizeLog2}
+			if tt.opts.Flags&LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM != 0 {
+				decomp_opts.Flags = LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM
+			}
+
+			// Two streams, each followed by a trailer, as a container would store them.
+			var container bytes.Buffer
+			trailer := []byte("trailer\xff\x00")
+			for i := 0; i < 2; i++ {
+				w, err := NewWriter(&container, tt.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for ofs := 0; ofs < len(tt.data); ofs += 10000 {
+					end := ofs + 10000
+					if end > len(tt.data) {
+						end = len(tt.data)
+					}
+					if _, err := w.Write(tt.data[ofs:end]); err != nil {
+						t.Fatal(err)
+					}
+					if tt.flush != nil {
+						if err := tt.flush(w); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				container.Write(trailer)
+			}
+
+			src := bytes.NewReader(container.Bytes())
+			z, err := NewReader(src, decomp_opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				if i > 0 {
+					if err := z.Reset(src); err != nil {
+						t.Fatal(err)
+					}
+				}
+				got, err := io.ReadAll(z)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, tt.data) {
+					t.Fatalf("stream %d: decoded %d bytes, which don't match the %d input bytes", i, len(got), len(tt.data))
+				}
+
+				next := make([]byte, len(trailer))
+				if _, err := io.ReadFull(src, next); err != nil || !bytes.Equal(next, trailer) {
+					t.Fatalf("stream %d: read %q after the stream, want %q", i, next, trailer)
+				}
+			}
+			if src.Len() != 0 {
+				t.Errorf("%d bytes left", src.Len())
+			}
+		})
+	}
+}
+
+func TestReader_context(t *testing.T) {
+	data := gen_test_records(75, 300<<10)
+	comp := compress_test_writer(t, &CompressOptions{DictSizeLog2: 16}, data)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	z, err := NewReaderContext(ctx, bytes.NewReader(comp), &DecompressOptions{DictSizeLog2: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	if _, err := io.ReadFull(z, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// What's left of the block already decoded is still returned.
+	cancel()
+	n, err := io.Copy(io.Discard, z)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("io.Copy() = %d, %v, want context.Canceled", n, err)
+	}
+	if 1000+n >= int64(len(data)) {
+		t.Errorf("read %d bytes of %d after the cancel", 1000+n, len(data))
+	}
+	if err := z.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close() = %v, want context.Canceled", err)
+	}
+}
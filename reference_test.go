@@ -0,0 +1,93 @@
+//go:build reference
+
+package lzham
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The reference tests check streams against the C library, and run with -tags reference. testdata/reference holds
+// inputs named <name>, and for each the stream the C library's lzham_compress_memory wrote from it at the default
+// level, with no flags, no seed bytes, the default table update rate and a dictionary size log2 of N, named
+// <name>.d<N>.lzham.
+var reference_dir = filepath.Join("testdata", "reference")
+
+// reference_streams returns the paths of the C library's streams, failing the test when there are none.
+func reference_streams(t *testing.T) []string {
+	streams, err := filepath.Glob(filepath.Join(reference_dir, "*.lzham"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) == 0 {
+		t.Fatalf("no streams from the C library in %s", reference_dir)
+	}
+	return streams
+}
+
+// read_reference_stream returns the C library's stream at path, the input it was written from and its dictionary
+// size log2.
+func read_reference_stream(t *testing.T, path string) (comp, want []byte, dict_size_log2 uint32) {
+	stream_name := strings.TrimSuffix(filepath.Base(path), ".lzham")
+	dict_ext := filepath.Ext(stream_name)
+	n, err := strconv.ParseUint(strings.TrimPrefix(dict_ext, ".d"), 10, 32)
+	if err != nil {
+		t.Fatalf("no dictionary size in %q: %v", stream_name, err)
+	}
+	if comp, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if want, err = os.ReadFile(filepath.Join(reference_dir, strings.TrimSuffix(stream_name, dict_ext))); err != nil {
+		t.Fatal(err)
+	}
+	return comp, want, uint32(n)
+}
+
+func Test_reference_streams(t *testing.T) {
+	for _, path := range reference_streams(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			comp, want, dict_size_log2 := read_reference_stream(t, path)
+
+			params := LZHAM_decompress_params{dict_size_log2: dict_size_log2}
+			got := make([]byte, len(want))
+			n, adler, status := LZHAM_lib_decompress_memory(&params, got, comp)
+			if status != LZHAM_DECOMP_STATUS_SUCCESS {
+				t.Fatalf("status %d", status)
+			}
+			if !bytes.Equal(got[:n], want) || adler != lzham_adler32_update(1, want) {
+				t.Errorf("decompressed %d bytes with adler32 %#x, which don't match the %d bytes expected", n, adler, len(want))
+			}
+		})
+	}
+}
+
+// Test_reference_streams_for_c compresses every reference input with the same settings as its C stream, into
+// testdata/reference/go/<name>.d<N>.lzham, for the C library's lzham_decompress_memory to decode back to <name>.
+func Test_reference_streams_for_c(t *testing.T) {
+	streams := reference_streams(t)
+	out_dir := filepath.Join(reference_dir, "go")
+	if err := os.MkdirAll(out_dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range streams {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			c_comp, data, dict_size_log2 := read_reference_stream(t, path)
+
+			params := LZHAM_compress_params{dict_size_log2: dict_size_log2, level: LZHAM_COMP_LEVEL_DEFAULT}
+			comp := make([]byte, LZHAM_lib_compress_bound(uint64(len(data)), &params))
+			n, _, status := LZHAM_lib_compress_memory(&params, comp, data)
+			if status != LZHAM_COMP_STATUS_SUCCESS {
+				t.Fatalf("status %d", status)
+			}
+			t.Logf("%d bytes, %d from the C library", n, len(c_comp))
+			if err := os.WriteFile(filepath.Join(out_dir, filepath.Base(path)), comp[:n], 0o644); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,5 +1,19 @@
 package lzham
 
+import (
+	"io"
+	"math"
+)
+
+const (
+	cSymbolCodecArithMinLen        = 0x01000000
+	cSymbolCodecArithMaxLen        = 0xFFFFFFFF
+	cSymbolCodecArithProbBits      = 11
+	cSymbolCodecArithProbScale     = 1 << cSymbolCodecArithProbBits
+	cSymbolCodecArithProbHalfScale = 1 << (cSymbolCodecArithProbBits - 1)
+	cSymbolCodecArithProbMoveBits  = 5
+)
+
 const (
 	cBitBufSize = 64
 
@@ -26,6 +40,12 @@ type symbol_codec struct {
 	pDecode_buf_end  []byte
 	decode_buf_size  uint64
 	decode_buf_eof   bool
+	decode_overrun   uint64 // Zero bytes read past the end of the buffer.
+
+	// When set, bytes past the end of the buffer are read from decode_src as they're needed, counting towards
+	// decode_buf_size. decode_src_err is the error that ended it, after which the rest are zeros too.
+	decode_src     io.ByteReader
+	decode_src_err error
 
 	pDecode_need_bytes_func func(num_bytes_consumed uint64, pPrivate_data []byte, pBuf []byte, buf_size uint64, eof_flag bool)
 	pDecode_private_data    []byte
@@ -59,6 +79,7 @@ func (sc *symbol_codec) reset() {
 	sc.pDecode_buf_next = nil
 	sc.pDecode_buf_end = nil
 	sc.decode_buf_size = 0
+	sc.decode_overrun = 0
 
 	sc.bit_buf = 0
 	sc.bit_count = 0
@@ -81,3 +102,591 @@ func (sc *symbol_codec) reset() {
 	// sc.pSaved_model = nil
 	sc.saved_node_index = 0
 }
+
+// start_encoding begins recording symbols. Nothing is written until stop_encoding assembles them.
+func (sc *symbol_codec) start_encoding(expected_file_size uint32) bool {
+	if sc.mode != cNull {
+		return false
+	}
+
+	sc.output_buf = sc.output_buf[:0]
+	sc.arith_output_buf = sc.arith_output_buf[:0]
+	sc.output_syms = sc.output_syms[:0]
+	if cap(sc.output_syms) == 0 {
+		sc.output_syms = make([]output_symbol, 0, LZHAM_MAX(expected_file_size, 64))
+	}
+
+	sc.bit_buf = 0
+	sc.bit_count = 0
+	sc.total_bits_written = 0
+	sc.total_model_updates = 0
+	sc.mode = cEncoding
+
+	return true
+}
+
+// encode_bits records num_bits raw bits, written MSB first.
+func (sc *symbol_codec) encode_bits(bits, num_bits uint32) {
+	if num_bits == 0 {
+		return
+	}
+	sc.output_syms = append(sc.output_syms, output_symbol{bits: bits, num_bits: int16(num_bits)})
+	sc.total_bits_written += num_bits
+}
+
+// encode_align_to_byte pads with zero bits up to the next byte boundary.
+func (sc *symbol_codec) encode_align_to_byte() {
+	sc.output_syms = append(sc.output_syms, output_symbol{num_bits: cAlignToByteSym})
+}
+
+// encode_arith_init starts a new run of arithmetic coded bits, which ends at the next encode_arith_init or at stop_encoding.
+func (sc *symbol_codec) encode_arith_init() {
+	sc.output_syms = append(sc.output_syms, output_symbol{num_bits: cArithInit})
+}
+
+// encode_bit records an arithmetic coded bit and updates its model.
+func (sc *symbol_codec) encode_bit(bit uint32, model *adaptive_bit_model) {
+	sc.output_syms = append(sc.output_syms, output_symbol{bits: bit, num_bits: cArithSym, arith_prob0: model.bit_0_prob})
+	model.update(bit)
+	sc.total_model_updates++
+}
+
+// encode_sym records a symbol's Huffman code and updates its model.
+func (sc *symbol_codec) encode_sym(sym uint32, model *quasi_adaptive_huffman_data_model) bool {
+	num_bits := uint32(model.code_sizes[sym])
+	sc.encode_bits(uint32(model.codes[sym]), num_bits)
+	sc.total_model_updates++
+	return model.update(sym)
+}
+
+// stop_encoding assembles the recorded symbols into output_buf. The arithmetic coder runs first over all of its
+// bits, so any carry has settled, then its bytes are interleaved with the other bits at the points the decoder
+// will read them: four when it starts, then one per renormalization.
+func (sc *symbol_codec) stop_encoding() bool {
+	if sc.mode != cEncoding {
+		return false
+	}
+
+	active := false
+	for _, sym := range sc.output_syms {
+		switch sym.num_bits {
+		case cArithInit:
+			if active {
+				sc.arith_flush()
+			}
+			sc.arith_base = 0
+			sc.arith_length = cSymbolCodecArithMaxLen
+			active = true
+		case cArithSym:
+			sc.arith_encode(sym.bits, uint32(sym.arith_prob0))
+		}
+	}
+	if active {
+		sc.arith_flush()
+	}
+
+	arith_ofs := 0
+	for _, sym := range sc.output_syms {
+		switch sym.num_bits {
+		case cArithInit:
+			for i := 0; i < 4; i++ {
+				sc.put_bits(uint32(sc.arith_output_buf[arith_ofs]), 8)
+				arith_ofs++
+			}
+			sc.arith_length = cSymbolCodecArithMaxLen
+		case cArithSym:
+			x := uint32(sym.arith_prob0) * (sc.arith_length >> cSymbolCodecArithProbBits)
+			if sym.bits == 0 {
+				sc.arith_length = x
+			} else {
+				sc.arith_length -= x
+			}
+			for sc.arith_length < cSymbolCodecArithMinLen {
+				sc.put_bits(uint32(sc.arith_output_buf[arith_ofs]), 8)
+				arith_ofs++
+				sc.arith_length <<= 8
+			}
+		case cAlignToByteSym:
+			if sc.bit_count&7 != 0 {
+				sc.put_bits(0, 8-uint32(sc.bit_count&7))
+			}
+		default:
+			sc.put_bits(sym.bits, uint32(sym.num_bits))
+		}
+	}
+
+	if sc.bit_count > 0 {
+		sc.put_bits(0, 8-uint32(sc.bit_count&7))
+	}
+
+	sc.output_syms = sc.output_syms[:0]
+	sc.mode = cNull
+
+	return true
+}
+
+// get_encoding_buf returns the bytes assembled by the last stop_encoding.
+func (sc *symbol_codec) get_encoding_buf() []byte {
+	return sc.output_buf
+}
+
+func (sc *symbol_codec) put_bits(bits, num_bits uint32) {
+	sc.bit_buf |= uint64(bits) << (cBitBufSize - uint32(sc.bit_count) - num_bits)
+	sc.bit_count += int32(num_bits)
+	for sc.bit_count >= 8 {
+		sc.output_buf = append(sc.output_buf, byte(sc.bit_buf>>(cBitBufSize-8)))
+		sc.bit_buf <<= 8
+		sc.bit_count -= 8
+	}
+}
+
+func (sc *symbol_codec) arith_encode(bit, prob0 uint32) {
+	x := prob0 * (sc.arith_length >> cSymbolCodecArithProbBits)
+	if bit == 0 {
+		sc.arith_length = x
+	} else {
+		orig_base := sc.arith_base
+		sc.arith_base += x
+		sc.arith_length -= x
+		if orig_base > sc.arith_base {
+			sc.arith_propagate_carry()
+		}
+	}
+
+	for sc.arith_length < cSymbolCodecArithMinLen {
+		sc.arith_output_buf = append(sc.arith_output_buf, byte(sc.arith_base>>24))
+		sc.arith_base <<= 8
+		sc.arith_length <<= 8
+	}
+}
+
+func (sc *symbol_codec) arith_propagate_carry() {
+	for i := len(sc.arith_output_buf) - 1; i >= 0; i-- {
+		sc.arith_output_buf[i]++
+		if sc.arith_output_buf[i] != 0 {
+			break
+		}
+	}
+}
+
+// arith_flush writes out the whole base, which is inside the final range, so the decoder can read its four bytes ahead.
+func (sc *symbol_codec) arith_flush() {
+	for i := 0; i < 4; i++ {
+		sc.arith_output_buf = append(sc.arith_output_buf, byte(sc.arith_base>>24))
+		sc.arith_base <<= 8
+	}
+}
+
+// start_decoding begins decoding buf. Reading past its end yields zero bits, see decode_overran.
+func (sc *symbol_codec) start_decoding(buf []byte) bool {
+	if sc.mode != cNull {
+		return false
+	}
+
+	sc.pDecode_buf = buf
+	sc.pDecode_buf_next = buf
+	sc.decode_buf_size = uint64(len(buf))
+	sc.decode_overrun = 0
+
+	sc.bit_buf = 0
+	sc.bit_count = 0
+	sc.total_model_updates = 0
+	sc.mode = cDecoding
+
+	return true
+}
+
+// resume_decoding goes on decoding from buf, which follows on from the bytes read of the last buffer. Unlike
+// start_decoding it keeps the bits read but not yet used and the arithmetic decoder's state.
+func (sc *symbol_codec) resume_decoding(buf []byte) bool {
+	if sc.mode != cNull {
+		return false
+	}
+
+	sc.pDecode_buf = buf
+	sc.pDecode_buf_next = buf
+	sc.decode_buf_size = uint64(len(buf))
+	sc.decode_overrun = 0
+	sc.mode = cDecoding
+
+	return true
+}
+
+// stop_decoding ends decoding and returns the number of whole bytes consumed.
+func (sc *symbol_codec) stop_decoding() uint64 {
+	n := sc.decode_get_bytes_consumed()
+	sc.mode = cNull
+	return n
+}
+
+// decode_get_bytes_consumed returns how many bytes have been used, not counting whole bytes still buffered. It's
+// more than the size of the buffer if decoding ran past its end.
+func (sc *symbol_codec) decode_get_bytes_consumed() uint64 {
+	return sc.decode_buf_size - uint64(len(sc.pDecode_buf_next)) + sc.decode_overrun - uint64(sc.bit_count>>3)
+}
+
+// decode_overran reports whether decoding used bits past the end of the buffer: more of the zeros read past it
+// than are still in the bit buffer.
+func (sc *symbol_codec) decode_overran() bool {
+	return sc.decode_overrun*8 > uint64(sc.bit_count)
+}
+
+// decode_pos is how far decoding has got, for going back to with set_decode_pos.
+type decode_pos struct {
+	buf_next     int
+	overrun      uint64
+	bit_buf      uint64
+	bit_count    int32
+	arith_value  uint32
+	arith_length uint32
+}
+
+func (sc *symbol_codec) get_decode_pos() decode_pos {
+	return decode_pos{
+		buf_next:     len(sc.pDecode_buf_next),
+		overrun:      sc.decode_overrun,
+		bit_buf:      sc.bit_buf,
+		bit_count:    sc.bit_count,
+		arith_value:  sc.arith_value,
+		arith_length: sc.arith_length,
+	}
+}
+
+// set_decode_pos goes back to pos, from get_decode_pos in the same buffer. The zeros read past the end of the buffer
+// by then are dropped from the bit buffer, so resume_decoding can read the real bytes in their place.
+func (sc *symbol_codec) set_decode_pos(pos decode_pos) {
+	sc.pDecode_buf_next = sc.pDecode_buf[len(sc.pDecode_buf)-pos.buf_next:]
+	sc.decode_overrun = 0
+	sc.bit_buf = pos.bit_buf
+	sc.bit_count = pos.bit_count - int32(pos.overrun*8)
+	sc.arith_value = pos.arith_value
+	sc.arith_length = pos.arith_length
+}
+
+// decode_get_bytes_read returns how many bytes of the buffer have been read, including any still in the bit buffer.
+func (sc *symbol_codec) decode_get_bytes_read() uint64 {
+	return sc.decode_buf_size - uint64(len(sc.pDecode_buf_next))
+}
+
+func (sc *symbol_codec) fill_bit_buf(num_bits int32) {
+	for sc.bit_count < num_bits {
+		var c byte
+		if len(sc.pDecode_buf_next) > 0 {
+			c = sc.pDecode_buf_next[0]
+			sc.pDecode_buf_next = sc.pDecode_buf_next[1:]
+		} else if sc.decode_src != nil && sc.decode_src_err == nil {
+			if c, sc.decode_src_err = sc.decode_src.ReadByte(); sc.decode_src_err == nil {
+				sc.decode_buf_size++
+			} else {
+				c = 0
+				sc.decode_overrun++
+			}
+		} else {
+			sc.decode_overrun++
+		}
+		sc.bit_buf |= uint64(c) << (cBitBufSize - 8 - sc.bit_count)
+		sc.bit_count += 8
+	}
+}
+
+// decode_bits returns the next num_bits raw bits, at most 32.
+func (sc *symbol_codec) decode_bits(num_bits uint32) uint32 {
+	if num_bits == 0 {
+		return 0
+	}
+	sc.fill_bit_buf(int32(num_bits))
+	bits := uint32(sc.bit_buf >> (cBitBufSize - num_bits))
+	sc.bit_buf <<= num_bits
+	sc.bit_count -= int32(num_bits)
+	return bits
+}
+
+// decode_align_to_byte skips the padding bits up to the next byte boundary.
+func (sc *symbol_codec) decode_align_to_byte() {
+	sc.decode_bits(uint32(sc.bit_count & 7))
+}
+
+// decode_arith_init starts decoding a run of arithmetic coded bits.
+func (sc *symbol_codec) decode_arith_init() {
+	sc.arith_length = cSymbolCodecArithMaxLen
+	sc.arith_value = 0
+	for i := 0; i < 4; i++ {
+		sc.arith_value = sc.arith_value<<8 | sc.decode_bits(8)
+	}
+}
+
+// decode_bit decodes an arithmetic coded bit and updates its model.
+func (sc *symbol_codec) decode_bit(model *adaptive_bit_model) uint32 {
+	x := uint32(model.bit_0_prob) * (sc.arith_length >> cSymbolCodecArithProbBits)
+
+	var bit uint32
+	if sc.arith_value < x {
+		sc.arith_length = x
+	} else {
+		bit = 1
+		sc.arith_value -= x
+		sc.arith_length -= x
+	}
+	model.update(bit)
+	sc.total_model_updates++
+
+	for sc.arith_length < cSymbolCodecArithMinLen {
+		sc.arith_value = sc.arith_value<<8 | sc.decode_bits(8)
+		sc.arith_length <<= 8
+	}
+
+	return bit
+}
+
+// decode_sym decodes a Huffman coded symbol and updates its model. ok is false on an invalid code.
+func (sc *symbol_codec) decode_sym(model *quasi_adaptive_huffman_data_model) (sym uint32, ok bool) {
+	if sym, ok = sc.decode_sym_no_update(model); !ok {
+		return 0, false
+	}
+	sc.total_model_updates++
+	return sym, model.update(sym)
+}
+
+// decode_sym_no_update decodes a Huffman coded symbol, leaving the model to be updated by the caller.
+func (sc *symbol_codec) decode_sym_no_update(model *quasi_adaptive_huffman_data_model) (sym uint32, ok bool) {
+	if sc.decode_src == nil {
+		sc.fill_bit_buf(cMaxExpectedHuffCodeSize)
+	}
+
+	var num_bits uint32
+	for {
+		sym, num_bits = model.decode_tables.decode(uint32(sc.bit_buf >> (cBitBufSize - cMaxExpectedHuffCodeSize)))
+		if sc.bit_count >= cMaxExpectedHuffCodeSize || (num_bits > 0 && int32(num_bits) <= sc.bit_count) {
+			break
+		}
+		// A code that fits in the bits already read is the one they'd decode to whatever follows, so a stream is
+		// only read a byte at a time as codes need it, and never past the end of a block.
+		sc.fill_bit_buf(sc.bit_count + 1)
+	}
+	if num_bits == 0 {
+		return 0, false
+	}
+	sc.bit_buf <<= num_bits
+	sc.bit_count -= int32(num_bits)
+	return sym, true
+}
+
+// Bit costs are fixed point with cBitCostScaleShift fractional bits.
+type bit_cost_t = uint64
+
+const (
+	cBitCostScaleShift = 24
+	cBitCostScale      = 1 << cBitCostScaleShift
+	cBitCostMax        = ^bit_cost_t(0)
+)
+
+func convert_to_scaled_bitcost(bits uint32) bit_cost_t {
+	return bit_cost_t(bits) << cBitCostScaleShift
+}
+
+// g_prob_cost[p] is the cost of coding a bit whose probability is p/cSymbolCodecArithProbScale.
+var g_prob_cost [cSymbolCodecArithProbScale]uint32
+
+func init() {
+	for i := 1; i < cSymbolCodecArithProbScale; i++ {
+		g_prob_cost[i] = uint32(-math.Log2(float64(i)/cSymbolCodecArithProbScale)*cBitCostScale + .5)
+	}
+	g_prob_cost[0] = g_prob_cost[1]
+}
+
+type adaptive_bit_model struct {
+	bit_0_prob uint16
+}
+
+func (m *adaptive_bit_model) clear() {
+	m.bit_0_prob = cSymbolCodecArithProbHalfScale
+}
+
+func (m *adaptive_bit_model) update(bit uint32) {
+	if bit == 0 {
+		m.bit_0_prob += (cSymbolCodecArithProbScale - m.bit_0_prob) >> cSymbolCodecArithProbMoveBits
+	} else {
+		m.bit_0_prob -= m.bit_0_prob >> cSymbolCodecArithProbMoveBits
+	}
+}
+
+func (m *adaptive_bit_model) get_cost(bit uint32) bit_cost_t {
+	if bit == 0 {
+		return bit_cost_t(g_prob_cost[m.bit_0_prob])
+	}
+	return bit_cost_t(g_prob_cost[cSymbolCodecArithProbScale-m.bit_0_prob])
+}
+
+const (
+	cSymbolCodecMinUpdateCycle = 16
+	cSymbolCodecMaxTotalCount  = 32768
+
+	cDefaultMaxUpdateInterval = 64
+	cDefaultUpdateSlowRate    = 64
+)
+
+// quasi_adaptive_huffman_data_model is a Huffman table whose code sizes are periodically rebuilt from symbol
+// frequencies. Tables are rebuilt often at first, then less and less often up to max_update_interval symbols.
+type quasi_adaptive_huffman_data_model struct {
+	sym_freq   []uint16
+	codes      []uint16
+	code_sizes []uint8
+
+	total_syms uint32
+
+	update_cycle         uint32
+	symbols_until_update uint32
+	total_count          uint32
+
+	max_update_interval       uint32
+	update_interval_slow_rate uint32
+
+	encoding bool
+
+	huff_ctx      *huffman_work
+	decode_tables *decoder_tables
+}
+
+func (m *quasi_adaptive_huffman_data_model) init(encoding bool, total_syms, max_update_interval, adapt_rate uint32) bool {
+	if total_syms == 0 || total_syms > cHuffmanMaxSupportedSyms {
+		return false
+	}
+
+	if max_update_interval == 0 {
+		max_update_interval = cDefaultMaxUpdateInterval
+	}
+	if adapt_rate == 0 {
+		adapt_rate = cDefaultUpdateSlowRate
+	}
+
+	m.encoding = encoding
+	m.total_syms = total_syms
+	m.max_update_interval = max_update_interval
+	m.update_interval_slow_rate = adapt_rate
+
+	m.sym_freq = make([]uint16, total_syms)
+	m.code_sizes = make([]uint8, total_syms)
+	if encoding {
+		m.codes = make([]uint16, total_syms)
+	} else if m.decode_tables == nil {
+		m.decode_tables = &decoder_tables{}
+	}
+
+	if m.huff_ctx == nil {
+		m.huff_ctx = &huffman_work{}
+	}
+
+	return m.reset()
+}
+
+// assign makes m a deep copy of other.
+func (m *quasi_adaptive_huffman_data_model) assign(other *quasi_adaptive_huffman_data_model) {
+	sym_freq, codes, code_sizes, huff_ctx, decode_tables := m.sym_freq, m.codes, m.code_sizes, m.huff_ctx, m.decode_tables
+	*m = *other
+
+	m.sym_freq = append(sym_freq[:0], other.sym_freq...)
+	m.codes = append(codes[:0], other.codes...)
+	m.code_sizes = append(code_sizes[:0], other.code_sizes...)
+	if huff_ctx == nil {
+		huff_ctx = &huffman_work{}
+	}
+	m.huff_ctx = huff_ctx
+
+	if other.decode_tables != nil {
+		if decode_tables == nil {
+			decode_tables = &decoder_tables{}
+		}
+		dt := *other.decode_tables
+		dt.lookup = append(decode_tables.lookup[:0], other.decode_tables.lookup...)
+		dt.sorted_syms = append(decode_tables.sorted_syms[:0], other.decode_tables.sorted_syms...)
+		*decode_tables = dt
+	}
+	m.decode_tables = decode_tables
+}
+
+func (m *quasi_adaptive_huffman_data_model) reset() bool {
+	if m.total_syms == 0 {
+		return true
+	}
+
+	for i := range m.sym_freq {
+		m.sym_freq[i] = 1
+	}
+
+	m.total_count = 0
+	m.update_cycle = m.total_syms
+	m.symbols_until_update = 0
+
+	return m.update_tables(int32(LZHAM_MIN(m.max_update_interval, cSymbolCodecMinUpdateCycle)))
+}
+
+func (m *quasi_adaptive_huffman_data_model) rescale() {
+	var total_freq uint32
+	for i := range m.sym_freq {
+		freq := (uint32(m.sym_freq[i]) + 1) >> 1
+		total_freq += freq
+		m.sym_freq[i] = uint16(freq)
+	}
+	m.total_count = total_freq
+}
+
+// reset_update_rate makes the table rebuild again after only a few symbols, so it adapts quickly to new statistics.
+func (m *quasi_adaptive_huffman_data_model) reset_update_rate() {
+	m.total_count += m.update_cycle - m.symbols_until_update
+
+	if m.total_count > m.total_syms {
+		m.rescale()
+	}
+
+	m.update_cycle = LZHAM_MIN(8, m.update_cycle)
+	m.symbols_until_update = m.update_cycle
+}
+
+// update_tables rebuilds the code sizes and codes from the current frequencies and schedules the next rebuild,
+// after force_update_cycle symbols if it's not negative.
+func (m *quasi_adaptive_huffman_data_model) update_tables(force_update_cycle int32) bool {
+	m.total_count += m.update_cycle
+	for m.total_count >= cSymbolCodecMaxTotalCount {
+		m.rescale()
+	}
+
+	if force_update_cycle >= 0 {
+		m.update_cycle = uint32(force_update_cycle)
+	} else {
+		m.update_cycle = (31 + m.update_cycle*LZHAM_MAX(32, m.update_interval_slow_rate)) >> 5
+		if m.update_cycle > m.max_update_interval {
+			m.update_cycle = m.max_update_interval
+		}
+	}
+	m.symbols_until_update = m.update_cycle
+
+	max_code_size, _, ok := generate_huffman_codes(m.huff_ctx, m.sym_freq, m.code_sizes)
+	if !ok {
+		return false
+	}
+
+	if max_code_size > cMaxExpectedHuffCodeSize {
+		if !limit_max_code_size(m.total_syms, m.code_sizes, cMaxExpectedHuffCodeSize) {
+			return false
+		}
+	}
+
+	if !m.encoding {
+		return generate_decoder_tables(m.total_syms, m.code_sizes, m.decode_tables)
+	}
+	return generate_codes(m.total_syms, m.code_sizes, m.codes)
+}
+
+func (m *quasi_adaptive_huffman_data_model) update(sym uint32) bool {
+	m.sym_freq[sym]++
+
+	m.symbols_until_update--
+	if m.symbols_until_update == 0 {
+		return m.update_tables(-1)
+	}
+	return true
+}
+
+func (m *quasi_adaptive_huffman_data_model) get_cost(sym uint32) bit_cost_t {
+	return convert_to_scaled_bitcost(uint32(m.code_sizes[sym]))
+}
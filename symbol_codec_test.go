@@ -0,0 +1,119 @@
+package lzham
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func Test_symbol_codec(t *testing.T) {
+	tests := []struct {
+		name       string
+		num_syms   int
+		arith_runs int
+		skew       float64
+	}{
+		{name: "bits only", num_syms: 5000},
+		{name: "one arith run", num_syms: 20000, arith_runs: 1, skew: 0.9},
+		{name: "several arith runs", num_syms: 20000, arith_runs: 7, skew: 0.99},
+		{name: "uniform bits", num_syms: 20000, arith_runs: 1, skew: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+
+			type op struct {
+				kind      int // 0 raw bits, 1 arith bit, 2 Huffman symbol, 3 arith init, 4 align
+				val, bits uint32
+			}
+			ops := make([]op, 0, tt.num_syms)
+			arith_active := false
+			for i := 0; i < tt.num_syms; i++ {
+				if tt.arith_runs > 0 && i%(tt.num_syms/tt.arith_runs) == 0 {
+					ops = append(ops, op{kind: 3})
+					arith_active = true
+				}
+				switch k := rng.Intn(8); {
+				case k < 3 && arith_active:
+					bit := uint32(0)
+					if rng.Float64() > tt.skew {
+						bit = 1
+					}
+					ops = append(ops, op{kind: 1, val: bit})
+				case k < 6:
+					// Skewed symbols, so the table gets codes of many sizes.
+					ops = append(ops, op{kind: 2, val: uint32(rng.ExpFloat64()*8) % 300})
+				case k < 7:
+					n := uint32(rng.Intn(33))
+					ops = append(ops, op{kind: 0, val: rng.Uint32() & uint32(uint64(1)<<n-1), bits: n})
+				default:
+					ops = append(ops, op{kind: 4})
+				}
+			}
+
+			var enc symbol_codec
+			var enc_bit adaptive_bit_model
+			var enc_table quasi_adaptive_huffman_data_model
+			enc_bit.clear()
+			if !enc_table.init(true, 300, 0, 0) || !enc.start_encoding(0) {
+				t.Fatal("init failed")
+			}
+			for _, o := range ops {
+				switch o.kind {
+				case 0:
+					enc.encode_bits(o.val, o.bits)
+				case 1:
+					enc.encode_bit(o.val, &enc_bit)
+				case 2:
+					if !enc.encode_sym(o.val, &enc_table) {
+						t.Fatal("encode_sym failed")
+					}
+				case 3:
+					enc.encode_arith_init()
+				case 4:
+					enc.encode_align_to_byte()
+				}
+			}
+			if !enc.stop_encoding() {
+				t.Fatal("stop_encoding failed")
+			}
+			buf := enc.get_encoding_buf()
+
+			var dec symbol_codec
+			var dec_bit adaptive_bit_model
+			var dec_table quasi_adaptive_huffman_data_model
+			dec_bit.clear()
+			if !dec_table.init(false, 300, 0, 0) || !dec.start_decoding(buf) {
+				t.Fatal("init failed")
+			}
+			for i, o := range ops {
+				var got uint32
+				switch o.kind {
+				case 0:
+					got = dec.decode_bits(o.bits)
+				case 1:
+					got = dec.decode_bit(&dec_bit)
+				case 2:
+					var ok bool
+					if got, ok = dec.decode_sym(&dec_table); !ok {
+						t.Fatalf("op %d: bad code", i)
+					}
+				case 3:
+					dec.decode_arith_init()
+				case 4:
+					dec.decode_align_to_byte()
+				}
+				if got != o.val {
+					t.Fatalf("op %d (kind %d): got %d, want %d", i, o.kind, got, o.val)
+				}
+			}
+
+			if dec.decode_overran() {
+				t.Errorf("decoding read past the %d byte buffer", len(buf))
+			}
+			if consumed := dec.stop_decoding(); consumed+1 < uint64(len(buf)) {
+				t.Errorf("decoding consumed %d of %d bytes", consumed, len(buf))
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package lzham
+
+import (
+	"runtime"
+	"sync"
+)
+
+// task_pool runs queued tasks on a fixed set of goroutines, which the compressor keeps for its whole lifetime so that
+// parsing a round or finding a block's matches doesn't start goroutines each time. They're started by the first task
+// queued and stopped by deinit, or once the pool is garbage collected.
+type task_pool struct {
+	num_threads uint32
+	workers     *task_pool_workers
+}
+
+// task_pool_workers is what the goroutines share. It's kept apart from task_pool so that the goroutines don't keep a
+// dropped pool reachable, and its finalizer can stop them.
+type task_pool_workers struct {
+	tasks   chan func()
+	pending sync.WaitGroup
+	running sync.WaitGroup
+}
+
+func (tp *task_pool) init(num_threads uint32) {
+	tp.deinit()
+	tp.num_threads = num_threads
+}
+
+// deinit waits for the tasks already queued, then stops the goroutines.
+func (tp *task_pool) deinit() {
+	if w := tp.workers; w != nil {
+		close(w.tasks)
+		w.running.Wait()
+		tp.workers = nil
+		runtime.SetFinalizer(tp, nil)
+	}
+}
+
+func (tp *task_pool) get_num_threads() uint32 {
+	return tp.num_threads
+}
+
+// queue_task runs task on one of the pool's goroutines.
+func (tp *task_pool) queue_task(task func()) {
+	if tp.workers == nil {
+		w := &task_pool_workers{tasks: make(chan func(), tp.num_threads)}
+		w.running.Add(int(tp.num_threads))
+		for i := uint32(0); i < tp.num_threads; i++ {
+			go w.run()
+		}
+		tp.workers = w
+		runtime.SetFinalizer(tp, (*task_pool).deinit)
+	}
+
+	tp.workers.pending.Add(1)
+	tp.workers.tasks <- task
+}
+
+// join waits for every task queued so far to finish.
+func (tp *task_pool) join() {
+	if tp.workers != nil {
+		tp.workers.pending.Wait()
+	}
+}
+
+func (w *task_pool_workers) run() {
+	defer w.running.Done()
+	for task := range w.tasks {
+		task()
+		w.pending.Done()
+	}
+}
@@ -0,0 +1,150 @@
+package lzham
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrWriterClosed = errors.New("write to closed writer")
+
+// cWriterBufSize is how much compressed data a Writer collects before writing it out.
+const cWriterBufSize = 64 << 10
+
+// Writer is an io.WriteCloser that compresses what's written to it into an LZHAM stream. Data is buffered into
+// blocks, so it's only written out as blocks fill up, on Flush and on Close.
+type Writer struct {
+	ctx    context.Context
+	w      io.Writer
+	state  *LZHAM_compress_state
+	params LZHAM_compress_params
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// NewWriter returns a Writer compressing to w with opts, or with DefaultCompressOptions if opts is nil.
+func NewWriter(w io.Writer, opts *CompressOptions) (*Writer, error) {
+	return NewWriterContext(context.Background(), w, opts)
+}
+
+// NewWriterContext is NewWriter for a Writer that gives up once ctx is done, with every call from then on
+// returning ctx.Err(). Compression stops part way through a block, parsing goroutines and all, so the stream is cut
+// short and the Writer can only be Reset.
+func NewWriterContext(ctx context.Context, w io.Writer, opts *CompressOptions) (*Writer, error) {
+	if opts == nil {
+		default_opts := DefaultCompressOptions()
+		opts = &default_opts
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	z := &Writer{ctx: ctx, w: w, params: opts.params(), buf: make([]byte, cWriterBufSize)}
+
+	state, err := LZHAM_lib_compress_init(&z.params)
+	if err != nil {
+		return nil, err
+	}
+	z.state = state
+	z.state.compressor.set_done(ctx.Done())
+
+	return z, nil
+}
+
+// Write compresses p, writing out any blocks it fills.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrWriterClosed
+	}
+	return z.compress(p, LZHAM_NO_FLUSH)
+}
+
+// Flush compresses and writes out everything written so far, ending with a sync block so a reader can decode all
+// of it without waiting for more of the stream.
+func (z *Writer) Flush() error {
+	if z.closed {
+		return ErrWriterClosed
+	}
+	_, err := z.compress(nil, LZHAM_SYNC_FLUSH)
+	return err
+}
+
+// Close finishes the stream and writes out the rest of it. It doesn't close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	_, err := z.compress(nil, LZHAM_FINISH)
+	return err
+}
+
+// Reset discards the Writer's state and makes it start a new stream to w with the same options and context,
+// keeping the allocated match finder.
+func (z *Writer) Reset(w io.Writer) error {
+	if _, err := LZHAM_lib_compress_reinit(&z.params, z.state); err != nil {
+		return err
+	}
+	z.w = w
+	z.err = nil
+	z.closed = false
+	return nil
+}
+
+// compress runs LZHAM_lib_compress on p until it's all taken and the compressor has no more output, writing out
+// each buffer of compressed data. Errors stick, as the stream can't go on after one.
+func (z *Writer) compress(p []byte, flush_type lzham_flush_t) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	var total int
+	for {
+		if err := z.ctx.Err(); err != nil {
+			z.err = err
+			return total, err
+		}
+
+		in_n, out_n, status := LZHAM_lib_compress(z.state, p, z.buf, flush_type)
+		p = p[in_n:]
+		total += int(in_n)
+
+		if out_n > 0 {
+			if _, err := z.w.Write(z.buf[:out_n]); err != nil {
+				z.err = err
+				return total, err
+			}
+		}
+
+		switch status {
+		case LZHAM_COMP_STATUS_HAS_MORE_OUTPUT:
+		case LZHAM_COMP_STATUS_NEEDS_MORE_INPUT, LZHAM_COMP_STATUS_NOT_FINISHED, LZHAM_COMP_STATUS_SUCCESS:
+			return total, nil
+		default:
+			// Canceling shows up as a failed block.
+			if z.err = z.ctx.Err(); z.err == nil {
+				z.err = compress_status_error(status)
+			}
+			return total, z.err
+		}
+	}
+}
+
+// CompressContext compresses src into a whole stream with opts, or with DefaultCompressOptions if opts is nil. It
+// returns ctx.Err() if ctx is done first.
+func CompressContext(ctx context.Context, src []byte, opts *CompressOptions) ([]byte, error) {
+	var comp bytes.Buffer
+	z, err := NewWriterContext(ctx, &comp, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := z.Write(src); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return comp.Bytes(), nil
+}
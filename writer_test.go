@@ -0,0 +1,263 @@
+package lzham
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// decompress_test_options decompresses a whole stream written with opts through LZHAM_lib_decompress_memory.
+func decompress_test_options(tb testing.TB, opts *CompressOptions, comp []byte, size int) []byte {
+	decomp_opts := DecompressOptions{DictSizeLog2: opts.DictSizeLog2, TableUpdateRate: opts.TableUpdateRate, SeedBytes: opts.SeedBytes}
+	if opts.Flags&LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM != 0 {
+		decomp_opts.Flags = LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM
+	}
+	params := decomp_opts.params()
+
+	out := make([]byte, size)
+	n, _, status := LZHAM_lib_decompress_memory(&params, out, comp)
+	if status != LZHAM_DECOMP_STATUS_SUCCESS {
+		tb.Fatalf("decompress status %d", status)
+	}
+	return out[:n]
+}
+
+func TestWriter(t *testing.T) {
+	data := append(gen_test_log(60, 300<<10), gen_test_records(61, 300<<10)...)
+
+	tests := []struct {
+		name       string
+		opts       *CompressOptions
+		write_size int
+	}{
+		{name: "defaults", write_size: 4096},
+		{name: "one write", opts: &CompressOptions{DictSizeLog2: 16, Level: LZHAM_COMP_LEVEL_FASTEST}, write_size: len(data)},
+		{name: "small writes", opts: &CompressOptions{DictSizeLog2: 18, Level: LZHAM_COMP_LEVEL_BETTER}, write_size: 7},
+		{name: "zlib with seed", opts: &CompressOptions{DictSizeLog2: 16, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM, SeedBytes: data[:1000]}, write_size: 100000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var comp bytes.Buffer
+			z, err := NewWriter(&comp, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ofs := 0; ofs < len(data); ofs += tt.write_size {
+				end := ofs + tt.write_size
+				if end > len(data) {
+					end = len(data)
+				}
+				if n, err := z.Write(data[ofs:end]); n != end-ofs || err != nil {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+			if err := z.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := tt.opts
+			if opts == nil {
+				default_opts := DefaultCompressOptions()
+				opts = &default_opts
+			}
+			if got := decompress_test_options(t, opts, comp.Bytes(), len(data)); !bytes.Equal(got, data) {
+				t.Fatalf("decoded %d bytes, which don't match the %d input bytes", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestWriter_Flush(t *testing.T) {
+	data := gen_test_log(62, 50<<10)
+
+	var comp bytes.Buffer
+	z, err := NewWriter(&comp, &CompressOptions{DictSizeLog2: 16, Level: LZHAM_COMP_LEVEL_DEFAULT})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d lzdecompressor
+	if !d.init(16, 0, 0, nil) {
+		t.Fatal("init failed")
+	}
+
+	var got []byte
+	var ofs uint64
+	written := 0
+	for _, end := range []int{100, 101, 5000, 30000, len(data)} {
+		if _, err := z.Write(data[written:end]); err != nil {
+			t.Fatal(err)
+		}
+		written = end
+		if err := z.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		for ofs < uint64(comp.Len()) {
+			n, block_out, status := d.decompress_block(comp.Bytes()[ofs:], got)
+			if status != LZHAM_DECOMP_STATUS_NOT_FINISHED {
+				t.Fatalf("status %d at %d", status, ofs)
+			}
+			got, ofs = block_out, ofs+n
+		}
+		if !bytes.Equal(got, data[:end]) {
+			t.Fatalf("decoded %d bytes after flushing %d", len(got), end)
+		}
+	}
+}
+
+func TestWriter_Reset(t *testing.T) {
+	data := gen_test_records(63, 100<<10)
+	opts := &CompressOptions{DictSizeLog2: 17, Level: LZHAM_COMP_LEVEL_DEFAULT, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM}
+
+	var want bytes.Buffer
+	z, err := NewWriter(&want, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stream left half written, or failed, doesn't carry over.
+	if err := z.Reset(failing_writer{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Write(gen_test_log(64, 300<<10)); err == nil {
+		t.Fatal("Write() to a failing writer succeeded")
+	}
+	if err := z.Flush(); err == nil {
+		t.Fatal("Flush() after an error succeeded")
+	}
+
+	for i := 0; i < 2; i++ {
+		var got bytes.Buffer
+		if err := z.Reset(&got); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := z.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("reset %d: %d bytes differ from the %d of a new writer", i, got.Len(), want.Len())
+		}
+	}
+}
+
+func TestWriter_Close(t *testing.T) {
+	var comp bytes.Buffer
+	z, err := NewWriter(&comp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	if _, err := z.Write([]byte{1}); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("Write() after Close() = %v", err)
+	}
+	if err := z.Flush(); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("Flush() after Close() = %v", err)
+	}
+
+	opts := DefaultCompressOptions()
+	if got := decompress_test_options(t, &opts, comp.Bytes(), 0); len(got) != 0 {
+		t.Errorf("decoded %d bytes", len(got))
+	}
+
+	if _, err := NewWriter(&comp, &CompressOptions{DictSizeLog2: 40}); !errors.Is(err, ErrInvalidDictSizeLog2) {
+		t.Errorf("NewWriter() with a bad dictionary = %v", err)
+	}
+}
+
+type failing_writer struct{}
+
+func (failing_writer) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriter_context(t *testing.T) {
+	data := append(gen_test_log(62, 2<<20), gen_test_records(63, 2<<20)...)
+
+	tests := []struct {
+		name  string
+		opts  CompressOptions
+		delay time.Duration
+	}{
+		{name: "canceled before", opts: DefaultCompressOptions()},
+		{name: "uber", opts: CompressOptions{DictSizeLog2: 22, Level: LZHAM_COMP_LEVEL_UBER, MaxHelperThreads: -1}, delay: 50 * time.Millisecond},
+		{name: "extreme parsing", opts: CompressOptions{DictSizeLog2: 22, Level: LZHAM_COMP_LEVEL_UBER, Flags: LZHAM_COMP_FLAG_EXTREME_PARSING, MaxHelperThreads: 4}, delay: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			num_goroutines := runtime.NumGoroutine()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.delay == 0 {
+				cancel()
+			} else {
+				time.AfterFunc(tt.delay, cancel)
+			}
+
+			var comp bytes.Buffer
+			z, err := NewWriterContext(ctx, &comp, &tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			if _, err := z.Write(data); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Write() = %v, want context.Canceled", err)
+			}
+			if elapsed := time.Since(start); elapsed > tt.delay+time.Second {
+				t.Errorf("Write() took %v to notice the cancel", elapsed)
+			}
+			if err := z.Close(); !errors.Is(err, context.Canceled) {
+				t.Errorf("Close() = %v, want context.Canceled", err)
+			}
+
+			// The parsing goroutines are gone by the time Write returns.
+			if n := runtime.NumGoroutine(); n > num_goroutines {
+				t.Errorf("%d goroutines left running, from %d", n, num_goroutines)
+			}
+		})
+	}
+}
+
+func TestCompressContext(t *testing.T) {
+	data := gen_test_log(64, 200<<10)
+	opts := CompressOptions{DictSizeLog2: 18, Flags: LZHAM_COMP_FLAG_WRITE_ZLIB_STREAM}
+
+	comp, err := CompressContext(context.Background(), data, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecompressContext(context.Background(), comp, &DecompressOptions{DictSizeLog2: 18, Flags: LZHAM_DECOMP_FLAG_READ_ZLIB_STREAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed %d bytes, which don't match", len(out))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CompressContext(ctx, data, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("CompressContext() = %v, want context.Canceled", err)
+	}
+	if _, err := DecompressContext(ctx, comp, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecompressContext() = %v, want context.Canceled", err)
+	}
+}